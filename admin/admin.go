@@ -2,10 +2,15 @@ package admin
 
 import (
 	"fmt"
+	"html"
 	"net/http"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"mu/app"
+	"mu/audit"
 	"mu/auth"
 )
 
@@ -19,7 +24,7 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	acc, err := auth.GetAccount(sess.Account)
-	if err != nil || !acc.Admin {
+	if err != nil || !acc.Can(auth.PermUsers) {
 		http.Error(w, "Forbidden - Admin access required", http.StatusForbidden)
 		return
 	}
@@ -46,17 +51,125 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		switch action {
-		case "toggle_admin":
-			targetUser.Admin = !targetUser.Admin
-			auth.UpdateAccount(targetUser)
+		case "set_role":
+			if userID == acc.ID {
+				http.Error(w, "You cannot change your own role", http.StatusBadRequest)
+				return
+			}
+			before, role := targetUser.GetRole(), auth.Role(r.FormValue("role"))
+			if err := auth.SetRole(userID, role); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			auth.Audit(r, audit.Event{
+				Action: "account.role",
+				Target: userID,
+				Before: map[string]any{"role": before},
+				After:  map[string]any{"role": role},
+			})
 		case "toggle_member":
 			targetUser.Member = !targetUser.Member
 			auth.UpdateAccount(targetUser)
+			auth.Audit(r, audit.Event{
+				Action: "account.member",
+				Target: userID,
+				Before: map[string]any{"member": !targetUser.Member},
+				After:  map[string]any{"member": targetUser.Member},
+			})
+		case "reset_2fa":
+			auth.ResetTOTP(userID)
+			fmt.Printf("Two-factor authentication for %s reset by %s\n", userID, acc.ID)
+			auth.Audit(r, audit.Event{Action: "auth.reset_2fa", Target: userID})
+		case "unlock":
+			auth.Unlock(userID)
+			auth.Audit(r, audit.Event{Action: "auth.unlock", Target: userID})
+		case "suspend":
+			if userID == acc.ID {
+				http.Error(w, "You cannot suspend yourself", http.StatusBadRequest)
+				return
+			}
+			days, _ := strconv.Atoi(r.FormValue("days"))
+			reason := strings.TrimSpace(r.FormValue("reason"))
+			s, err := auth.Suspend(userID, acc.ID, reason, time.Duration(days)*24*time.Hour)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			action := "account.suspend"
+			if s.Ban() {
+				action = "account.ban"
+			}
+			auth.Audit(r, audit.Event{
+				Action: action,
+				Target: userID,
+				Before: suspensionState(targetUser.Suspension),
+				After:  suspensionState(s),
+			})
+		case "unsuspend":
+			if err := auth.Unsuspend(userID); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			auth.Audit(r, audit.Event{
+				Action: "account.unsuspend",
+				Target: userID,
+				Before: suspensionState(targetUser.Suspension),
+			})
+		case "reset_code":
+			code, rc, err := auth.CreateResetCode(userID, acc.ID, auth.ResetCodeTTL)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			auth.Audit(r, audit.Event{
+				Action: "auth.reset_code",
+				Target: userID,
+				Detail: "expires " + rc.Expires.UTC().Format(time.RFC3339),
+			})
+			// the code is shown once, so render it rather than redirect
+			w.Header().Set("Cache-Control", "no-store")
+			w.Write([]byte(app.RenderHTMLForRequest("Admin", "Password reset code", resetCodePage(userID, code, rc), r)))
+			return
+		case "approve":
+			if err := auth.ApproveAccount(userID); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			auth.Audit(r, audit.Event{
+				Action: "account.approve",
+				Target: userID,
+				Before: map[string]any{"pending": true},
+				After:  map[string]any{"pending": false},
+			})
+		case "reject":
+			if !targetUser.Pending {
+				http.Error(w, "Account is not awaiting approval", http.StatusBadRequest)
+				return
+			}
+			if err := auth.DeleteAccount(userID); err != nil {
+				http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+				return
+			}
+			auth.Audit(r, audit.Event{
+				Action: "account.reject",
+				Target: userID,
+				Before: map[string]any{"name": targetUser.Name, "pending": true, "created": targetUser.Created},
+			})
 		case "delete":
 			if err := auth.DeleteAccount(userID); err != nil {
 				http.Error(w, "Failed to delete user", http.StatusInternalServerError)
 				return
 			}
+			auth.Audit(r, audit.Event{
+				Action: "account.delete",
+				Target: userID,
+				Before: map[string]any{
+					"name":    targetUser.Name,
+					"role":    targetUser.GetRole(),
+					"member":  targetUser.Member,
+					"created": targetUser.Created,
+				},
+			})
 		}
 
 		http.Redirect(w, r, "/admin", http.StatusSeeOther)
@@ -71,7 +184,7 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 		return users[i].Created.After(users[j].Created)
 	})
 
-	content := `<h2>User Management</h2>
+	content := pendingAccounts() + `<h2>User Management</h2>
 	<p>Total Users: ` + fmt.Sprintf("%d", len(users)) + `</p>
 	<style>
 		.admin-table { width: 100%; border-collapse: collapse; }
@@ -91,7 +204,7 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 				<th>Username</th>
 				<th>Name</th>
 				<th class="created-col">Created</th>
-				<th class="center">Admin</th>
+				<th class="center">Role</th>
 				<th class="center">Member</th>
 				<th class="center">Actions</th>
 			</tr>
@@ -111,17 +224,56 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 			</form>`
 		}
 
+		// Reset a lost second factor
+		resetButton := ""
+		if auth.TOTPEnabled(user.ID) {
+			resetButton = `<form method="POST" style="display: inline;" onsubmit="return confirm('Turn off two-factor authentication for ` + user.ID + `?');">
+				<input type="hidden" name="action" value="reset_2fa">
+				<input type="hidden" name="user_id" value="` + user.ID + `">
+				<button type="submit" class="delete-btn" style="padding: 5px 10px; border-radius: 3px; cursor: pointer;">Reset 2FA</button>
+			</form> `
+		}
+
+		// Issue a one-time code to set a new password
+		resetButton += `<form method="POST" style="display: inline;" onsubmit="return confirm('Issue a password reset code for ` + user.ID + `?');">
+				<input type="hidden" name="action" value="reset_code">
+				<input type="hidden" name="user_id" value="` + user.ID + `">
+				<button type="submit" class="delete-btn" style="padding: 5px 10px; border-radius: 3px; cursor: pointer;">Reset password</button>
+			</form> `
+
+		// Suspend or ban, or lift a suspension in force
+		suspendButton := ""
+		if user.Suspended() != nil {
+			suspendButton = `<form method="POST" style="display: inline;">
+				<input type="hidden" name="action" value="unsuspend">
+				<input type="hidden" name="user_id" value="` + user.ID + `">
+				<button type="submit" class="delete-btn" style="padding: 5px 10px; border-radius: 3px; cursor: pointer;">Lift</button>
+			</form> `
+		} else if user.ID != acc.ID {
+			suspendButton = `<details class="suspend-form">
+				<summary>Suspend</summary>
+				<form method="POST">
+					<input type="hidden" name="action" value="suspend">
+					<input type="hidden" name="user_id" value="` + user.ID + `">
+					<input type="text" name="reason" placeholder="Reason, shown to the user" required>
+					<select name="days">
+						<option value="1">1 day</option>
+						<option value="7">7 days</option>
+						<option value="30">30 days</option>
+						<option value="0">Ban</option>
+					</select>
+					<button type="submit">Suspend</button>
+				</form>
+			</details> `
+		}
+
 		content += `
 			<tr>
 				<td><strong><a href="/@` + user.ID + `" style="color: inherit; text-decoration: none;">` + user.ID + `</a></strong></td>
-				<td>` + user.Name + `</td>
+				<td>` + user.Name + suspensionNote(user) + `</td>
 				<td class="created-col">` + createdStr + `</td>
 				<td class="center">
-					<form method="POST" style="display: inline;">
-						<input type="hidden" name="action" value="toggle_admin">
-						<input type="hidden" name="user_id" value="` + user.ID + `">
-						<input type="checkbox" ` + func() string { if user.Admin { return "checked" }; return "" }() + ` onchange="this.form.submit()" style="cursor: pointer; width: 18px; height: 18px;">
-					</form>
+					` + roleSelect(user, user.ID == acc.ID) + `
 				</td>
 				<td class="center">
 					<form method="POST" style="display: inline;">
@@ -131,7 +283,7 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 					</form>
 				</td>
 				<td class="center">
-					` + deleteButton + `
+					` + suspendButton + resetButton + deleteButton + `
 				</td>
 			</tr>`
 	}
@@ -139,9 +291,156 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 	content += `
 		</tbody>
 	</table>
+	` + lockedAccounts() + `
+	` + roleLegend() + `
 	<br>
-	<p><a href="/moderate">Moderation Queue</a></p>`
+	<p><a href="/moderate">Moderation Queue</a> · <a href="/admin/audit">Audit Log</a></p>
+	<form method="POST" action="/admin/backup">
+		<button type="submit">Download backup</button>
+	</form>`
 
 	html := app.RenderHTMLForRequest("Admin", "User Management", content, r)
 	w.Write([]byte(html))
 }
+
+// suspensionNote describes a suspension in force on an account for the
+// user list.
+func suspensionNote(user *auth.Account) string {
+	err := user.Suspended()
+	if err == nil {
+		return ""
+	}
+	status := "Banned"
+	if !err.Ban() {
+		status = "Suspended until " + err.Until.Local().Format("2006-01-02 15:04")
+	}
+	return fmt.Sprintf(`<br><small class="suspended">%s by %s: %s</small>`,
+		status, html.EscapeString(err.By), html.EscapeString(err.Reason))
+}
+
+// suspensionState is a suspension as recorded in the audit log.
+func suspensionState(s *auth.Suspension) any {
+	if !s.Active() {
+		return nil
+	}
+	state := map[string]any{"reason": s.Reason, "by": s.By}
+	if !s.Ban() {
+		state["until"] = s.Until
+	}
+	return state
+}
+
+// resetCodePage shows a reset code just issued for an account.
+func resetCodePage(userID, code string, rc *auth.ResetCode) string {
+	return fmt.Sprintf(`<h2>Password reset code for %s</h2>
+	<p>Give this code to %s privately. It can set a new password once, at <a href="/reset">/reset</a>, until %s. It will not be shown again, and issuing another code replaces it.</p>
+	<p><code style="font-size: 1.4em;">%s</code></p>
+	<p>Resetting the password logs %s out everywhere and revokes their API tokens.</p>
+	<p><a href="/admin">Back to admin</a></p>`,
+		html.EscapeString(userID),
+		html.EscapeString(userID),
+		rc.Expires.Local().Format("2006-01-02 15:04"),
+		code,
+		html.EscapeString(userID))
+}
+
+// roleSelect renders the role picker for an account. Admins cannot change
+// their own role, so they cannot lock themselves out.
+func roleSelect(user *auth.Account, self bool) string {
+	disabled := ""
+	if self {
+		disabled = " disabled"
+	}
+	options := ""
+	for _, role := range auth.Roles {
+		selected := ""
+		if role == user.GetRole() {
+			selected = " selected"
+		}
+		options += fmt.Sprintf(`<option value="%s"%s>%s</option>`, role, selected, role)
+	}
+	return fmt.Sprintf(`<form method="POST" style="display: inline;">
+						<input type="hidden" name="action" value="set_role">
+						<input type="hidden" name="user_id" value="%s">
+						<select name="role" onchange="this.form.submit()"%s>%s</select>
+					</form>`, user.ID, disabled, options)
+}
+
+// pendingAccounts lists signups awaiting approval, with buttons to approve
+// or reject each.
+func pendingAccounts() string {
+	pending := auth.PendingAccounts()
+	if len(pending) == 0 {
+		return ""
+	}
+	rows := ""
+	for _, acc := range pending {
+		rows += fmt.Sprintf(`<tr>
+			<td><strong>%s</strong></td>
+			<td>%s</td>
+			<td>%s</td>
+			<td class="center"><form method="POST" style="display: inline;">
+				<input type="hidden" name="action" value="approve">
+				<input type="hidden" name="user_id" value="%s">
+				<button type="submit">Approve</button>
+			</form> <form method="POST" style="display: inline;" onsubmit="return confirm('Reject and delete %s?');">
+				<input type="hidden" name="action" value="reject">
+				<input type="hidden" name="user_id" value="%s">
+				<button type="submit" class="delete-btn">Reject</button>
+			</form></td>
+		</tr>`, acc.ID, html.EscapeString(acc.Name), acc.Created.Format("2006-01-02 15:04"), acc.ID, acc.ID, acc.ID)
+	}
+	return `<h2>Awaiting Approval</h2>
+	<p>These accounts signed up while signups need approval. They cannot post or chat until approved; rejecting deletes them.</p>
+	<table class="admin-table">
+		<thead><tr><th>Username</th><th>Name</th><th>Signed up</th><th class="center">Actions</th></tr></thead>
+		<tbody>` + rows + `</tbody>
+	</table>
+	`
+}
+
+// lockedAccounts lists accounts locked after failed logins, with a button
+// to unlock each.
+func lockedAccounts() string {
+	locked := auth.ListLockouts()
+	if len(locked) == 0 {
+		return ""
+	}
+	rows := ""
+	for _, l := range locked {
+		rows += fmt.Sprintf(`<tr>
+			<td><strong>%s</strong></td>
+			<td class="center">%d</td>
+			<td>%s</td>
+			<td class="center"><form method="POST" style="display: inline;">
+				<input type="hidden" name="action" value="unlock">
+				<input type="hidden" name="user_id" value="%s">
+				<button type="submit">Unlock</button>
+			</form></td>
+		</tr>`, l.Account, l.Failures, l.Until.Format("2006-01-02 15:04"), l.Account)
+	}
+	return `<h3>Locked Accounts</h3>
+	<p>Locked after repeated failed logins. Each further failure doubles the lockout.</p>
+	<table class="admin-table">
+		<thead><tr><th>Username</th><th class="center">Failures</th><th>Locked until</th><th class="center">Actions</th></tr></thead>
+		<tbody>` + rows + `</tbody>
+	</table>`
+}
+
+// roleLegend lists what each role may do.
+func roleLegend() string {
+	rows := ""
+	for _, role := range auth.Roles {
+		perms := make([]string, 0, len(role.Permissions()))
+		for _, p := range role.Permissions() {
+			perms = append(perms, string(p))
+		}
+		rows += fmt.Sprintf(`<tr><td><strong>%s</strong></td><td>%s</td></tr>`, role, strings.Join(perms, ", "))
+	}
+	return `<h3>Roles</h3>
+	<p>Members with a paid membership act as at least <em>member</em>. Visitors who are not signed in are guests.</p>
+	<table class="admin-table">
+		<thead><tr><th>Role</th><th>Permissions</th></tr></thead>
+		<tbody>` + rows + `</tbody>
+	</table>`
+}
@@ -0,0 +1,151 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"mu/app"
+	"mu/audit"
+	"mu/auth"
+)
+
+// auditPageLimit is how many events the audit page shows at once; the
+// export has no limit.
+const auditPageLimit = 200
+
+// AuditHandler shows the audit log with filters, or exports the matching
+// events as JSON lines with ?format=jsonl.
+func AuditHandler(w http.ResponseWriter, r *http.Request) {
+	sess, err := auth.GetSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	acc, err := auth.GetAccount(sess.Account)
+	if err != nil || !acc.Can(auth.PermUsers) {
+		http.Error(w, "Forbidden - Admin access required", http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	f := audit.Filter{
+		Actor:  strings.TrimSpace(q.Get("actor")),
+		Action: strings.TrimSpace(q.Get("action")),
+		Target: strings.TrimSpace(q.Get("target")),
+		Limit:  auditPageLimit,
+	}
+	if t, err := time.Parse("2006-01-02", q.Get("since")); err == nil {
+		f.Since = t
+	}
+	if t, err := time.Parse("2006-01-02", q.Get("until")); err == nil {
+		f.Until = t.AddDate(0, 0, 1) // through the end of that day
+	}
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 {
+		f.Limit = n
+	}
+
+	if q.Get("format") == "jsonl" {
+		name := "mu-audit-" + time.Now().UTC().Format("20060102-150405") + ".jsonl"
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		w.Header().Set("Cache-Control", "no-store")
+		if err := audit.Export(w, f); err != nil {
+			fmt.Printf("Audit export by %s failed: %v\n", acc.ID, err)
+		}
+		return
+	}
+
+	events, err := audit.Query(f)
+	if err != nil {
+		http.Error(w, "Failed to read audit log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows := ""
+	for _, e := range events {
+		rows += fmt.Sprintf(`<tr>
+			<td>%s</td>
+			<td>%s</td>
+			<td><code>%s</code></td>
+			<td>%s</td>
+			<td>%s</td>
+			<td>%s</td>
+		</tr>`,
+			e.Time.Local().Format("2006-01-02 15:04:05"),
+			auditLink("actor", e.Actor),
+			html.EscapeString(e.Action),
+			auditLink("target", e.Target),
+			html.EscapeString(e.IP),
+			auditChange(e))
+	}
+	if rows == "" {
+		rows = `<tr><td colspan="6">No matching events.</td></tr>`
+	}
+
+	export := url.Values{}
+	for _, k := range []string{"actor", "action", "target", "since", "until"} {
+		if v := q.Get(k); v != "" {
+			export.Set(k, v)
+		}
+	}
+	export.Set("format", "jsonl")
+
+	content := fmt.Sprintf(`<h2>Audit Log</h2>
+	<p>Account, moderation, settings and security changes, newest first. Showing up to %d events.</p>
+	<form method="GET" action="/admin/audit" class="audit-filter">
+		<input type="text" name="actor" placeholder="Actor" value="%s">
+		<input type="text" name="action" placeholder="Action, e.g. account." value="%s">
+		<input type="text" name="target" placeholder="Target" value="%s">
+		<input type="date" name="since" value="%s" title="From">
+		<input type="date" name="until" value="%s" title="Until">
+		<button type="submit">Filter</button>
+		<a href="/admin/audit">Clear</a> · <a href="/admin/audit?%s">Export JSONL</a>
+	</form>
+	<table class="admin-table">
+		<thead><tr><th>Time</th><th>Actor</th><th>Action</th><th>Target</th><th>IP</th><th>Change</th></tr></thead>
+		<tbody>%s</tbody>
+	</table>
+	<p><a href="/admin">Back to admin</a></p>`,
+		f.Limit,
+		html.EscapeString(f.Actor),
+		html.EscapeString(f.Action),
+		html.EscapeString(f.Target),
+		html.EscapeString(q.Get("since")),
+		html.EscapeString(q.Get("until")),
+		export.Encode(),
+		rows)
+
+	w.Write([]byte(app.RenderHTMLForRequest("Audit Log", "Administrative changes", content, r)))
+}
+
+// auditLink links a value to the audit log filtered by it.
+func auditLink(field, value string) string {
+	if value == "" {
+		return ""
+	}
+	return fmt.Sprintf(`<a href="/admin/audit?%s=%s">%s</a>`, field, url.QueryEscape(value), html.EscapeString(value))
+}
+
+// auditChange renders an event's detail and before and after states.
+func auditChange(e audit.Event) string {
+	var parts []string
+	if e.Detail != "" {
+		parts = append(parts, html.EscapeString(e.Detail))
+	}
+	if e.Before != nil {
+		b, _ := json.Marshal(e.Before)
+		parts = append(parts, "<code>"+html.EscapeString(string(b))+"</code>")
+	}
+	if e.After != nil {
+		b, _ := json.Marshal(e.After)
+		parts = append(parts, "→ <code>"+html.EscapeString(string(b))+"</code>")
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,45 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"mu/audit"
+	"mu/auth"
+	"mu/data"
+)
+
+// BackupHandler streams a backup of the data store to an admin. It only
+// accepts POST so a backup is never taken by a stray link or prefetch.
+func BackupHandler(w http.ResponseWriter, r *http.Request) {
+	sess, err := auth.GetSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	acc, err := auth.GetAccount(sess.Account)
+	if err != nil || !acc.Can(auth.PermBackup) {
+		http.Error(w, "Forbidden - Admin access required", http.StatusForbidden)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := "mu-backup-" + time.Now().UTC().Format("20060102-150405") + ".tar.gz"
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	m, err := data.Backup(w)
+	if err != nil {
+		fmt.Printf("Backup by %s failed: %v\n", acc.ID, err)
+		return
+	}
+	fmt.Printf("Backup of %d files downloaded by %s\n", len(m.Files), acc.ID)
+	auth.Audit(r, audit.Event{Action: "data.backup", Detail: fmt.Sprintf("%d files", len(m.Files))})
+}
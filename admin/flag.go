@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"mu/app"
+	"mu/audit"
 	"mu/auth"
 	"mu/data"
 )
@@ -75,6 +76,13 @@ func logModerationEvent(msg string) {
 // ============================================
 
 func Load() {
+	// Include flags raised in account exports and closures
+	auth.RegisterAccountData(auth.AccountData{
+		Name:   "flags",
+		Export: exportFlags,
+		Remove: removeFlagger,
+	})
+
 	b, err := data.LoadFile("flags.json")
 	if err != nil {
 		return
@@ -86,6 +94,54 @@ func Load() {
 	json.Unmarshal(b, &flags)
 }
 
+// flaggedBy returns where acc is among an item's flaggers, who are
+// recorded by display name, or -1.
+func flaggedBy(item *FlaggedItem, acc *auth.Account) int {
+	for i, name := range item.FlaggedBy {
+		if name == acc.Name {
+			return i
+		}
+	}
+	return -1
+}
+
+// exportFlags lists the content an account has flagged for its data export.
+func exportFlags(acc *auth.Account) any {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	list := []map[string]any{}
+	for _, item := range flags {
+		if flaggedBy(item, acc) >= 0 {
+			list = append(list, map[string]any{
+				"content_type": item.ContentType,
+				"content_id":   item.ContentID,
+				"flag_count":   item.FlagCount,
+				"hidden":       item.Flagged,
+			})
+		}
+	}
+	return list
+}
+
+// removeFlagger takes a closed account's name off the flags it raised. The
+// flags themselves stay so moderation is not undone.
+func removeFlagger(acc *auth.Account, anonymize bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	changed := false
+	for _, item := range flags {
+		if i := flaggedBy(item, acc); i >= 0 {
+			item.FlaggedBy[i] = "deleted account"
+			changed = true
+		}
+	}
+	if changed {
+		saveUnlocked()
+	}
+}
+
 func saveUnlocked() error {
 	// Caller must hold mutex lock
 	return data.SaveJSON("flags.json", flags)
@@ -307,6 +363,10 @@ func FlagHandler(w http.ResponseWriter, r *http.Request) {
 	if err == nil {
 		acc, err := auth.GetAccount(sess.Account)
 		if err == nil {
+			if err := acc.Refusal(); err != nil {
+				http.Error(w, "Forbidden - "+err.Error(), http.StatusForbidden)
+				return
+			}
 			flagger = acc.Name
 		}
 	}
@@ -342,15 +402,8 @@ func ModerateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user is admin
-	isAdmin := false
-	sess, err := auth.GetSession(r)
-	if err == nil {
-		acc, err := auth.GetAccount(sess.Account)
-		if err == nil && acc.Admin {
-			isAdmin = true
-		}
-	}
+	// Check if user can moderate
+	isAdmin := auth.Can(r, auth.PermModerate)
 
 	flaggedItems := GetAll()
 
@@ -488,31 +541,53 @@ func handleModeration(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user is admin
-	sess, err := auth.GetSession(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// Check if user can moderate
+	if !auth.Can(r, auth.PermModerate) {
+		http.Error(w, "Moderator access required", http.StatusForbidden)
 		return
 	}
 
-	acc, err := auth.GetAccount(sess.Account)
-	if err != nil || !acc.Admin {
-		http.Error(w, "Admin access required", http.StatusForbidden)
-		return
-	}
+	before := moderationState(contentType, contentID)
 
+	var err error
 	switch action {
 	case "approve":
-		Approve(contentType, contentID)
-		http.Redirect(w, r, "/moderate", http.StatusSeeOther)
-
+		err = Approve(contentType, contentID)
 	case "delete":
-		Delete(contentType, contentID)
-		http.Redirect(w, r, "/moderate", http.StatusSeeOther)
-
+		err = Delete(contentType, contentID)
 	default:
 		http.Error(w, "Invalid action", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to "+action+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	auth.Audit(r, audit.Event{
+		Action: "content." + action,
+		Target: contentType + ":" + contentID,
+		Before: before,
+	})
+	http.Redirect(w, r, "/moderate", http.StatusSeeOther)
+}
+
+// moderationState captures flagged content and its flags for the audit log
+// before a moderator acts on it.
+func moderationState(contentType, contentID string) map[string]any {
+	state := map[string]any{}
+	if item := GetItem(contentType, contentID); item != nil {
+		mutex.RLock()
+		state["flag_count"] = item.FlagCount
+		state["flagged_by"] = append([]string(nil), item.FlaggedBy...)
+		mutex.RUnlock()
+	}
+	if deleter, ok := deleters[contentType]; ok {
+		if content := deleter.Get(contentID); content != nil {
+			state["content"] = content
+		}
 	}
+	return state
 }
 
 // ============================================
@@ -120,6 +120,55 @@ var Endpoints = []*Endpoint{{
 			},
 		},
 	},
+}, {
+	Name:        "Search",
+	Path:        "/search",
+	Method:      "GET",
+	Description: "Search news, videos, markets and posts. Send Accept: application/json to get JSON instead of the search page",
+	Params: []*Param{
+		{
+			Name:        "q",
+			Value:       "string",
+			Description: "Search query, passed in the URL",
+		},
+		{
+			Name:        "type",
+			Value:       "string",
+			Description: "Optional type to restrict to: news, video, market, post or user",
+		},
+		{
+			Name:        "page",
+			Value:       "number",
+			Description: "Page of results, starting at 1; 10 results per page",
+		},
+	},
+	Response: []*Value{
+		{
+			Type: "JSON",
+			Params: []*Param{
+				{
+					Name:        "results",
+					Value:       "array",
+					Description: "Matches ranked by relevance; [{'id', 'type', 'title', 'snippet', 'url', 'score', 'published'}]",
+				},
+				{
+					Name:        "total",
+					Value:       "number",
+					Description: "Matches across all pages for the selected type",
+				},
+				{
+					Name:        "pages",
+					Value:       "number",
+					Description: "Number of pages",
+				},
+				{
+					Name:        "counts",
+					Value:       "object",
+					Description: "Matches per type, with \"\" for all types",
+				},
+			},
+		},
+	},
 }}
 
 // Register an endpoint
@@ -131,6 +180,18 @@ func Register(ep *Endpoint) {
 func Markdown() string {
 	var data string
 
+	data += fmt.Sprintln("## Authentication")
+	data += fmt.Sprintln()
+	data += fmt.Sprintln("Create a personal API token under Account and send it with each request to act as your account. Tokens are scoped to read, chat or posts and expire; revoke them from the same page.")
+	data += fmt.Sprintln()
+	data += fmt.Sprintf("```%s: <token>```", TokenHeader)
+	data += fmt.Sprintln()
+	data += fmt.Sprintln()
+	data += fmt.Sprintln("or `Authorization: Bearer <token>`.")
+	data += fmt.Sprintln()
+	data += fmt.Sprintln("Requests made with a session cookie must instead send the page's CSRF token, found in its `csrf-token` meta tag, as an `X-CSRF-Token` header on POSTs.")
+	data += fmt.Sprintln()
+
 	for _, endpoint := range Endpoints {
 		data += "## " + endpoint.Name
 		data += fmt.Sprintln()
@@ -144,6 +205,8 @@ func Markdown() string {
 		data += fmt.Sprintln()
 		data += fmt.Sprintln("Content-Type: application/json")
 		data += fmt.Sprintln()
+		data += fmt.Sprintf("%s: <token> (optional)", TokenHeader)
+		data += fmt.Sprintln()
 		data += fmt.Sprintln()
 
 		if endpoint.Params != nil {
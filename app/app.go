@@ -1,8 +1,10 @@
 package app
 
 import (
+	"archive/zip"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	htmlstd "html"
 	"io/fs"
@@ -11,10 +13,13 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"mu/audit"
 	"mu/auth"
 	"mu/config"
 
@@ -127,6 +132,78 @@ var LoginTemplate = `<html lang="en">
 	  <input id="secret" name="secret" type="password" placeholder="Password" required>
 	  <br>
 	  <button>Login</button>
+	  <p><a href="/reset">Have a reset code?</a></p>
+	</form>
+      </div>
+    </div>
+  </body>
+</html>
+`
+
+// TwoFactorTemplate asks for a one-time code after the password. It takes
+// a message and the login challenge.
+var TwoFactorTemplate = `<html lang="en">
+  <head>
+    <title>Login | Mu</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1, interactive-widget=resizes-content, viewport-fit=cover" />
+    <meta name="referrer" content="no-referrer"/>
+    <link rel="preconnect" href="https://fonts.googleapis.com">
+    <link rel="preconnect" href="https://fonts.gstatic.com" crossorigin>
+    <link href="https://fonts.googleapis.com/css2?family=Nunito+Sans:ital,opsz,wght@0,6..12,200..1000;1,6..12,200..1000&display=swap" rel="stylesheet">
+    <link rel="stylesheet" href="/mu.css">
+  </head>
+  <body>
+    <div id="head">
+      <div id="brand">
+        <a href="/">Mu</a>
+      </div>
+    </div>
+    <div id="container">
+      <div id="content">
+	<form id="login" action="/login" method="POST">
+	  <h1>Two-factor code</h1>
+	  %s
+	  <p>Enter the 6 digit code from your authenticator app, or one of your recovery codes.</p>
+	  <input type="hidden" name="challenge" value="%s">
+	  <input id="code" name="code" placeholder="123456" autocomplete="one-time-code" inputmode="numeric" autofocus required>
+	  <br>
+	  <button>Verify</button>
+	</form>
+      </div>
+    </div>
+  </body>
+</html>
+`
+
+// ResetTemplate sets a new password with a reset code from an admin. It
+// takes a message and the code, if one was given in the link.
+var ResetTemplate = `<html lang="en">
+  <head>
+    <title>Reset password | Mu</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1, interactive-widget=resizes-content, viewport-fit=cover" />
+    <meta name="referrer" content="no-referrer"/>
+    <link rel="preconnect" href="https://fonts.googleapis.com">
+    <link rel="preconnect" href="https://fonts.gstatic.com" crossorigin>
+    <link href="https://fonts.googleapis.com/css2?family=Nunito+Sans:ital,opsz,wght@0,6..12,200..1000;1,6..12,200..1000&display=swap" rel="stylesheet">
+    <link rel="stylesheet" href="/mu.css">
+  </head>
+  <body>
+    <div id="head">
+      <div id="brand">
+        <a href="/">Mu</a>
+      </div>
+    </div>
+    <div id="container">
+      <div id="content">
+	<form id="login" action="/reset" method="POST">
+	  <h1>Reset password</h1>
+	  %s
+	  <p>Forgot your password? Ask an admin for a reset code, then choose a new password here.</p>
+	  <input id="code" name="code" placeholder="Reset code" value="%s" autocomplete="off" required>
+	  <input id="secret" name="secret" type="password" placeholder="New password (min 6 chars)" autocomplete="new-password" required>
+	  <input id="confirm" name="confirm" type="password" placeholder="Repeat new password" autocomplete="new-password" required>
+	  <br>
+	  <button>Reset password</button>
 	</form>
       </div>
     </div>
@@ -158,6 +235,7 @@ var SignupTemplate = `<html lang="en">
 	  <input id="id" name="id" placeholder="Username (4-24 chars, lowercase)" required>
 	  <input id="name" name="name" placeholder="Name (optional)">
   	  <input id="secret" name="secret" type="password" placeholder="Password (min 6 chars)" required>
+	  %s
 	  <br>
 	  <button>Signup</button>
 	</form>
@@ -167,6 +245,19 @@ var SignupTemplate = `<html lang="en">
 </html>
 `
 
+// signupPage renders the signup form with msg, asking for an invite code
+// unless signups are open. A code in the invite parameter is filled in.
+func signupPage(r *http.Request, msg string) string {
+	invite := ""
+	switch config.Get().SignupMode {
+	case config.SignupInvite:
+		invite = fmt.Sprintf(`<input id="invite" name="invite" placeholder="Invite code" value="%s" required>`, htmlstd.EscapeString(r.FormValue("invite")))
+	case config.SignupApproval:
+		invite = fmt.Sprintf(`<input id="invite" name="invite" placeholder="Invite code (optional)" value="%s">`, htmlstd.EscapeString(r.FormValue("invite")))
+	}
+	return fmt.Sprintf(SignupTemplate, msg, invite)
+}
+
 func Link(name, ref string) string {
 	return fmt.Sprintf(`<a href="%s" class="link">%s</a>`, ref, name)
 }
@@ -198,6 +289,28 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
 		r.ParseForm()
 
+		if ok, wait := auth.LoginLimiter.Allow(auth.ClientIP(r)); !ok {
+			w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())))
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(fmt.Sprintf(LoginTemplate, `<p style="color: red;">Too many attempts, try again in `+auth.FormatWait(wait)+`</p>`)))
+			return
+		}
+
+		// second step for accounts with two-factor authentication
+		if challenge := r.Form.Get("challenge"); challenge != "" {
+			sess, err := auth.VerifyLogin(challenge, r.Form.Get("code"))
+			if err == auth.ErrInvalidCode {
+				w.Write([]byte(fmt.Sprintf(TwoFactorTemplate, `<p style="color: red;">Invalid code</p>`, htmlstd.EscapeString(challenge))))
+				return
+			}
+			if err != nil {
+				w.Write([]byte(fmt.Sprintf(LoginTemplate, fmt.Sprintf(`<p style="color: red;">%s</p>`, htmlstd.EscapeString(err.Error())))))
+				return
+			}
+			completeLogin(w, r, sess)
+			return
+		}
+
 		id := r.Form.Get("id")
 		secret := r.Form.Get("secret")
 
@@ -211,51 +324,103 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		}
 
 		sess, err := auth.Login(id, secret)
+		var second *auth.SecondFactorRequired
+		if errors.As(err, &second) {
+			w.Write([]byte(fmt.Sprintf(TwoFactorTemplate, "", htmlstd.EscapeString(second.Challenge))))
+			return
+		}
+		var locked *auth.LockedError
+		if errors.As(err, &locked) {
+			w.Write([]byte(fmt.Sprintf(LoginTemplate, `<p style="color: red;">Too many failed logins, try again in `+auth.FormatWait(time.Until(locked.Until))+`</p>`)))
+			return
+		}
+		var suspended *auth.SuspendedError
+		if errors.As(err, &suspended) {
+			w.Write([]byte(fmt.Sprintf(LoginTemplate, fmt.Sprintf(`<p style="color: red;">Sign in refused, %s</p>`, htmlstd.EscapeString(err.Error())))))
+			return
+		}
 		if err != nil {
 			w.Write([]byte(fmt.Sprintf(LoginTemplate, `<p style="color: red;">Invalid username or password</p>`)))
 			return
 		}
 
-		var secure bool
+		completeLogin(w, r, sess)
+	}
+}
 
-		if h := r.Header.Get("X-Forwarded-Proto"); h == "https" {
-			secure = true
+// completeLogin gives the browser its session once every login step has
+// passed, then sends it home.
+func completeLogin(w http.ResponseWriter, r *http.Request, sess *auth.Session) {
+	// set a new token
+	auth.SetSessionCookie(w, r, sess)
+
+	// Check for pending membership activation
+	if pendingCookie, err := r.Cookie("pending_membership"); err == nil && pendingCookie.Value == "true" {
+		// Get account and activate membership
+		if acc, err := auth.GetAccount(sess.Account); err == nil {
+			acc.Member = true
+			auth.UpdateAccount(acc)
 		}
-
-		// set a new token
+		// Clear the pending cookie
 		http.SetCookie(w, &http.Cookie{
-			Name:   "session",
-			Value:  sess.Token,
-			Secure: secure,
+			Name:     "pending_membership",
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
 		})
+	}
 
-		// Check for pending membership activation
-		if pendingCookie, err := r.Cookie("pending_membership"); err == nil && pendingCookie.Value == "true" {
-			// Get account and activate membership
-			if acc, err := auth.GetAccount(sess.Account); err == nil {
-				acc.Member = true
-				auth.UpdateAccount(acc)
-			}
-			// Clear the pending cookie
-			http.SetCookie(w, &http.Cookie{
-				Name:     "pending_membership",
-				Value:    "",
-				Path:     "/",
-				MaxAge:   -1,
-				HttpOnly: true,
-			})
-		}
+	// return to home
+	http.Redirect(w, r, "/home", http.StatusFound)
+}
 
-		// return to home
-		http.Redirect(w, r, "/home", http.StatusFound)
+// Reset sets a new password with a one-time code issued by an admin.
+func Reset(w http.ResponseWriter, r *http.Request) {
+	code := r.FormValue("code")
+	page := func(msg string) []byte {
+		return []byte(fmt.Sprintf(ResetTemplate, msg, htmlstd.EscapeString(code)))
+	}
+
+	if r.Method != "POST" {
+		w.Write(page(""))
+		return
+	}
+
+	// codes are guessed like passwords, so share the login limit
+	if ok, wait := auth.LoginLimiter.Allow(auth.ClientIP(r)); !ok {
+		w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write(page(`<p style="color: red;">Too many attempts, try again in ` + auth.FormatWait(wait) + `</p>`))
+		return
+	}
+
+	secret := r.FormValue("secret")
+	if secret != r.FormValue("confirm") {
+		w.Write(page(`<p style="color: red;">Passwords do not match</p>`))
+		return
+	}
+	id, err := auth.ResetPassword(code, secret)
+	if err != nil {
+		w.Write(page(fmt.Sprintf(`<p style="color: red;">%s</p>`, htmlstd.EscapeString(err.Error()))))
 		return
 	}
+	auth.Audit(r, audit.Event{Actor: id, Action: "auth.password_reset", Target: id})
+
+	w.Write([]byte(fmt.Sprintf(LoginTemplate, `<p style="color: green;">Your password has been reset. Log in with your new password.</p>`)))
 }
 
 // Signup handler
 func Signup(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		w.Write([]byte(fmt.Sprintf(SignupTemplate, "")))
+		msg := ""
+		switch config.Get().SignupMode {
+		case config.SignupInvite:
+			msg = `<p>Signups on this instance need an invite code from an existing member.</p>`
+		case config.SignupApproval:
+			msg = `<p>New accounts on this instance are reviewed by an admin before they can post or chat. An invite code skips the wait.</p>`
+		}
+		w.Write([]byte(signupPage(r, msg)))
 		return
 	}
 
@@ -271,22 +436,22 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 		usernameRegex := regexp.MustCompile(usernamePattern)
 
 		if len(id) == 0 {
-			w.Write([]byte(fmt.Sprintf(SignupTemplate, `<p style="color: red;">Username is required</p>`)))
+			w.Write([]byte(signupPage(r, `<p style="color: red;">Username is required</p>`)))
 			return
 		}
 
 		if !usernameRegex.MatchString(id) {
-			w.Write([]byte(fmt.Sprintf(SignupTemplate, `<p style="color: red;">Invalid username format. Must start with a letter, be 4-24 characters, and contain only lowercase letters, numbers, and underscores</p>`)))
+			w.Write([]byte(signupPage(r, `<p style="color: red;">Invalid username format. Must start with a letter, be 4-24 characters, and contain only lowercase letters, numbers, and underscores</p>`)))
 			return
 		}
 
 		if len(secret) == 0 {
-			w.Write([]byte(fmt.Sprintf(SignupTemplate, `<p style="color: red;">Password is required</p>`)))
+			w.Write([]byte(signupPage(r, `<p style="color: red;">Password is required</p>`)))
 			return
 		}
 
-		if len(secret) < 6 {
-			w.Write([]byte(fmt.Sprintf(SignupTemplate, `<p style="color: red;">Password must be at least 6 characters</p>`)))
+		if len(secret) < auth.MinPasswordLength {
+			w.Write([]byte(signupPage(r, fmt.Sprintf(`<p style="color: red;">Password must be at least %d characters</p>`, auth.MinPasswordLength))))
 			return
 		}
 
@@ -295,35 +460,50 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 			name = id
 		}
 
-		if err := auth.Create(&auth.Account{
+		if ok, wait := auth.SignupLimiter.Allow(auth.ClientIP(r)); !ok {
+			w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())))
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(signupPage(r, `<p style="color: red;">Too many signups from your network, try again in `+auth.FormatWait(wait)+`</p>`)))
+			return
+		}
+
+		acc := &auth.Account{
 			ID:      id,
 			Secret:  secret,
 			Name:    name,
 			Created: time.Now(),
-		}); err != nil {
-			w.Write([]byte(fmt.Sprintf(SignupTemplate, fmt.Sprintf(`<p style="color: red;">%s</p>`, err.Error()))))
+		}
+		mode := config.Get().SignupMode
+		invite := strings.TrimSpace(r.Form.Get("invite"))
+		var err error
+		switch {
+		case invite != "" && mode != config.SignupOpen:
+			err = auth.CreateInvited(acc, invite)
+		case mode == config.SignupInvite:
+			err = errors.New("An invite code is required to sign up")
+		case mode == config.SignupApproval:
+			acc.Pending = true
+			err = auth.Create(acc)
+		default:
+			err = auth.Create(acc)
+		}
+		if err != nil {
+			w.Write([]byte(signupPage(r, fmt.Sprintf(`<p style="color: red;">%s</p>`, htmlstd.EscapeString(err.Error())))))
 			return
 		}
+		if acc.Pending {
+			fmt.Printf("[auth] %s signed up and is awaiting approval\n", id)
+		}
 
 		// login
 		sess, err := auth.Login(id, secret)
 		if err != nil {
-			w.Write([]byte(fmt.Sprintf(SignupTemplate, `<p style="color: red;">Account created but login failed. Please try logging in.</p>`)))
+			w.Write([]byte(signupPage(r, `<p style="color: red;">Account created but login failed. Please try logging in.</p>`)))
 			return
 		}
 
-		var secure bool
-
-		if h := r.Header.Get("X-Forwarded-Proto"); h == "https" {
-			secure = true
-		}
-
 		// set a new token
-		http.SetCookie(w, &http.Cookie{
-			Name:   "session",
-			Value:  sess.Token,
-			Secure: secure,
-		})
+		auth.SetSessionCookie(w, r, sess)
 
 		// Check for pending membership activation
 		if pendingCookie, err := r.Cookie("pending_membership"); err == nil && pendingCookie.Value == "true" {
@@ -361,15 +541,128 @@ func Account(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Handle POST to update language
+	// notices are shown in their section, e.g. a token just created, which
+	// cannot be shown again after a redirect
+	notice := ""
+	totpNotice := ""
+	inviteNotice := ""
+	passwordNotice := ""
+	closeNotice := ""
+
 	if r.Method == "POST" {
 		r.ParseForm()
-		newLang := r.Form.Get("language")
-		if _, ok := SupportedLanguages[newLang]; ok {
-			acc.Language = newLang
+		switch r.Form.Get("action") {
+		case "revoke_session":
+			auth.RevokeSession(acc.ID, r.Form.Get("session"))
+		case "create_token":
+			days, _ := strconv.Atoi(r.Form.Get("expires"))
+			tk, tok, err := auth.CreateAPIToken(acc.ID, r.Form.Get("name"), r.Form["scope"], time.Duration(days)*24*time.Hour)
+			if err != nil {
+				notice = fmt.Sprintf(`<p style="color: red;">%s</p>`, htmlstd.EscapeString(err.Error()))
+			} else {
+				notice = fmt.Sprintf(`<p>Token <strong>%s</strong> created. Copy it now, it won't be shown again:</p>
+			<p><code class="api-token">%s</code></p>`, htmlstd.EscapeString(tok.Name), tk)
+			}
+		case "revoke_token":
+			auth.RevokeAPIToken(acc.ID, r.Form.Get("token"))
+		case "create_invite":
+			if !acc.Can(auth.PermInvite) {
+				http.Error(w, "Forbidden - invite permission required", http.StatusForbidden)
+				return
+			}
+			uses, _ := strconv.Atoi(r.Form.Get("uses"))
+			days, _ := strconv.Atoi(r.Form.Get("expires"))
+			quota := config.Get().InviteQuota
+			if acc.Can(auth.PermUsers) {
+				quota = 0
+			}
+			code, _, err := auth.CreateInvite(acc.ID, uses, time.Duration(days)*24*time.Hour, quota)
+			if err != nil {
+				inviteNotice = fmt.Sprintf(`<p style="color: red;">%s</p>`, htmlstd.EscapeString(err.Error()))
+			} else {
+				link := inviteLink(r, code)
+				inviteNotice = fmt.Sprintf(`<p>Invite created. Copy it now, it won't be shown again:</p>
+			<p><code class="api-token">%s</code></p>
+			<p>or share the link <a href="%s">%s</a></p>`, code, link, link)
+			}
+		case "revoke_invite":
+			auth.RevokeInvite(acc.ID, r.Form.Get("invite"))
+		case "totp_begin":
+			if !auth.TOTPEnabled(acc.ID) {
+				auth.BeginTOTP(acc.ID)
+			}
+		case "totp_cancel":
+			if !auth.TOTPEnabled(acc.ID) {
+				auth.ResetTOTP(acc.ID)
+			}
+		case "totp_enable":
+			if codes, err := auth.EnableTOTP(acc.ID, r.Form.Get("code")); err != nil {
+				totpNotice = fmt.Sprintf(`<p style="color: red;">%s</p>`, htmlstd.EscapeString(err.Error()))
+			} else {
+				totpNotice = renderRecoveryCodes("Two-factor authentication is on.", codes)
+			}
+		case "totp_recovery", "totp_disable":
+			if err := auth.VerifyTOTP(acc.ID, r.Form.Get("code")); err != nil {
+				totpNotice = `<p style="color: red;">Invalid code</p>`
+			} else if r.Form.Get("action") == "totp_disable" {
+				auth.ResetTOTP(acc.ID)
+			} else if codes, err := auth.RegenerateRecoveryCodes(acc.ID); err == nil {
+				totpNotice = renderRecoveryCodes("New recovery codes created; the old ones no longer work.", codes)
+			}
+		case "logout_all":
+			auth.RevokeSessions(acc.ID, "")
+			auth.ClearSessionCookie(w, r)
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		case "change_password":
+			secret := r.Form.Get("password")
+			revokeTokens := r.Form.Get("revoke_tokens") == "on"
+			if secret != r.Form.Get("confirm") {
+				passwordNotice = `<p style="color: red;">New passwords do not match</p>`
+			} else if n, err := auth.ChangePassword(acc.ID, r.Form.Get("current"), secret, sess.ID, revokeTokens); err != nil {
+				passwordNotice = fmt.Sprintf(`<p style="color: red;">%s</p>`, htmlstd.EscapeString(err.Error()))
+			} else {
+				detail := fmt.Sprintf("%d other sessions ended", n)
+				passwordNotice = fmt.Sprintf(`<p style="color: green;">Password changed. %d other sessions were logged out.`, n)
+				if revokeTokens {
+					detail += ", API tokens revoked"
+					passwordNotice += " Your API tokens were revoked."
+				}
+				passwordNotice += `</p>`
+				auth.Audit(r, audit.Event{Action: "auth.password_change", Target: acc.ID, Detail: detail})
+			}
+		case "export":
+			exportAccount(w, acc.ID, r.Form.Get("format"))
+			return
+		case "close_account":
+			anonymize := r.Form.Get("posts") != "remove"
+			if r.Form.Get("confirm") != acc.ID {
+				closeNotice = `<p style="color: red;">Type your username to confirm</p>`
+			} else if err := auth.CloseAccount(acc.ID, r.Form.Get("password"), anonymize); err != nil {
+				closeNotice = fmt.Sprintf(`<p style="color: red;">%s</p>`, htmlstd.EscapeString(err.Error()))
+			} else {
+				posts := "removed"
+				if anonymize {
+					posts = "anonymized"
+				}
+				auth.Audit(r, audit.Event{Actor: acc.ID, Action: "account.close", Target: acc.ID, Detail: "posts " + posts})
+				auth.ClearSessionCookie(w, r)
+				http.Redirect(w, r, "/home", http.StatusSeeOther)
+				return
+			}
+		default:
+			// update language
+			newLang := r.Form.Get("language")
+			if _, ok := SupportedLanguages[newLang]; ok {
+				c := *acc
+				c.Language = newLang
+				auth.UpdateAccount(&c)
+			}
+		}
+		if notice == "" && totpNotice == "" && inviteNotice == "" && passwordNotice == "" && closeNotice == "" {
+			http.Redirect(w, r, "/account", http.StatusSeeOther)
+			return
 		}
-		http.Redirect(w, r, "/account", http.StatusSeeOther)
-		return
 	}
 
 	// Build membership section
@@ -407,8 +700,23 @@ func Account(w http.ResponseWriter, r *http.Request) {
 			<button type="submit" style="margin-left: 10px;">Save</button>
 		</form>`, languageOptions)
 
+	passwordSection := renderPassword(passwordNotice)
+	dataSection := renderAccountData(closeNotice)
+	sessionsSection := renderSessions(acc.ID, sess.ID)
+	tokensSection := renderAPITokens(acc.ID, notice)
+	totpSection := renderTwoFactor(acc.ID, totpNotice)
+	inviteSection := ""
+	if acc.Can(auth.PermInvite) {
+		inviteSection = renderInvites(acc.ID, inviteNotice)
+	}
+	pendingNotice := ""
+	if acc.Pending {
+		pendingNotice = `<p class="account-pending">Your account is awaiting approval by an admin. Until then you can look around but not post or chat.</p>`
+	}
+
 	content := fmt.Sprintf(`<div style="max-width: 600px;">
 		<h2 style="margin-bottom: 15px;">Profile</h2>
+		%s
 		<p><strong>Username:</strong> %s</p>
 		<p><strong>Name:</strong> %s</p>
 		<p><strong>Member since:</strong> %s</p>
@@ -418,26 +726,438 @@ func Account(w http.ResponseWriter, r *http.Request) {
 
 		<div style="margin-top: 20px;">%s</div>
 
+		<div style="margin-top: 20px;">%s</div>
+
+		<div style="margin-top: 20px;">%s</div>
+
+		<div style="margin-top: 20px;">%s</div>
+
+		<div style="margin-top: 20px;">%s</div>
+
+		<div style="margin-top: 20px;">%s</div>
+
+		<div style="margin-top: 20px;">%s</div>
+
 		<hr style="margin: 20px 0;">
 		<p><a href="/logout"><button style="display: inline-flex; align-items: center; gap: 8px; background: #000; color: #fff; border: 1px solid #000;"><img src="/logout.png" width="16" height="16" style="vertical-align: middle; filter: brightness(0) invert(1);">Logout</button></a></p>
 		</div>`,
+		pendingNotice,
 		acc.ID,
 		acc.Name,
 		acc.Created.Format("January 2, 2006"),
 		membershipSection,
 		languageSection,
+		passwordSection,
+		totpSection,
+		sessionsSection,
+		tokensSection,
+		inviteSection,
+		dataSection,
 	)
 
-	html := RenderHTMLWithLang("Account", "Your Account", content, currentLang)
+	html := WithCSRF(RenderHTMLWithLang("Account", "Your Account", content, currentLang), r)
 	w.Write([]byte(html))
 }
 
+// renderPassword shows the form to change the account's password.
+func renderPassword(notice string) string {
+	return fmt.Sprintf(`<h3>Password</h3>
+		<p>Changing your password logs out every other device.</p>
+		%s
+		<form action="/account" method="POST" style="margin-top: 10px;">
+			<input type="hidden" name="action" value="change_password">
+			<input type="password" name="current" placeholder="Current password" autocomplete="current-password" required style="padding: 8px; display: block; margin-bottom: 6px;">
+			<input type="password" name="password" placeholder="New password (min %d chars)" autocomplete="new-password" minlength="%d" required style="padding: 8px; display: block; margin-bottom: 6px;">
+			<input type="password" name="confirm" placeholder="Repeat new password" autocomplete="new-password" minlength="%d" required style="padding: 8px; display: block; margin-bottom: 6px;">
+			<label style="display: block; margin-bottom: 6px;"><input type="checkbox" name="revoke_tokens"> Also revoke my API tokens</label>
+			<button type="submit">Change password</button>
+		</form>`, notice, auth.MinPasswordLength, auth.MinPasswordLength, auth.MinPasswordLength)
+}
+
+// renderAccountData offers an export of the account's data and the form to
+// close the account.
+func renderAccountData(notice string) string {
+	return fmt.Sprintf(`<h3>Your data</h3>
+		<p>Download your profile, posts, flags, chat messages, sessions, tokens and settings.</p>
+		<form action="/account" method="POST" style="display: inline;">
+			<input type="hidden" name="action" value="export">
+			<input type="hidden" name="format" value="json">
+			<button type="submit">Export JSON</button>
+		</form>
+		<form action="/account" method="POST" style="display: inline;">
+			<input type="hidden" name="action" value="export">
+			<input type="hidden" name="format" value="zip">
+			<button type="submit">Export ZIP</button>
+		</form>
+
+		<h3 style="margin-top: 20px;">Delete account</h3>
+		<p>Deleting your account cannot be undone. Your posts can stay up as "Anonymous" or be removed.</p>
+		%s
+		<form action="/account" method="POST" style="margin-top: 10px;" onsubmit="return confirm('Delete your account permanently?');">
+			<input type="hidden" name="action" value="close_account">
+			<label style="display: block;"><input type="radio" name="posts" value="anonymize" checked> Keep my posts as Anonymous</label>
+			<label style="display: block; margin-bottom: 6px;"><input type="radio" name="posts" value="remove"> Remove my posts</label>
+			<input type="text" name="confirm" placeholder="Your username" autocomplete="off" required style="padding: 8px; display: block; margin-bottom: 6px;">
+			<input type="password" name="password" placeholder="Password" autocomplete="current-password" required style="padding: 8px; display: block; margin-bottom: 6px;">
+			<button type="submit" class="delete-btn" style="background: #dc3545; color: white; border: none;">Delete account</button>
+		</form>`, notice)
+}
+
+// exportAccount writes everything kept for an account as a JSON download,
+// or as a ZIP with one JSON file per section.
+func exportAccount(w http.ResponseWriter, id, format string) {
+	export, err := auth.ExportAccount(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	name := "mu-export-" + id + "-" + time.Now().UTC().Format("20060102")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if format != "zip" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.json"`)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(export)
+		return
+	}
+
+	sections := make([]string, 0, len(export))
+	for section := range export {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.zip"`)
+	zw := zip.NewWriter(w)
+	for _, section := range sections {
+		f, err := zw.Create(name + "/" + section + ".json")
+		if err != nil {
+			break
+		}
+		b, _ := json.MarshalIndent(export[section], "", "  ")
+		f.Write(b)
+	}
+	if err := zw.Close(); err != nil {
+		fmt.Printf("Export for %s failed: %v\n", id, err)
+	}
+}
+
+// renderSessions lists an account's active sessions with a button to end
+// each one, marking the one making the request.
+func renderSessions(account, current string) string {
+	rows := ""
+	for _, s := range auth.ListSessions(account) {
+		action := fmt.Sprintf(`<form action="/account" method="POST" style="display: inline;">
+				<input type="hidden" name="action" value="revoke_session">
+				<input type="hidden" name="session" value="%s">
+				<button type="submit">Revoke</button>
+			</form>`, s.ID)
+		if s.ID == current {
+			action = `<em>This device</em>`
+		}
+		ip := s.IP
+		if ip == "" {
+			ip = "unknown"
+		}
+		rows += fmt.Sprintf(`<tr>
+			<td>%s</td>
+			<td>%s</td>
+			<td>%s</td>
+			<td>%s</td>
+		</tr>`,
+			htmlstd.EscapeString(DescribeAgent(s.Agent)),
+			htmlstd.EscapeString(ip),
+			TimeAgo(s.LastSeen),
+			action,
+		)
+	}
+
+	return fmt.Sprintf(`<h3>Sessions</h3>
+		<p>Devices signed in to your account. Sessions end after %s without use.</p>
+		<table class="sessions">
+			<thead><tr><th>Device</th><th>IP</th><th>Last seen</th><th></th></tr></thead>
+			<tbody>%s</tbody>
+		</table>
+		<form action="/account" method="POST" style="margin-top: 10px;" onsubmit="return confirm('Log out of every device, including this one?');">
+			<input type="hidden" name="action" value="logout_all">
+			<button type="submit">Log out everywhere</button>
+		</form>`, formatDays(auth.GetSessionPolicy().Idle), rows)
+}
+
+// renderTwoFactor shows an account's two-factor status with the forms to
+// set it up, turn it off or replace recovery codes.
+func renderTwoFactor(account, notice string) string {
+	const codeInput = `<input type="text" name="code" placeholder="Code" autocomplete="one-time-code" inputmode="numeric" required style="padding: 8px; width: 120px;">`
+
+	if enabled, left := auth.TOTPStatus(account); !enabled.IsZero() {
+		return fmt.Sprintf(`<h3>Two-factor authentication</h3>
+		%s
+		<p><strong>On</strong> since %s. %d recovery codes left.</p>
+		<form action="/account" method="POST" style="margin-top: 10px;">
+			<input type="hidden" name="action" value="totp_recovery">
+			%s
+			<button type="submit">New recovery codes</button>
+		</form>
+		<form action="/account" method="POST" style="margin-top: 10px;">
+			<input type="hidden" name="action" value="totp_disable">
+			%s
+			<button type="submit">Turn off</button>
+		</form>`, notice, enabled.Format("January 2, 2006"), left, codeInput, codeInput)
+	}
+
+	secret, uri, pending := auth.PendingTOTP(account)
+	if !pending {
+		return fmt.Sprintf(`<h3>Two-factor authentication</h3>
+		%s
+		<p>Ask for a code from an authenticator app when you log in.</p>
+		<form action="/account" method="POST">
+			<input type="hidden" name="action" value="totp_begin">
+			<button type="submit">Set up</button>
+		</form>`, notice)
+	}
+
+	qr, err := QRCode(uri, 200)
+	if err != nil {
+		qr = ""
+	}
+	return fmt.Sprintf(`<h3>Two-factor authentication</h3>
+		%s
+		<p>Scan this code with your authenticator app, or enter the key by hand, then type the 6 digit code it shows.</p>
+		<div class="totp-qr">%s</div>
+		<p>Key: <code class="api-token">%s</code></p>
+		<form action="/account" method="POST" style="margin-top: 10px;">
+			<input type="hidden" name="action" value="totp_enable">
+			%s
+			<button type="submit">Turn on</button>
+		</form>
+		<form action="/account" method="POST" style="margin-top: 10px;">
+			<input type="hidden" name="action" value="totp_cancel">
+			<button type="submit">Cancel</button>
+		</form>`, notice, qr, secret, codeInput)
+}
+
+// renderRecoveryCodes shows newly created recovery codes once.
+func renderRecoveryCodes(msg string, codes []string) string {
+	return fmt.Sprintf(`<p>%s Save these recovery codes somewhere safe. Each works once if you lose your device, and they won't be shown again:</p>
+		<pre class="api-token">%s</pre>`, msg, strings.Join(codes, "\n"))
+}
+
+// tokenExpiry lists the lifetimes offered for new API tokens, in days.
+var tokenExpiry = []int{7, 30, 90, 365}
+
+// renderAPITokens lists an account's API tokens with a button to revoke
+// each one, and a form to create another.
+func renderAPITokens(account, notice string) string {
+	rows := ""
+	for _, t := range auth.ListAPITokens(account) {
+		used := "never"
+		if !t.LastUsed.IsZero() {
+			used = TimeAgo(t.LastUsed)
+			if t.LastIP != "" {
+				used += " from " + htmlstd.EscapeString(t.LastIP)
+			}
+		}
+		expires := t.Expires.Format("2 Jan 2006")
+		if !time.Now().Before(t.Expires) {
+			expires = "expired"
+		}
+		rows += fmt.Sprintf(`<tr>
+			<td>%s</td>
+			<td>%s</td>
+			<td>%s</td>
+			<td>%s</td>
+			<td><form action="/account" method="POST" style="display: inline;">
+				<input type="hidden" name="action" value="revoke_token">
+				<input type="hidden" name="token" value="%s">
+				<button type="submit">Revoke</button>
+			</form></td>
+		</tr>`,
+			htmlstd.EscapeString(t.Name),
+			htmlstd.EscapeString(strings.Join(t.Scopes, ", ")),
+			used,
+			expires,
+			t.ID,
+		)
+	}
+	if rows == "" {
+		rows = `<tr><td colspan="5"><em>No tokens yet.</em></td></tr>`
+	}
+
+	scopes := ""
+	for _, sc := range auth.Scopes {
+		checked := ""
+		if sc[0] == auth.ScopeRead {
+			checked = " checked"
+		}
+		scopes += fmt.Sprintf(`<label style="display: block;"><input type="checkbox" name="scope" value="%s"%s> <strong>%s</strong> &middot; %s</label>`, sc[0], checked, sc[0], sc[1])
+	}
+	expiry := ""
+	for _, d := range tokenExpiry {
+		selected := ""
+		if d == 90 {
+			selected = " selected"
+		}
+		expiry += fmt.Sprintf(`<option value="%d"%s>%d days</option>`, d, selected, d)
+	}
+
+	return fmt.Sprintf(`<h3>API tokens</h3>
+		<p>Tokens let scripts use Mu as you. Send one in the <code>X-Micro-Token</code> header or as <code>Authorization: Bearer</code>. See the <a href="/api">API docs</a>.</p>
+		%s
+		<table class="sessions">
+			<thead><tr><th>Name</th><th>Scopes</th><th>Last used</th><th>Expires</th><th></th></tr></thead>
+			<tbody>%s</tbody>
+		</table>
+		<form action="/account" method="POST" style="margin-top: 10px;">
+			<input type="hidden" name="action" value="create_token">
+			<input type="text" name="name" placeholder="Token name" maxlength="64" required style="padding: 8px;">
+			<select name="expires" style="padding: 8px;">%s</select>
+			<div style="margin: 8px 0;">%s</div>
+			<button type="submit">Create token</button>
+		</form>`, notice, rows, expiry, scopes)
+}
+
+// inviteUses and inviteExpiry are the choices offered for new invites.
+var (
+	inviteUses   = []int{1, 5, 25}
+	inviteExpiry = []int{1, 7, 30}
+)
+
+// renderInvites lists the invite codes an account has created, with a form
+// to make another.
+func renderInvites(account, notice string) string {
+	rows := ""
+	for _, inv := range auth.ListInvites(account) {
+		status := fmt.Sprintf("%d of %d used", len(inv.UsedBy), inv.MaxUses)
+		if len(inv.UsedBy) > 0 {
+			status += " by " + htmlstd.EscapeString(strings.Join(inv.UsedBy, ", "))
+		}
+		expires := inv.Expires.Format("2 Jan 2006")
+		if !time.Now().Before(inv.Expires) {
+			expires = "expired"
+		}
+		rows += fmt.Sprintf(`<tr>
+			<td>%s</td>
+			<td>%s</td>
+			<td>%s</td>
+			<td><form action="/account" method="POST" style="display: inline;">
+				<input type="hidden" name="action" value="revoke_invite">
+				<input type="hidden" name="invite" value="%s">
+				<button type="submit">Revoke</button>
+			</form></td>
+		</tr>`, inv.Created.Format("2 Jan 2006"), status, expires, inv.ID)
+	}
+	if rows == "" {
+		rows = `<tr><td colspan="4"><em>No invites yet.</em></td></tr>`
+	}
+
+	uses := ""
+	for _, n := range inviteUses {
+		label := fmt.Sprintf("%d uses", n)
+		if n == 1 {
+			label = "Single use"
+		}
+		uses += fmt.Sprintf(`<option value="%d">%s</option>`, n, label)
+	}
+	expiry := ""
+	for _, d := range inviteExpiry {
+		selected := ""
+		if d == 7 {
+			selected = " selected"
+		}
+		expiry += fmt.Sprintf(`<option value="%d"%s>%s</option>`, d, selected, formatDays(time.Duration(d)*24*time.Hour))
+	}
+
+	return fmt.Sprintf(`<h3>Invites</h3>
+		<p>Invite codes let people sign up when signups are closed or need approval.</p>
+		%s
+		<table class="sessions">
+			<thead><tr><th>Created</th><th>Used</th><th>Expires</th><th></th></tr></thead>
+			<tbody>%s</tbody>
+		</table>
+		<form action="/account" method="POST" style="margin-top: 10px;">
+			<input type="hidden" name="action" value="create_invite">
+			<select name="uses" style="padding: 8px;">%s</select>
+			<select name="expires" style="padding: 8px;">%s</select>
+			<button type="submit">Create invite</button>
+		</form>`, notice, rows, uses, expiry)
+}
+
+// inviteLink returns the signup link for an invite code on this server.
+func inviteLink(r *http.Request, code string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/signup?invite=" + code
+}
+
+// formatDays renders a duration in whole days, or hours below a day.
+func formatDays(d time.Duration) string {
+	if d < 24*time.Hour {
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	days := int(d.Hours() / 24)
+	if days == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+// DescribeAgent turns a User-Agent header into a short "Browser on OS"
+// description.
+func DescribeAgent(ua string) string {
+	if ua == "" {
+		return "Unknown device"
+	}
+
+	browser := "Browser"
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "curl/"):
+		browser = "curl"
+	}
+
+	platform := ""
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		platform = "iOS"
+	case strings.Contains(ua, "Android"):
+		platform = "Android"
+	case strings.Contains(ua, "Windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "Mac OS X"), strings.Contains(ua, "Macintosh"):
+		platform = "macOS"
+	case strings.Contains(ua, "CrOS"):
+		platform = "ChromeOS"
+	case strings.Contains(ua, "Linux"):
+		platform = "Linux"
+	}
+
+	if platform == "" {
+		return browser
+	}
+	return browser + " on " + platform
+}
+
 // Settings lets a logged-in user manage API keys needed by optional services.
 func Settings(w http.ResponseWriter, r *http.Request) {
 	status := ""
 	if r.Method == http.MethodPost {
 		r.ParseForm()
-		current := config.Get()
+		previous := config.Get()
+		current := previous
 		current.YouTubeAPIKey = strings.TrimSpace(r.Form.Get("youtube_api_key"))
 		current.FanarAPIKey = strings.TrimSpace(r.Form.Get("fanar_api_key"))
 		src := strings.TrimSpace(r.Form.Get("reminder_source"))
@@ -447,6 +1167,15 @@ func Settings(w http.ResponseWriter, r *http.Request) {
 		current.ReminderSource = src
 		current.NewsSources = r.Form["news_sources"]
 
+		// Signups
+		current.SignupMode = r.Form.Get("signup_mode")
+		if !slices.Contains(config.SignupModes, current.SignupMode) {
+			current.SignupMode = config.SignupOpen
+		}
+		if n, err := strconv.Atoi(r.Form.Get("invite_quota")); err == nil && n > 0 {
+			current.InviteQuota = n
+		}
+
 		// Codex settings
 		current.ChatModel = strings.TrimSpace(r.Form.Get("chat_model"))
 		current.ChatThinking = strings.TrimSpace(r.Form.Get("chat_thinking"))
@@ -459,6 +1188,9 @@ func Settings(w http.ResponseWriter, r *http.Request) {
 		if err := config.Update(current); err != nil {
 			status = fmt.Sprintf(`<p style="color: red;">Failed to save settings: %s</p>`, htmlstd.EscapeString(err.Error()))
 		} else {
+			if before, after := config.Changes(previous, current); len(after) > 0 {
+				auth.Audit(r, audit.Event{Action: "settings.update", Target: "settings", Before: before, After: after})
+			}
 			status = `<p style="color: green;">Settings saved. Feeds are refreshing...</p>`
 		}
 	}
@@ -554,6 +1286,16 @@ func Settings(w http.ResponseWriter, r *http.Request) {
 		return b.String()
 	}
 
+	var signupOpts strings.Builder
+	signupLabels := map[string]string{
+		config.SignupOpen:     "Open - anyone can sign up",
+		config.SignupInvite:   "Invite only - an invite code is required",
+		config.SignupApproval: "Approval - new accounts wait for an admin unless invited",
+	}
+	for _, mode := range config.SignupModes {
+		fmt.Fprintf(&signupOpts, `<option value="%s" %s>%s</option>`, mode, selected(current.SignupMode, mode), signupLabels[mode])
+	}
+
 	chatModelOpts := modelOptions(current.ChatModel)
 	chatThinkingOpts := thinkingOptions(current.ChatThinking)
 	summaryModelOpts := modelOptions(current.SummaryModel)
@@ -561,7 +1303,7 @@ func Settings(w http.ResponseWriter, r *http.Request) {
 
 	content := fmt.Sprintf(`<div style="max-width: 680px;">
 		<h2>API Keys</h2>
-		<p>Keys are stored locally on this server at <code>$HOME/.mu/data/settings.json</code>. Use them to enable integrations like YouTube and Fanar.</p>
+		<p>Keys are stored locally on this server at <code>$HOME/.mu/data/settings.json</code>, encrypted when a master key is set. Use them to enable integrations like YouTube and Fanar.</p>
 		%s
 		<form action="/settings" method="POST" style="margin-top: 16px;">
 			<label for="youtube_api_key"><strong>YouTube Data API key</strong></label><br>
@@ -584,6 +1326,12 @@ func Settings(w http.ResponseWriter, r *http.Request) {
 			<p>Select which feeds to use. Edit <code>news/feeds.json</code> to add/remove options, then toggle them here.</p>
 			<div class="news-sources">%s</div>
 
+			<h3>Signups</h3>
+			<p>Who can create an account. Members, moderators and admins can create invite codes from their account page.</p>
+			<select id="signup_mode" name="signup_mode" style="width: 100%%; padding: 8px; margin: 4px 0 12px 0;">%s</select>
+			<label for="invite_quota">Active invites per account (admins are not limited)</label><br>
+			<input id="invite_quota" name="invite_quota" type="number" min="1" max="1000" value="%d" style="width: 120px; padding: 8px; margin: 4px 0 12px 0;">
+
 			<h3>Codex CLI</h3>
 			<p>%s</p>
 
@@ -617,6 +1365,7 @@ func Settings(w http.ResponseWriter, r *http.Request) {
 		selected(current.ReminderSource, "bible"),
 		selected(current.ReminderSource, "zen"),
 		newsChecks.String(),
+		signupOpts.String(), current.InviteQuota,
 		codexStatus,
 		chatModelOpts, chatThinkingOpts,
 		summaryModelOpts, summaryThinkingOpts,
@@ -645,17 +1394,7 @@ func Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var secure bool
-
-	if h := r.Header.Get("X-Forwarded-Proto"); h == "https" {
-		secure = true
-	}
-	// set a new token
-	http.SetCookie(w, &http.Cookie{
-		Name:   "session",
-		Value:  "",
-		Secure: secure,
-	})
+	auth.ClearSessionCookie(w, r)
 	auth.Logout(sess.Token)
 	http.Redirect(w, r, "/home", http.StatusFound)
 }
@@ -873,7 +1612,34 @@ func RenderHTML(title, desc, html string) string {
 // RenderHTMLForRequest renders the given html in a template using the user's language preference
 func RenderHTMLForRequest(title, desc, html string, r *http.Request) string {
 	lang := GetUserLanguage(r)
-	return RenderHTMLWithLang(title, desc, html, lang)
+	return WithCSRF(RenderHTMLWithLang(title, desc, html, lang), r)
+}
+
+var postForm = regexp.MustCompile(`(?i)<form\b[^>]*\bmethod=["']?post["']?[^>]*>`)
+
+// CSRFField returns a hidden input carrying the request's CSRF token, or
+// nothing for guests.
+func CSRFField(r *http.Request) string {
+	tk := auth.CSRFToken(r)
+	if tk == "" {
+		return ""
+	}
+	return fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`, auth.CSRFField, tk)
+}
+
+// WithCSRF adds the request's CSRF token to a rendered page: a hidden field
+// in every POST form and a csrf-token meta tag for scripts.
+func WithCSRF(page string, r *http.Request) string {
+	tk := auth.CSRFToken(r)
+	if tk == "" {
+		return page
+	}
+	field := CSRFField(r)
+	page = strings.Replace(page, "</head>", `<meta name="csrf-token" content="`+tk+`">
+  </head>`, 1)
+	return postForm.ReplaceAllStringFunc(page, func(form string) string {
+		return form + field
+	})
 }
 
 // RenderHTMLWithLang renders the given html in a template with specified language
@@ -881,7 +1647,14 @@ func RenderHTMLWithLang(title, desc, html, lang string) string {
 	if lang == "" {
 		lang = "en"
 	}
-	return fmt.Sprintf(Template, lang, title, desc, "", "", title, html)
+	return renderPage(lang, title, desc, "", html)
+}
+
+// renderPage fills in Template. The title and description are plain text
+// and are escaped, since they often come from users or the query string.
+func renderPage(lang, title, desc, logoutStyle, html string) string {
+	title = htmlstd.EscapeString(title)
+	return fmt.Sprintf(Template, lang, title, htmlstd.EscapeString(desc), "", logoutStyle, title, html)
 }
 
 func RenderHTMLWithLogout(title, desc, html string, showLogout bool) string {
@@ -889,7 +1662,7 @@ func RenderHTMLWithLogout(title, desc, html string, showLogout bool) string {
 	if !showLogout {
 		logoutStyle = ` style="display: none;"`
 	}
-	return fmt.Sprintf(Template, "en", title, desc, "", logoutStyle, title, html)
+	return renderPage("en", title, desc, logoutStyle, html)
 }
 
 // RenderHTMLWithLogoutAndLang renders the given html in a template with logout control and language
@@ -901,7 +1674,7 @@ func RenderHTMLWithLogoutAndLang(title, desc, html string, showLogout bool, lang
 	if !showLogout {
 		logoutStyle = ` style="display: none;"`
 	}
-	return fmt.Sprintf(Template, lang, title, desc, "", logoutStyle, title, html)
+	return renderPage(lang, title, desc, logoutStyle, html)
 }
 
 // RenderString renders a markdown string as html
@@ -911,7 +1684,7 @@ func RenderString(v string) string {
 
 // RenderTemplate renders a markdown string in a html template
 func RenderTemplate(title string, desc, text string) string {
-	return fmt.Sprintf(Template, "en", title, desc, "", "", title, RenderString(text))
+	return renderPage("en", title, desc, "", RenderString(text))
 }
 
 func ServeHTML(html string) http.Handler {
@@ -1,11 +1,34 @@
 package app
 
 import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"strings"
 	"testing"
 	"time"
+
+	"mu/auth"
+	"mu/config"
+	"mu/data"
 )
 
+func TestMain(m *testing.M) {
+	tmpDir, _ := os.MkdirTemp("", "mu_test_app")
+	originalHome := os.Getenv("HOME")
+	_ = os.Setenv("HOME", tmpDir)
+	code := m.Run()
+
+	data.FlushIndex()
+	_ = os.Setenv("HOME", originalHome)
+	os.RemoveAll(tmpDir)
+	os.Exit(code)
+}
+
 func TestTimeAgo(t *testing.T) {
 	now := time.Now()
 
@@ -50,3 +73,162 @@ func TestRenderHTML(t *testing.T) {
 		t.Error("RenderHTML missing content")
 	}
 }
+
+func TestQRCode(t *testing.T) {
+	svg, err := QRCode("otpauth://totp/Mu:alice?secret=JBSWY3DPEHPK3PXP&issuer=Mu", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, `width="200"`) || !strings.Contains(svg, "h1v1h-1z") {
+		t.Errorf("unexpected QR code markup: %.80s", svg)
+	}
+}
+
+func TestWithCSRF(t *testing.T) {
+	content := `<form method="POST" action="/posts"></form><form action="/search" method="GET"></form>`
+
+	guest := httptest.NewRequest("GET", "/posts", nil)
+	if page := RenderHTMLForRequest("Posts", "", content, guest); strings.Contains(page, "csrf") {
+		t.Error("guest page given a CSRF token")
+	}
+
+	if err := auth.Create(&auth.Account{ID: "csrfpage", Name: "csrfpage", Secret: "password123", Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auth.DeleteAccount("csrfpage") })
+	sess, err := auth.Login("csrfpage", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/posts", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: sess.Token})
+
+	page := RenderHTMLForRequest("Posts", "", content, r)
+	field := `<input type="hidden" name="csrf_token" value="` + sess.CSRF + `">`
+	if !strings.Contains(page, `<form method="POST" action="/posts">`+field) {
+		t.Error("POST form missing the CSRF field")
+	}
+	if strings.Count(page, field) != 1 {
+		t.Error("CSRF field added to a GET form")
+	}
+	if !strings.Contains(page, `<meta name="csrf-token" content="`+sess.CSRF+`">`) {
+		t.Error("page missing the csrf-token meta tag")
+	}
+}
+
+func TestSignupModes(t *testing.T) {
+	t.Cleanup(func() { config.Update(config.Settings{}) })
+
+	n := 0
+	signup := func(id, invite string) string {
+		t.Helper()
+		n++
+		form := url.Values{"id": {id}, "secret": {"password123"}, "invite": {invite}}
+		req := httptest.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", n)
+		rec := httptest.NewRecorder()
+		Signup(rec, req)
+		if _, err := auth.GetAccount(id); err == nil {
+			t.Cleanup(func() { auth.DeleteAccount(id) })
+		}
+		return rec.Body.String()
+	}
+
+	config.Update(config.Settings{SignupMode: config.SignupInvite})
+	if body := signup("noinvite", ""); !strings.Contains(body, "invite code is required") {
+		t.Errorf("invite mode without a code: %q", body)
+	}
+	if body := signup("badinvite", "aaaa-bbbb-cccc-dddd"); !strings.Contains(body, auth.ErrInvalidInvite.Error()) {
+		t.Errorf("invite mode with a bad code: %q", body)
+	}
+	if err := auth.Create(&auth.Account{ID: "host", Name: "host", Secret: "password123", Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auth.DeleteAccount("host") })
+	code, _, err := auth.CreateInvite("host", 2, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signup("invited", code)
+	if acc, err := auth.GetAccount("invited"); err != nil || acc.Pending {
+		t.Errorf("invited signup: %v %+v", err, acc)
+	}
+
+	config.Update(config.Settings{SignupMode: config.SignupApproval})
+	signup("queued", "")
+	if acc, err := auth.GetAccount("queued"); err != nil || !acc.Pending {
+		t.Errorf("approval signup: %v %+v", err, acc)
+	}
+	signup("skipped", code)
+	if acc, err := auth.GetAccount("skipped"); err != nil || acc.Pending {
+		t.Errorf("invited signup in approval mode: %v %+v", err, acc)
+	}
+}
+
+func TestAccountSelfService(t *testing.T) {
+	if err := auth.Create(&auth.Account{ID: "self", Name: "self", Secret: "password123", Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auth.DeleteAccount("self") })
+	sess, err := auth.Login("self", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/account", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session", Value: sess.Token})
+		rec := httptest.NewRecorder()
+		Account(rec, req)
+		return rec
+	}
+
+	post(url.Values{"language": {"ar"}})
+	// the change must reach disk, not just the account in memory
+	var saved map[string]*auth.Account
+	if err := data.LoadJSON("accounts.json", &saved); err != nil || saved["self"] == nil || saved["self"].Language != "ar" {
+		t.Errorf("language not saved: %v", err)
+	}
+
+	rec := post(url.Values{"action": {"export"}, "format": {"zip"}})
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("export is not a zip: %v", err)
+	}
+	found := false
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/account.json") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("zip export has no account.json")
+	}
+
+	rec = post(url.Values{"action": {"close_account"}, "confirm": {"someone"}, "password": {"password123"}})
+	if _, err := auth.GetAccount("self"); err != nil {
+		t.Fatal("account closed without confirming the username")
+	}
+	rec = post(url.Values{"action": {"close_account"}, "confirm": {"self"}, "password": {"password123"}})
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("close account: status %d", rec.Code)
+	}
+	if _, err := auth.GetAccount("self"); err == nil {
+		t.Error("account not closed")
+	}
+}
+
+func TestRenderHTMLEscapesTitle(t *testing.T) {
+	output := RenderHTML(`<script>x</script>`, `"><img src=x>`, "<p>ok</p>")
+	if strings.Contains(output, "<script>x</script>") || strings.Contains(output, `"><img src=x>`) {
+		t.Error("title or description rendered as markup")
+	}
+	if !strings.Contains(output, "<title>&lt;script&gt;x&lt;/script&gt; | Mu</title>") {
+		t.Error("missing escaped title")
+	}
+	if !strings.Contains(output, "<p>ok</p>") {
+		t.Error("content was escaped")
+	}
+}
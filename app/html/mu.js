@@ -197,6 +197,7 @@ if (typeof document === "undefined") {
       method: "POST",
       headers: {
         "Content-Type": "application/json",
+        "X-CSRF-Token": csrfToken(),
       },
       body: JSON.stringify(data),
     })
@@ -374,6 +375,13 @@ if (typeof document === "undefined") {
     }
   }
 
+  // csrfToken returns the token the server put in the page, which POSTs
+  // made with the session cookie must send back.
+  function csrfToken() {
+    var meta = document.querySelector('meta[name="csrf-token"]');
+    return meta ? meta.content : "";
+  }
+
   function getCookie(name) {
     var match = document.cookie.match(new RegExp("(^| )" + name + "=([^;]+)"));
     if (match) return match[2];
@@ -551,7 +559,10 @@ if (typeof document === "undefined") {
 
     fetch("/flag", {
       method: "POST",
-      headers: { "Content-Type": "application/x-www-form-urlencoded" },
+      headers: {
+        "Content-Type": "application/x-www-form-urlencoded",
+        "X-CSRF-Token": csrfToken(),
+      },
       body: "type=post&id=" + encodeURIComponent(postId),
     })
       .then((response) => response.json())
@@ -2,7 +2,10 @@ package app
 
 import (
 	"fmt"
+	"strings"
 	"time"
+
+	"rsc.io/qr"
 )
 
 func TimeAgo(d time.Time) string {
@@ -54,3 +57,25 @@ func distanceOfTime(minutes float64) string {
 		return fmt.Sprintf("%d months", int(minutes/43800))
 	}
 }
+
+// QRCode renders text as an inline SVG QR code, so nothing is sent to a
+// third party to draw it.
+func QRCode(text string, size int) (string, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return "", err
+	}
+
+	// 4 module quiet zone on each side
+	n := code.Size + 8
+	var path strings.Builder
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x+4, y+4)
+			}
+		}
+	}
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges"><rect width="100%%" height="100%%" fill="#fff"/><path d="%s" fill="#000"/></svg>`,
+		n, n, size, size, path.String()), nil
+}
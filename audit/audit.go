@@ -0,0 +1,168 @@
+// Package audit keeps an append-only log of security and administrative
+// events, one JSON object per line in $HOME/.mu/logs/audit.jsonl. Entries
+// are only ever appended; Query reads them back for the admin audit page.
+// The log lives outside the data store, so it is registered with backups
+// as an outside file.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"mu/data"
+)
+
+// Event is one audit log entry.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`            // account that acted, or "system"
+	Action string    `json:"action"`           // e.g. "auth.lockout"
+	Target string    `json:"target,omitempty"` // account or item acted on
+	IP     string    `json:"ip,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+	Before any       `json:"before,omitempty"` // state before the change
+	After  any       `json:"after,omitempty"`  // state after the change
+}
+
+var mutex sync.Mutex
+
+func init() {
+	data.RegisterBackupFile("logs/audit.jsonl", File)
+}
+
+// File returns the path of the audit log.
+func File() string {
+	return filepath.Join(os.ExpandEnv("$HOME"), ".mu", "logs", "audit.jsonl")
+}
+
+// Record appends an event to the audit log, stamping the time if unset.
+// Failures are printed rather than returned so callers never block on
+// logging.
+func Record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		fmt.Printf("[audit] Failed to encode event: %v\n", err)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	path := File()
+	os.MkdirAll(filepath.Dir(path), 0700)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Printf("[audit] Failed to open log: %v\n", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		fmt.Printf("[audit] Failed to write log: %v\n", err)
+	}
+}
+
+// Filter selects events. Empty fields match everything.
+type Filter struct {
+	Actor  string
+	Action string // matches the action or, ending in ".", any under it
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int // newest events kept, 0 for all
+}
+
+// Match reports whether e passes the filter.
+func (f Filter) Match(e Event) bool {
+	if f.Actor != "" && e.Actor != f.Actor {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action &&
+		!(strings.HasSuffix(f.Action, ".") && strings.HasPrefix(e.Action, f.Action)) {
+		return false
+	}
+	if f.Target != "" && e.Target != f.Target {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Query returns the events matching f, newest first. Lines that cannot be
+// decoded are skipped.
+func Query(f Filter) ([]Event, error) {
+	var events []Event
+	err := scan(func(e Event) {
+		if !f.Match(e) {
+			return
+		}
+		events = append(events, e)
+		if f.Limit > 0 && len(events) > 2*f.Limit {
+			events = append(events[:0], events[len(events)-f.Limit:]...)
+		}
+	})
+	if f.Limit > 0 && len(events) > f.Limit {
+		events = events[len(events)-f.Limit:]
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, err
+}
+
+// Export writes the events matching f to w as JSON lines, oldest first,
+// as they appear in the log.
+func Export(w io.Writer, f Filter) error {
+	f.Limit = 0
+	var werr error
+	err := scan(func(e Event) {
+		if werr != nil || !f.Match(e) {
+			return
+		}
+		b, _ := json.Marshal(e)
+		_, werr = w.Write(append(b, '\n'))
+	})
+	if werr != nil {
+		return werr
+	}
+	return err
+}
+
+// scan calls fn with each event in the log, oldest first.
+func scan(fn func(Event)) error {
+	f, err := os.Open(File())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// a line being appended may be read half written; it fails to decode
+	// and is skipped
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var e Event
+		if json.Unmarshal(sc.Bytes(), &e) != nil {
+			continue
+		}
+		fn(e)
+	}
+	return sc.Err()
+}
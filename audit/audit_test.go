@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if events, err := Query(Filter{}); err != nil || len(events) != 0 {
+		t.Fatalf("empty log: %v %v", events, err)
+	}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, e := range []Event{
+		{Actor: "alice", Action: "account.role", Target: "bob", Before: map[string]any{"role": "user"}, After: map[string]any{"role": "moderator"}},
+		{Actor: "alice", Action: "account.delete", Target: "carol"},
+		{Actor: "system", Action: "auth.lockout", Target: "bob"},
+		{Actor: "bob", Action: "content.delete", Target: "post:1"},
+	} {
+		e.Time = start.Add(time.Duration(i) * 24 * time.Hour)
+		Record(e)
+	}
+	// a torn line is skipped
+	f, _ := os.OpenFile(File(), os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString(`{"time":"2025-`)
+	f.Close()
+
+	actions := func(f Filter) string {
+		t.Helper()
+		events, err := Query(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var list []string
+		for _, e := range events {
+			list = append(list, e.Action)
+		}
+		return strings.Join(list, " ")
+	}
+
+	for _, tc := range []struct {
+		filter Filter
+		want   string
+	}{
+		{Filter{}, "content.delete auth.lockout account.delete account.role"},
+		{Filter{Limit: 2}, "content.delete auth.lockout"},
+		{Filter{Actor: "alice"}, "account.delete account.role"},
+		{Filter{Action: "account."}, "account.delete account.role"},
+		{Filter{Action: "account"}, ""},
+		{Filter{Target: "bob"}, "auth.lockout account.role"},
+		{Filter{Since: start.Add(24 * time.Hour), Until: start.Add(3 * 24 * time.Hour)}, "auth.lockout account.delete"},
+	} {
+		if got := actions(tc.filter); got != tc.want {
+			t.Errorf("Query(%+v) = %q, want %q", tc.filter, got, tc.want)
+		}
+	}
+
+	events, _ := Query(Filter{Action: "account.role"})
+	if after, ok := events[0].After.(map[string]any); !ok || after["role"] != "moderator" {
+		t.Errorf("after = %#v", events[0].After)
+	}
+
+	var buf bytes.Buffer
+	if err := Export(&buf, Filter{Target: "bob", Limit: 1}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"action":"account.role"`) || !strings.Contains(lines[0], `"before":{"role":"user"}`) {
+		t.Errorf("export:\n%s", buf.String())
+	}
+}
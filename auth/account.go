@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ============================================
+// ACCOUNT SELF-SERVICE
+// ============================================
+
+// Users can change their password, export everything tied to their account
+// and close it. Packages that keep data for an account, such as posts and
+// chat messages, register an AccountData so exports include it and closing
+// the account removes or anonymizes it.
+
+// MinPasswordLength is the shortest password accepted.
+const MinPasswordLength = 6
+
+// ErrWrongPassword is returned when a password given to confirm a change
+// does not match.
+var ErrWrongPassword = errors.New("current password is incorrect")
+
+// AccountData is data another package keeps for an account.
+type AccountData struct {
+	// Name is the section the data appears under in an export.
+	Name string
+	// Export returns the account's data, ready to encode as JSON.
+	Export func(acc *Account) any
+	// Remove deletes the account's data when it is closed, or with
+	// anonymize keeps what others may rely on, such as posts, detached
+	// from the account. It may be nil.
+	Remove func(acc *Account, anonymize bool)
+}
+
+var (
+	accountDataMu sync.Mutex
+	accountData   []AccountData
+)
+
+// RegisterAccountData adds d to account exports and closures.
+func RegisterAccountData(d AccountData) {
+	accountDataMu.Lock()
+	defer accountDataMu.Unlock()
+	accountData = append(accountData, d)
+}
+
+func registeredAccountData() []AccountData {
+	accountDataMu.Lock()
+	defer accountDataMu.Unlock()
+	return append([]AccountData(nil), accountData...)
+}
+
+// checkPassword compares secret with an account's password, counting a
+// mismatch towards lockout as a failed login would.
+func checkPassword(id, secret string) error {
+	acc, err := GetAccount(id)
+	if err != nil {
+		return err
+	}
+	if until := LockedUntil(id); !until.IsZero() {
+		return &LockedError{Until: until}
+	}
+	if bcrypt.CompareHashAndPassword([]byte(acc.Secret), []byte(secret)) != nil {
+		recordLockout(loginFailed(id))
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// ChangePassword sets a new password once the current one is confirmed,
+// then ends every session of the account except keep, so a stolen session
+// does not outlive the change. With revokeTokens the account's API tokens
+// are deleted too. It returns how many sessions ended.
+func ChangePassword(id, current, secret, keep string, revokeTokens bool) (int, error) {
+	if len(secret) < MinPasswordLength {
+		return 0, fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	if err := checkPassword(id, current); err != nil {
+		return 0, err
+	}
+	if err := setPassword(id, secret); err != nil {
+		return 0, err
+	}
+	n := RevokeSessions(id, keep)
+	tokens := 0
+	if revokeTokens {
+		tokens = revokeAccountTokens(id)
+	}
+	fmt.Printf("[auth] Password changed for %s, %d other sessions ended, %d API tokens revoked\n", id, n, tokens)
+	return n, nil
+}
+
+// setPassword stores the hash of a new password for an account.
+func setPassword(id, secret string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), 10)
+	if err != nil {
+		return err
+	}
+	acc, err := GetAccount(id)
+	if err != nil {
+		return err
+	}
+	c := *acc
+	c.Secret = string(hash)
+	return UpdateAccount(&c)
+}
+
+// ExportAccount gathers everything kept for an account, by section. The
+// password hash and token hashes are left out.
+func ExportAccount(id string) (map[string]any, error) {
+	acc, err := GetAccount(id)
+	if err != nil {
+		return nil, err
+	}
+
+	enabled, recovery := TOTPStatus(id)
+	twoFactor := map[string]any{"enabled": !enabled.IsZero()}
+	if !enabled.IsZero() {
+		twoFactor["since"] = enabled
+		twoFactor["recovery_codes_left"] = recovery
+	}
+
+	sessions := ListSessions(id)
+	for _, s := range sessions {
+		s.CSRF = ""
+	}
+
+	profile := map[string]any{
+		"id":       acc.ID,
+		"name":     acc.Name,
+		"created":  acc.Created,
+		"role":     acc.GetRole(),
+		"member":   acc.Member,
+		"language": acc.Language,
+		"pending":  acc.Pending,
+	}
+	if s := acc.Suspended(); s != nil {
+		profile["suspension"] = s.Suspension
+	}
+
+	out := map[string]any{
+		"account":    profile,
+		"two_factor": twoFactor,
+		"sessions":   sessions,
+		"api_tokens": ListAPITokens(id),
+		"invites":    ListInvites(id),
+	}
+	for _, d := range registeredAccountData() {
+		if d.Export != nil {
+			out[d.Name] = d.Export(acc)
+		}
+	}
+	return out, nil
+}
+
+// CloseAccount deletes an account at its owner's request once the password
+// is confirmed. Registered data is removed, or kept detached from the
+// account when anonymize is set. The last admin cannot close their account.
+func CloseAccount(id, password string, anonymize bool) error {
+	if err := checkPassword(id, password); err != nil {
+		return err
+	}
+	acc, err := GetAccount(id)
+	if err != nil {
+		return err
+	}
+	if isLastAdmin(acc) {
+		return errLastAdmin
+	}
+
+	snapshot := *acc
+	for _, d := range registeredAccountData() {
+		if d.Remove != nil {
+			d.Remove(&snapshot, anonymize)
+		}
+	}
+	if err := DeleteAccount(id); err != nil {
+		return err
+	}
+	loginSucceeded(id)
+	fmt.Printf("[auth] %s closed their account\n", id)
+	return nil
+}
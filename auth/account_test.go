@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChangePassword(t *testing.T) {
+	useClock(t)
+	createTestAccount(t, "changer")
+	keep, _ := Login("changer", "password123")
+	other, _ := Login("changer", "password123")
+
+	if _, err := ChangePassword("changer", "wrong", "newsecret", keep.ID, false); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("wrong current password: %v", err)
+	}
+	loginSucceeded("changer")
+	if _, err := ChangePassword("changer", "password123", "short", keep.ID, false); err == nil {
+		t.Fatal("short password accepted")
+	}
+
+	tk, _, _ := CreateAPIToken("changer", "script", []string{ScopeRead}, time.Hour)
+	n, err := ChangePassword("changer", "password123", "newsecret", keep.ID, false)
+	if err != nil || n != 1 {
+		t.Fatalf("ChangePassword = %d, %v", n, err)
+	}
+	if _, err := ResolveAPIToken(tk, ""); err != nil {
+		t.Errorf("API token revoked without asking: %v", err)
+	}
+	if _, err := useSession(keep.Token, "", ""); err != nil {
+		t.Errorf("kept session revoked: %v", err)
+	}
+	if _, err := useSession(other.Token, "", ""); err == nil {
+		t.Error("other session survived the change")
+	}
+	if _, err := Login("changer", "password123"); err == nil {
+		t.Error("old password still works")
+	}
+	if _, err := Login("changer", "newsecret"); err != nil {
+		t.Errorf("new password: %v", err)
+	}
+
+	if _, err := ChangePassword("changer", "newsecret", "thirdsecret", keep.ID, true); err != nil {
+		t.Fatalf("ChangePassword with token revocation: %v", err)
+	}
+	if _, err := ResolveAPIToken(tk, ""); err == nil {
+		t.Error("API token survived the change")
+	}
+}
+
+func TestExportAndCloseAccount(t *testing.T) {
+	useClock(t)
+	createTestAccount(t, "leaver")
+	Login("leaver", "password123")
+
+	var removed *Account
+	var anonymized bool
+	accountDataMu.Lock()
+	saved := accountData
+	accountDataMu.Unlock()
+	t.Cleanup(func() {
+		accountDataMu.Lock()
+		accountData = saved
+		accountDataMu.Unlock()
+	})
+	RegisterAccountData(AccountData{
+		Name:   "things",
+		Export: func(acc *Account) any { return []string{acc.ID + "-thing"} },
+		Remove: func(acc *Account, anonymize bool) { removed, anonymized = acc, anonymize },
+	})
+
+	export, err := ExportAccount("leaver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, section := range []string{"account", "sessions", "api_tokens", "invites", "things"} {
+		if _, ok := export[section]; !ok {
+			t.Errorf("export missing %q", section)
+		}
+	}
+	if _, ok := export["account"].(map[string]any)["secret"]; ok {
+		t.Error("export includes the password hash")
+	}
+	for _, s := range export["sessions"].([]*Session) {
+		if s.CSRF != "" {
+			t.Error("export includes a CSRF token")
+		}
+	}
+
+	if err := CloseAccount("leaver", "wrong", true); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("close with wrong password: %v", err)
+	}
+	if removed != nil {
+		t.Fatal("data removed before the password was confirmed")
+	}
+	if err := CloseAccount("leaver", "password123", true); err != nil {
+		t.Fatal(err)
+	}
+	if removed == nil || removed.ID != "leaver" || !anonymized {
+		t.Errorf("remove hook got %+v, anonymize=%v", removed, anonymized)
+	}
+	if _, err := GetAccount("leaver"); err == nil {
+		t.Error("account still exists")
+	}
+	if len(ListSessions("leaver")) > 0 {
+		t.Error("sessions survived the closure")
+	}
+}
+
+func TestCloseLastAdmin(t *testing.T) {
+	useClock(t)
+	createTestAccount(t, "onlyadmin")
+	for _, acc := range GetAllAccounts() {
+		if acc.GetRole() == RoleAdmin && acc.ID != "onlyadmin" {
+			t.Skip("another admin exists")
+		}
+	}
+	if err := SetRole("onlyadmin", RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := CloseAccount("onlyadmin", "password123", true); err == nil {
+		t.Fatal("last admin closed their account")
+	}
+}
@@ -1,7 +1,6 @@
 package auth
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -10,7 +9,6 @@ import (
 
 	"mu/data"
 
-	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -27,27 +25,80 @@ type Account struct {
 	Name     string    `json:"name"`
 	Secret   string    `json:"secret"`
 	Created  time.Time `json:"created"`
-	Admin    bool      `json:"admin"`
+	Role     string    `json:"role,omitempty"`
 	Member   bool      `json:"member"`
 	Language string    `json:"language"`
+	Pending  bool      `json:"pending,omitempty"` // awaiting admin approval
+
+	Suspension *Suspension `json:"suspension,omitempty"`
 }
 
 type Session struct {
-	ID      string    `json:"id"`
-	Type    string    `json:"type"`
-	Token   string    `json:"token"`
-	Account string    `json:"account"`
-	Created time.Time `json:"created"`
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Token    string    `json:"-"` // set on sessions handed out, never stored
+	Account  string    `json:"account"`
+	Created  time.Time `json:"created"`
+	LastSeen time.Time `json:"last_seen"`
+	Expires  time.Time `json:"expires"`
+	IP       string    `json:"ip,omitempty"`
+	Agent    string    `json:"agent,omitempty"`
+	CSRF     string    `json:"csrf,omitempty"` // form token, see CSRFToken
+}
+
+// accountHooks run after an account is created, updated or deleted
+var accountHooks []func(acc *Account, deleted bool)
+
+// OnAccountChange registers fn to be called after an account is created,
+// updated or deleted, outside the accounts lock.
+func OnAccountChange(fn func(acc *Account, deleted bool)) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	accountHooks = append(accountHooks, fn)
+}
+
+func notifyAccountChange(acc *Account, deleted bool) {
+	mutex.Lock()
+	hooks := append([]func(*Account, bool){}, accountHooks...)
+	mutex.Unlock()
+
+	for _, fn := range hooks {
+		fn(acc, deleted)
+	}
 }
 
 func init() {
+	// password hashes and live session tokens
+	data.RegisterSensitive("accounts.json", "sessions.json")
+}
+
+// Load reads accounts and sessions from disk. It runs after the master key
+// is set so encrypted files can be read.
+func Load() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
 	b, _ := data.LoadFile("accounts.json")
 	json.Unmarshal(b, &accounts)
 	b, _ = data.LoadFile("sessions.json")
 	json.Unmarshal(b, &sessions)
+	loadTokens()
+	loadTOTP()
+	loadInvites()
+	loadResets()
+
+	startPurger()
 }
 
 func Create(acc *Account) error {
+	if err := create(acc); err != nil {
+		return err
+	}
+	notifyAccountChange(acc, false)
+	return nil
+}
+
+func create(acc *Account) error {
 	mutex.Lock()
 	defer mutex.Unlock()
 
@@ -72,15 +123,20 @@ func Create(acc *Account) error {
 
 func Delete(acc *Account) error {
 	mutex.Lock()
-	defer mutex.Unlock()
-
 	if _, ok := accounts[acc.ID]; !ok {
+		mutex.Unlock()
 		return errors.New("account does not exist")
 	}
 
 	delete(accounts, acc.ID)
 	data.SaveJSON("accounts.json", accounts)
+	mutex.Unlock()
 
+	revokeAccountTokens(acc.ID)
+	revokeAccountInvites(acc.ID)
+	revokeAccountResets(acc.ID)
+	ResetTOTP(acc.ID)
+	notifyAccountChange(acc, true)
 	return nil
 }
 
@@ -98,15 +154,16 @@ func GetAccount(id string) (*Account, error) {
 
 func UpdateAccount(acc *Account) error {
 	mutex.Lock()
-	defer mutex.Unlock()
-
 	if _, ok := accounts[acc.ID]; !ok {
+		mutex.Unlock()
 		return errors.New("account does not exist")
 	}
 
 	accounts[acc.ID] = acc
 	data.SaveJSON("accounts.json", accounts)
+	mutex.Unlock()
 
+	notifyAccountChange(acc, false)
 	return nil
 }
 
@@ -123,9 +180,9 @@ func GetAllAccounts() []*Account {
 
 func DeleteAccount(id string) error {
 	mutex.Lock()
-	defer mutex.Unlock()
-
-	if _, ok := accounts[id]; !ok {
+	acc, ok := accounts[id]
+	if !ok {
+		mutex.Unlock()
 		return errors.New("account does not exist")
 	}
 
@@ -140,57 +197,77 @@ func DeleteAccount(id string) error {
 
 	data.SaveJSON("accounts.json", accounts)
 	data.SaveJSON("sessions.json", sessions)
+	mutex.Unlock()
 
+	revokeAccountTokens(id)
+	revokeAccountInvites(id)
+	revokeAccountResets(id)
+	ResetTOTP(id)
+	notifyAccountChange(acc, true)
 	return nil
 }
 
+// Login checks an account's password and starts a session. For accounts
+// with two-factor authentication it returns *SecondFactorRequired instead,
+// and for accounts locked after too many failures, or tried too often,
+// *LockedError.
 func Login(id, secret string) (*Session, error) {
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	acc, ok := accounts[id]
-	if !ok {
-		return nil, errors.New("account does not exist")
+	// the password check is slow, so it runs without holding the lock
+	acc, err := GetAccount(id)
+	if err != nil {
+		return nil, err
+	}
+	if until := LockedUntil(acc.ID); !until.IsZero() {
+		return nil, &LockedError{Until: until}
+	}
+	if ok, wait := AccountLoginLimiter.Allow(acc.ID); !ok {
+		return nil, &LockedError{Until: now().Add(wait)}
 	}
 
-	err := bcrypt.CompareHashAndPassword([]byte(acc.Secret), []byte(secret))
-	if err != nil {
+	if err := bcrypt.CompareHashAndPassword([]byte(acc.Secret), []byte(secret)); err != nil {
+		recordLockout(loginFailed(acc.ID))
 		return nil, errors.New("invalid account secret")
 	}
-
-	guid := uuid.New().String()
-
-	sess := &Session{
-		ID:      guid,
-		Type:    "account",
-		Token:   base64.StdEncoding.EncodeToString([]byte(guid)),
-		Account: acc.ID,
-		Created: time.Now(),
+	if err := acc.Suspended(); err != nil {
+		return nil, err
 	}
 
-	// store the session
-	sessions[guid] = sess
-	data.SaveJSON("sessions.json", sessions)
+	if TOTPEnabled(acc.ID) {
+		return nil, &SecondFactorRequired{Challenge: newChallenge(acc.ID)}
+	}
+	loginSucceeded(acc.ID)
 
-	return sess, nil
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := accounts[acc.ID]; !ok {
+		return nil, errors.New("account does not exist")
+	}
+	return newSession(acc.ID), nil
 }
 
 func Logout(tk string) error {
-	sess, err := ParseToken(tk)
-	if err != nil {
+	if _, err := ParseToken(tk); err != nil {
 		return err
 	}
 
 	mutex.Lock()
-	delete(sessions, sess.ID)
+	delete(sessions, hashToken(tk))
 	data.SaveJSON("sessions.json", sessions)
 	mutex.Unlock()
 
 	return nil
 }
 
+// GetSession returns the session named by the request's cookie and records
+// the request as its latest use. Requests authenticated by TokenMiddleware
+// get a session of type "token" for the token's account.
 func GetSession(r *http.Request) (*Session, error) {
-	c, err := r.Cookie("session")
+	if sess, ok := r.Context().Value(sessionKey{}).(*Session); ok {
+		c := *sess
+		return &c, nil
+	}
+
+	c, err := r.Cookie(sessionCookie)
 	if err != nil {
 		return nil, err
 	}
@@ -199,39 +276,18 @@ func GetSession(r *http.Request) (*Session, error) {
 		return nil, errors.New("session not found")
 	}
 
-	sess, err := ParseToken(c.Value)
-	if err != nil {
-		return nil, err
-	}
-
-	return sess, nil
+	return useSession(c.Value, ClientIP(r), r.UserAgent())
 }
 
+// ParseToken returns the session for a token if it exists and has not
+// expired.
 func ParseToken(tk string) (*Session, error) {
-	dec, err := base64.StdEncoding.DecodeString(tk)
-	if err != nil {
-		return nil, errors.New("invalid session")
-	}
-
-	id, err := uuid.Parse(string(dec))
-	if err != nil {
-		return nil, errors.New("invalid session")
-	}
-
-	mutex.Lock()
-	sess, ok := sessions[id.String()]
-	mutex.Unlock()
-
-	if !ok {
-		return nil, errors.New("session not found")
-	}
-
-	return sess, nil
+	return useSession(tk, "", "")
 }
 
+// GenerateToken returns a new random opaque token.
 func GenerateToken() string {
-	id := uuid.New().String()
-	return base64.StdEncoding.EncodeToString([]byte(id))
+	return randomString(tokenBytes)
 }
 
 func ValidateToken(tk string) error {
@@ -4,15 +4,17 @@ import (
 	"os"
 	"testing"
 	"time"
+
+	"mu/data"
 )
 
 func TestMain(m *testing.M) {
 	tmpDir, _ := os.MkdirTemp("", "mu_test_auth")
 	originalHome := os.Getenv("HOME")
 	_ = os.Setenv("HOME", tmpDir)
-
 	code := m.Run()
 
+	data.FlushIndex()
 	_ = os.Setenv("HOME", originalHome)
 	os.RemoveAll(tmpDir)
 	os.Exit(code)
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSRF(t *testing.T) {
+	useClock(t)
+	createTestAccount(t, "former")
+	sess, _ := Login("former", "password123")
+	if len(sess.CSRF) < 40 {
+		t.Fatalf("CSRF token %q", sess.CSRF)
+	}
+	api, _, _ := CreateAPIToken("former", "poster", []string{ScopeRead, ScopePosts}, time.Hour)
+
+	h := TokenMiddleware(CSRF(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(method string, form url.Values, set func(r *http.Request)) int {
+		req := httptest.NewRequest(method, "/posts", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if set != nil {
+			set(req)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	cookie := func(r *http.Request) { r.AddCookie(&http.Cookie{Name: sessionCookie, Value: sess.Token}) }
+
+	if code := serve("POST", nil, cookie); code != http.StatusForbidden {
+		t.Errorf("cookie POST without token: %d", code)
+	}
+	if code := serve("POST", url.Values{CSRFField: {"nope"}}, cookie); code != http.StatusForbidden {
+		t.Errorf("cookie POST with wrong token: %d", code)
+	}
+	if code := serve("POST", url.Values{CSRFField: {sess.CSRF}}, cookie); code != http.StatusOK {
+		t.Errorf("cookie POST with form token: %d", code)
+	}
+	if code := serve("POST", nil, func(r *http.Request) {
+		cookie(r)
+		r.Header.Set(CSRFHeader, sess.CSRF)
+	}); code != http.StatusOK {
+		t.Errorf("cookie POST with header token: %d", code)
+	}
+	if code := serve("GET", nil, cookie); code != http.StatusOK {
+		t.Errorf("GET was checked: %d", code)
+	}
+	if code := serve("POST", nil, nil); code != http.StatusOK {
+		t.Errorf("guest POST was checked: %d", code)
+	}
+	if code := serve("POST", nil, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+api) }); code != http.StatusOK {
+		t.Errorf("API token POST was checked: %d", code)
+	}
+
+	// another session's token is no good
+	other, _ := Login("former", "password123")
+	if code := serve("POST", url.Values{CSRFField: {other.CSRF}}, cookie); code != http.StatusForbidden {
+		t.Errorf("token from another session accepted: %d", code)
+	}
+}
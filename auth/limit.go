@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mu/audit"
+)
+
+// ============================================
+// RATE LIMITING
+// ============================================
+
+// Requests that can be abused, such as logins, signups, flags and chat
+// prompts, draw from a token bucket per client. Repeated failed logins also
+// lock the account for a period that doubles with each further failure.
+// State is kept in memory; a restart clears it.
+
+// Limit allows Burst requests at once, refilling one every Every.
+type Limit struct {
+	Burst int
+	Every time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a set of token buckets, one per key.
+type Limiter struct {
+	limit Limit
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewLimiter returns a limiter allowing l per key.
+func NewLimiter(l Limit) *Limiter {
+	return &Limiter{limit: l, buckets: map[string]*bucket{}}
+}
+
+// Limiters for the endpoints that need them. Keys are client IPs, except
+// that Throttle keys signed-in users as "account:"+id. AccountLoginLimiter
+// is keyed by the bare ID of the account signing in, so guesses spread over
+// many addresses are slowed too.
+var (
+	LoginLimiter        = NewLimiter(Limit{Burst: 10, Every: 30 * time.Second})
+	AccountLoginLimiter = NewLimiter(Limit{Burst: 5, Every: 30 * time.Second})
+	SignupLimiter       = NewLimiter(Limit{Burst: 3, Every: 20 * time.Minute})
+	FlagLimiter         = NewLimiter(Limit{Burst: 10, Every: time.Minute})
+	ChatLimiter         = NewLimiter(Limit{Burst: 20, Every: 6 * time.Second})
+)
+
+// Allow takes a token for key. When none is left it returns false and how
+// long until the next one.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	t := now()
+	every := l.limit.Every.Seconds()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), last: t}
+		l.buckets[key] = b
+		l.prune(t)
+	}
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+t.Sub(b.last).Seconds()/every)
+	b.last = t
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) * every * float64(time.Second))
+		return false, wait.Round(time.Second) + time.Second
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Reset refills key's bucket.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, key)
+}
+
+// prune drops buckets that have refilled, so the map does not grow with
+// every client ever seen. The caller must hold l.mu.
+func (l *Limiter) prune(t time.Time) {
+	if len(l.buckets) < 1024 {
+		return
+	}
+	full := time.Duration(l.limit.Burst) * l.limit.Every
+	for k, b := range l.buckets {
+		if t.Sub(b.last) > full {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// Throttle returns middleware that limits POST requests per account, or
+// per IP for guests, answering 429 with Retry-After when exceeded.
+func Throttle(l *Limiter) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				key := ClientIP(r)
+				if sess, err := GetSession(r); err == nil {
+					key = "account:" + sess.Account
+				}
+				if ok, wait := l.Allow(key); !ok {
+					TooManyRequests(w, r, wait)
+					return
+				}
+			}
+			next(w, r)
+		}
+	}
+}
+
+// TooManyRequests writes a 429 response asking the client to wait.
+func TooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())))
+	msg := "Too many requests, try again in " + FormatWait(wait)
+	if strings.Contains(r.Header.Get("Accept"), "application/json") ||
+		strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		tokenError(w, http.StatusTooManyRequests, msg)
+		return
+	}
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
+
+// FormatWait renders a wait as whole seconds, minutes or hours.
+func FormatWait(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%d seconds", int(math.Ceil(d.Seconds())))
+	case d < time.Hour:
+		return fmt.Sprintf("%d minutes", int(math.Ceil(d.Minutes())))
+	default:
+		return fmt.Sprintf("%d hours", int(math.Ceil(d.Hours())))
+	}
+}
+
+// ============================================
+// ACCOUNT LOCKOUT
+// ============================================
+
+const (
+	lockoutThreshold = 5              // failures before the first lockout
+	lockoutBase      = time.Minute    // first lockout
+	lockoutMax       = 24 * time.Hour // longest lockout
+	lockoutForget    = 24 * time.Hour // failures older than this are forgotten
+)
+
+// Lockout is an account locked after repeated failed logins.
+type Lockout struct {
+	Account  string    `json:"account"`
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+}
+
+// LockedError is returned by Login while an account is locked.
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return "too many failed logins, try again in " + FormatWait(e.Until.Sub(now()))
+}
+
+type failures struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+var (
+	lockMu   sync.Mutex
+	lockouts = map[string]*failures{}
+)
+
+// LockedUntil returns when an account's lockout ends, or zero if it is not
+// locked.
+func LockedUntil(account string) time.Time {
+	lockMu.Lock()
+	defer lockMu.Unlock()
+	if f, ok := lockouts[account]; ok && now().Before(f.until) {
+		return f.until
+	}
+	return time.Time{}
+}
+
+// loginFailed counts a failed password or code. From the threshold on each
+// failure locks the account, for twice as long as the last time, and the
+// lockout is returned for recordLockout. Callers may hold the accounts lock,
+// so nothing is written here.
+func loginFailed(account string) *audit.Event {
+	t := now()
+
+	lockMu.Lock()
+	f, ok := lockouts[account]
+	if !ok || t.Sub(f.last) > lockoutForget {
+		f = &failures{}
+		lockouts[account] = f
+	}
+	f.count++
+	f.last = t
+	over := f.count - lockoutThreshold
+	if over < 0 {
+		lockMu.Unlock()
+		return nil
+	}
+	d := lockoutMax
+	if over < 20 {
+		d = min(lockoutBase<<over, lockoutMax)
+	}
+	f.until = t.Add(d)
+	count := f.count
+	lockMu.Unlock()
+
+	fmt.Printf("[auth] Locked %s for %s after %d failed logins\n", account, d, count)
+	return &audit.Event{
+		Actor:  "system",
+		Action: "auth.lockout",
+		Target: account,
+		Detail: fmt.Sprintf("locked for %s after %d failed logins", d, count),
+	}
+}
+
+// recordLockout writes a lockout from loginFailed to the audit log. It
+// must be called without the accounts lock held.
+func recordLockout(e *audit.Event) {
+	if e != nil {
+		audit.Record(*e)
+	}
+}
+
+// loginSucceeded clears an account's failures.
+func loginSucceeded(account string) {
+	lockMu.Lock()
+	delete(lockouts, account)
+	lockMu.Unlock()
+}
+
+// ListLockouts returns accounts that are locked now, soonest to unlock
+// first.
+func ListLockouts() []Lockout {
+	t := now()
+
+	lockMu.Lock()
+	defer lockMu.Unlock()
+
+	var list []Lockout
+	for id, f := range lockouts {
+		if t.Before(f.until) {
+			list = append(list, Lockout{Account: id, Failures: f.count, Until: f.until})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Until.Before(list[j].Until)
+	})
+	return list
+}
+
+// Unlock lifts an account's lockout and forgets its failures and recent
+// login attempts.
+func Unlock(account string) {
+	loginSucceeded(account)
+	AccountLoginLimiter.Reset(account)
+}
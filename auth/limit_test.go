@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"mu/audit"
+)
+
+func TestLimiter(t *testing.T) {
+	clock := useClock(t)
+	l := NewLimiter(Limit{Burst: 3, Every: 10 * time.Second})
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d refused within the burst", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait <= 0 || wait > 11*time.Second {
+		t.Errorf("fourth request: ok=%v wait=%v", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("keys share a bucket")
+	}
+
+	*clock = clock.Add(10 * time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("bucket did not refill")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("refilled more than one token")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	useClock(t)
+	createTestAccount(t, "chatty")
+	sess, _ := Login("chatty", "password123")
+
+	l := NewLimiter(Limit{Burst: 1, Every: time.Minute})
+	h := Throttle(l)(func(w http.ResponseWriter, r *http.Request) {})
+	serve := func(method string, cookie bool) int {
+		req := httptest.NewRequest(method, "/chat", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if cookie {
+			req.AddCookie(&http.Cookie{Name: sessionCookie, Value: sess.Token})
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec.Code
+	}
+
+	if serve("POST", true) != http.StatusOK || serve("POST", true) != http.StatusTooManyRequests {
+		t.Error("account not limited")
+	}
+	if serve("POST", false) != http.StatusOK {
+		t.Error("guest on the same IP shares the account's bucket")
+	}
+	if serve("GET", true) != http.StatusOK {
+		t.Error("GET was throttled")
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	clock := useClock(t)
+	createTestAccount(t, "target")
+	t.Cleanup(func() { Unlock("target") })
+
+	for i := 0; i < lockoutThreshold-1; i++ {
+		Login("target", "wrong")
+	}
+	if !LockedUntil("target").IsZero() {
+		t.Fatal("locked before the threshold")
+	}
+
+	Login("target", "wrong")
+	until := LockedUntil("target")
+	if !until.Equal(clock.Add(lockoutBase)) {
+		t.Fatalf("first lockout until %v, want %v", until, clock.Add(lockoutBase))
+	}
+	_, err := Login("target", "password123")
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("correct password while locked: %v", err)
+	}
+	if list := ListLockouts(); len(list) != 1 || list[0].Account != "target" {
+		t.Errorf("ListLockouts = %+v", list)
+	}
+
+	// each further failure doubles the lockout
+	*clock = until
+	Login("target", "wrong")
+	if got := LockedUntil("target").Sub(*clock); got != 2*lockoutBase {
+		t.Errorf("second lockout %v, want %v", got, 2*lockoutBase)
+	}
+
+	*clock = LockedUntil("target")
+	if _, err := Login("target", "password123"); err != nil {
+		t.Fatalf("login after lockout: %v", err)
+	}
+	Login("target", "wrong")
+	if !LockedUntil("target").IsZero() {
+		t.Error("a successful login did not reset failures")
+	}
+
+	b, _ := os.ReadFile(audit.File())
+	if !strings.Contains(string(b), `"action":"auth.lockout","target":"target"`) {
+		t.Errorf("lockout not in the audit log: %s", b)
+	}
+}
+
+func TestAccountLoginLimit(t *testing.T) {
+	clock := useClock(t)
+	createTestAccount(t, "sprayed")
+	t.Cleanup(func() { Unlock("sprayed") })
+
+	// correct guesses count too, so the limit holds below the lockout
+	for i := 0; i < 5; i++ {
+		if _, err := Login("sprayed", "password123"); err != nil {
+			t.Fatalf("login %d: %v", i+1, err)
+		}
+	}
+	_, err := Login("sprayed", "password123")
+	var locked *LockedError
+	if !errors.As(err, &locked) || !locked.Until.After(*clock) {
+		t.Fatalf("sixth login: %v", err)
+	}
+	if !LockedUntil("sprayed").IsZero() {
+		t.Error("rate limit counted as a lockout")
+	}
+
+	*clock = clock.Add(30 * time.Second)
+	if _, err := Login("sprayed", "password123"); err != nil {
+		t.Errorf("login after refill: %v", err)
+	}
+	Unlock("sprayed")
+	if _, err := Login("sprayed", "password123"); err != nil {
+		t.Errorf("login after unlock: %v", err)
+	}
+}
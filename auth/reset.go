@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"mu/data"
+)
+
+// ============================================
+// PASSWORD RESET
+// ============================================
+
+// There is no email to recover an account with, so an admin issues a reset
+// code instead and passes it on privately. A code works once, for one
+// account, until it expires. Issuing a new code replaces the account's
+// previous one. Codes are kept only as hashes.
+
+const resetsFile = "resets.json"
+
+// ResetCodeTTL is how long a reset code issued from the admin page lasts.
+const ResetCodeTTL = 24 * time.Hour
+
+// ErrInvalidResetCode is returned for an unknown, used or expired code.
+var ErrInvalidResetCode = errors.New("invalid or expired reset code")
+
+// ResetCode is a pending password reset. The code itself is never stored.
+type ResetCode struct {
+	Account string    `json:"account"`
+	Creator string    `json:"creator"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+var (
+	resetMu sync.Mutex
+	resets  = map[string]*ResetCode{} // keyed by code hash
+)
+
+func init() {
+	data.RegisterSensitive(resetsFile)
+}
+
+func loadResets() {
+	resetMu.Lock()
+	defer resetMu.Unlock()
+	b, _ := data.LoadFile(resetsFile)
+	json.Unmarshal(b, &resets)
+}
+
+// CreateResetCode issues a code from creator that sets a new password for
+// account until ttl has passed.
+func CreateResetCode(account, creator string, ttl time.Duration) (string, *ResetCode, error) {
+	if ttl <= 0 {
+		return "", nil, errors.New("a reset code must expire")
+	}
+	if _, err := GetAccount(account); err != nil {
+		return "", nil, err
+	}
+
+	code := newCode()
+	t := now()
+	rc := &ResetCode{
+		Account: account,
+		Creator: creator,
+		Created: t,
+		Expires: t.Add(ttl),
+	}
+
+	resetMu.Lock()
+	defer resetMu.Unlock()
+	for key, old := range resets {
+		if old.Account == account || !t.Before(old.Expires) {
+			delete(resets, key)
+		}
+	}
+	resets[hashToken(code)] = rc
+	data.SaveJSON(resetsFile, resets)
+
+	fmt.Printf("[auth] Reset code for %s issued by %s\n", account, creator)
+	c := *rc
+	return code, &c, nil
+}
+
+// ResetPassword uses up a reset code to set its account's password. Every
+// session and API token of the account ends and any lockout is lifted. It
+// returns the account reset.
+func ResetPassword(code, secret string) (string, error) {
+	if len(secret) < MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	key := hashToken(normalizeCode(code))
+
+	resetMu.Lock()
+	rc, ok := resets[key]
+	if !ok || !now().Before(rc.Expires) {
+		resetMu.Unlock()
+		return "", ErrInvalidResetCode
+	}
+	delete(resets, key)
+	data.SaveJSON(resetsFile, resets)
+	resetMu.Unlock()
+
+	if _, err := GetAccount(rc.Account); err != nil {
+		return "", ErrInvalidResetCode
+	}
+	if err := setPassword(rc.Account, secret); err != nil {
+		return "", err
+	}
+	RevokeSessions(rc.Account, "")
+	revokeAccountTokens(rc.Account)
+	Unlock(rc.Account)
+
+	fmt.Printf("[auth] Password for %s reset with a code from %s\n", rc.Account, rc.Creator)
+	return rc.Account, nil
+}
+
+// PendingReset returns the unexpired reset code issued for an account, if
+// any.
+func PendingReset(account string) *ResetCode {
+	resetMu.Lock()
+	defer resetMu.Unlock()
+	t := now()
+	for _, rc := range resets {
+		if rc.Account == account && t.Before(rc.Expires) {
+			c := *rc
+			return &c
+		}
+	}
+	return nil
+}
+
+// revokeAccountResets deletes any reset code issued for an account.
+func revokeAccountResets(account string) {
+	resetMu.Lock()
+	defer resetMu.Unlock()
+	changed := false
+	for key, rc := range resets {
+		if rc.Account == account {
+			delete(resets, key)
+			changed = true
+		}
+	}
+	if changed {
+		data.SaveJSON(resetsFile, resets)
+	}
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResetPassword(t *testing.T) {
+	clock := useClock(t)
+	createTestAccount(t, "forgetful")
+	sess, _ := Login("forgetful", "password123")
+	tk, _, _ := CreateAPIToken("forgetful", "script", []string{ScopeRead}, time.Hour)
+	for i := 0; i < lockoutThreshold; i++ {
+		Login("forgetful", "wrong")
+	}
+	t.Cleanup(func() { Unlock("forgetful") })
+
+	first, _, err := CreateResetCode("forgetful", "admin", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, rc, err := CreateResetCode("forgetful", "admin", time.Hour)
+	if err != nil || rc.Account != "forgetful" || !rc.Expires.Equal(clock.Add(time.Hour)) {
+		t.Fatalf("CreateResetCode = %+v, %v", rc, err)
+	}
+	if _, err := ResetPassword(first, "newsecret"); !errors.Is(err, ErrInvalidResetCode) {
+		t.Errorf("replaced code: %v", err)
+	}
+	if PendingReset("forgetful") == nil {
+		t.Error("no pending reset")
+	}
+
+	id, err := ResetPassword(strings.ToUpper(code), "newsecret")
+	if err != nil || id != "forgetful" {
+		t.Fatalf("ResetPassword = %q, %v", id, err)
+	}
+	if _, err := useSession(sess.Token, "", ""); err == nil {
+		t.Error("session survived the reset")
+	}
+	if _, err := ResolveAPIToken(tk, ""); err == nil {
+		t.Error("API token survived the reset")
+	}
+	if _, err := Login("forgetful", "newsecret"); err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+	if _, err := ResetPassword(code, "another"); !errors.Is(err, ErrInvalidResetCode) {
+		t.Errorf("code used twice: %v", err)
+	}
+
+	code, _, _ = CreateResetCode("forgetful", "admin", time.Hour)
+	*clock = clock.Add(time.Hour)
+	if _, err := ResetPassword(code, "another"); !errors.Is(err, ErrInvalidResetCode) {
+		t.Errorf("expired code: %v", err)
+	}
+}
@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"mu/audit"
+	"mu/data"
+)
+
+// ============================================
+// ROLES AND PERMISSIONS
+// ============================================
+
+// Every account has a role, and each role grants a fixed set of named
+// permissions. Requests without a session act as a guest. Roles are ranked;
+// a paid membership lifts a user to at least the member role.
+
+// Role names an account's level of trust.
+type Role string
+
+const (
+	RoleGuest     Role = "guest"
+	RoleUser      Role = "user"
+	RoleMember    Role = "member"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Roles lists every role, most trusted first.
+var Roles = []Role{RoleAdmin, RoleModerator, RoleMember, RoleUser, RoleGuest}
+
+// Permission names an action that can be granted to a role.
+type Permission string
+
+const (
+	PermChat     Permission = "chat"     // send chat prompts and messages
+	PermPost     Permission = "post"     // write and edit own posts
+	PermFlag     Permission = "flag"     // flag content for moderation
+	PermInvite   Permission = "invite"   // create signup invite codes
+	PermModerate Permission = "moderate" // review, approve and delete flagged content
+	PermUsers    Permission = "users"    // manage accounts and roles
+	PermSettings Permission = "settings" // edit global settings and API keys
+	PermBackup   Permission = "backup"   // download backups of all data
+)
+
+var (
+	// ErrPending is the refusal given to accounts awaiting approval.
+	ErrPending = errors.New("your account is awaiting approval by an admin")
+
+	errLastAdmin = errors.New("at least one admin is required")
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleGuest:     {PermChat},
+	RoleUser:      {PermChat, PermPost, PermFlag},
+	RoleMember:    {PermChat, PermPost, PermFlag, PermInvite},
+	RoleModerator: {PermChat, PermPost, PermFlag, PermInvite, PermModerate},
+	RoleAdmin:     {PermChat, PermPost, PermFlag, PermInvite, PermModerate, PermUsers, PermSettings, PermBackup},
+}
+
+func init() {
+	data.RegisterMigration(data.Migration{
+		Version: 3,
+		Name:    "replace admin flag with roles",
+		Apply:   migrateRoles,
+	})
+}
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// rank orders roles from guest (0) to admin.
+func (r Role) rank() int {
+	return len(Roles) - 1 - slices.Index(Roles, r)
+}
+
+// Permissions returns what a role may do.
+func (r Role) Permissions() []Permission {
+	return rolePermissions[r]
+}
+
+// Has reports whether a role grants perm.
+func (r Role) Has(perm Permission) bool {
+	return slices.Contains(rolePermissions[r], perm)
+}
+
+// GetRole returns the role an account acts with: its assigned role, or user
+// if none is set, raised to member while it holds a paid membership.
+func (a *Account) GetRole() Role {
+	role := Role(a.Role)
+	if !role.Valid() {
+		role = RoleUser
+	}
+	if a.Member && role.rank() < RoleMember.rank() {
+		role = RoleMember
+	}
+	return role
+}
+
+// Can reports whether an account's role grants perm. Accounts awaiting
+// approval may do nothing until an admin approves them, nor may suspended
+// accounts until the suspension is lifted.
+func (a *Account) Can(perm Permission) bool {
+	return !a.Pending && a.Suspended() == nil && a.GetRole().Has(perm)
+}
+
+// Refusal explains why an account is refused the permissions its role
+// grants, or returns nil.
+func (a *Account) Refusal() error {
+	if err := a.Suspended(); err != nil {
+		return err
+	}
+	if a.Pending {
+		return ErrPending
+	}
+	return nil
+}
+
+// IsAdmin reports whether an account has the admin role.
+func (a *Account) IsAdmin() bool {
+	return a.GetRole() == RoleAdmin
+}
+
+// SetRole assigns a role to an account. The last admin cannot be demoted.
+func SetRole(id string, role Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	acc, err := GetAccount(id)
+	if err != nil {
+		return err
+	}
+	if role != RoleAdmin && isLastAdmin(acc) {
+		return errLastAdmin
+	}
+	c := *acc
+	c.Role = string(role)
+	return UpdateAccount(&c)
+}
+
+// isLastAdmin reports whether acc is the only admin left.
+func isLastAdmin(acc *Account) bool {
+	if !acc.IsAdmin() {
+		return false
+	}
+	admins := 0
+	for _, a := range GetAllAccounts() {
+		if a.IsAdmin() {
+			admins++
+		}
+	}
+	return admins <= 1
+}
+
+// RequestRole returns the role of the account making a request, or guest.
+func RequestRole(r *http.Request) Role {
+	if acc := requestAccount(r); acc != nil {
+		return acc.GetRole()
+	}
+	return RoleGuest
+}
+
+// requestAccount returns the account making a request, or nil for guests.
+func requestAccount(r *http.Request) *Account {
+	sess, err := GetSession(r)
+	if err != nil {
+		return nil
+	}
+	acc, err := GetAccount(sess.Account)
+	if err != nil {
+		return nil
+	}
+	return acc
+}
+
+// Can reports whether the request's account, or a guest, holds perm.
+func Can(r *http.Request, perm Permission) bool {
+	if acc := requestAccount(r); acc != nil {
+		return acc.Can(perm)
+	}
+	return RoleGuest.Has(perm)
+}
+
+// Refusal explains why the request's account is refused the permissions
+// its role grants, or returns nil. Guests are never refused this way.
+func Refusal(r *http.Request) error {
+	if acc := requestAccount(r); acc != nil {
+		return acc.Refusal()
+	}
+	return nil
+}
+
+// Audit records a privileged action taken by the request's account in the
+// audit log, filling in the actor and IP.
+func Audit(r *http.Request, e audit.Event) {
+	if e.Actor == "" {
+		e.Actor = "guest"
+		if sess, err := GetSession(r); err == nil {
+			e.Actor = sess.Account
+		}
+	}
+	if e.IP == "" {
+		e.IP = ClientIP(r)
+	}
+	audit.Record(e)
+}
+
+// Require returns middleware that lets a request through only if its
+// account holds perm. Guests are sent to /login, signed in accounts without
+// the permission get 403.
+func Require(perm Permission) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !allow(w, r, perm) {
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// RequireWrite is Require applied only to requests that change state, so
+// pages can still be viewed by anyone.
+func RequireWrite(perm Permission) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead && !allow(w, r, perm) {
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// allow checks perm for a request and writes the refusal if it is missing.
+func allow(w http.ResponseWriter, r *http.Request, perm Permission) bool {
+	acc := requestAccount(r)
+	if (acc == nil && RoleGuest.Has(perm)) || (acc != nil && acc.Can(perm)) {
+		return true
+	}
+
+	wantsJSON := strings.Contains(r.Header.Get("Accept"), "application/json") ||
+		strings.Contains(r.Header.Get("Content-Type"), "application/json")
+	switch {
+	case acc == nil && wantsJSON:
+		tokenError(w, http.StatusUnauthorized, "sign in required")
+	case acc == nil:
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	case acc.Refusal() != nil && wantsJSON:
+		tokenError(w, http.StatusForbidden, acc.Refusal().Error())
+	case acc.Refusal() != nil:
+		http.Error(w, "Forbidden - "+acc.Refusal().Error(), http.StatusForbidden)
+	case wantsJSON:
+		tokenError(w, http.StatusForbidden, fmt.Sprintf("%s permission required", perm))
+	default:
+		http.Error(w, fmt.Sprintf("Forbidden - %s permission required", perm), http.StatusForbidden)
+	}
+	return false
+}
+
+// ============================================
+// MIGRATION
+// ============================================
+
+// migrateRoles gives accounts saved with the old admin flag the admin role
+// and drops the flag.
+func migrateRoles(tx data.Tx) error {
+	b, err := tx.Get("accounts.json")
+	if data.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("parse accounts.json: %w", err)
+	}
+
+	changed := false
+	for id, acc := range raw {
+		flag, ok := acc["admin"]
+		if !ok {
+			continue
+		}
+		changed = true
+		delete(acc, "admin")
+
+		var admin bool
+		if err := json.Unmarshal(flag, &admin); err != nil {
+			return fmt.Errorf("parse account %s: %w", id, err)
+		}
+		if _, hasRole := acc["role"]; !hasRole && admin {
+			acc["role"] = json.RawMessage(`"admin"`)
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	nb, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return tx.Put("accounts.json", nb)
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"mu/data"
+)
+
+func TestAccountRoles(t *testing.T) {
+	acc := &Account{ID: "r"}
+	if acc.GetRole() != RoleUser || !acc.Can(PermPost) || acc.Can(PermModerate) {
+		t.Errorf("account without a role acts as %s", acc.GetRole())
+	}
+
+	acc.Member = true
+	if acc.GetRole() != RoleMember {
+		t.Errorf("paid member acts as %s", acc.GetRole())
+	}
+	acc.Role = string(RoleModerator)
+	if acc.GetRole() != RoleModerator || !acc.Can(PermModerate) || acc.Can(PermSettings) {
+		t.Errorf("membership lowered a moderator to %s", acc.GetRole())
+	}
+	acc.Role = string(RoleGuest)
+	acc.Member = false
+	if acc.Can(PermPost) || !acc.Can(PermChat) {
+		t.Error("guest role may post or may not chat")
+	}
+	for _, perm := range []Permission{PermChat, PermPost, PermFlag, PermModerate, PermUsers, PermSettings, PermBackup} {
+		if !RoleAdmin.Has(perm) {
+			t.Errorf("admin lacks %s", perm)
+		}
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	createTestAccount(t, "boss")
+	createTestAccount(t, "helper")
+
+	if err := SetRole("helper", "superuser"); err == nil {
+		t.Error("unknown role accepted")
+	}
+	if err := SetRole("boss", RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetRole("boss", RoleUser); err != errLastAdmin {
+		t.Errorf("demoting the last admin: %v", err)
+	}
+
+	SetRole("helper", RoleAdmin)
+	if err := SetRole("boss", RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	if acc, _ := GetAccount("boss"); acc.GetRole() != RoleModerator {
+		t.Errorf("boss is %s", acc.GetRole())
+	}
+}
+
+func TestRequire(t *testing.T) {
+	useClock(t)
+	createTestAccount(t, "mod")
+	createTestAccount(t, "plain")
+	SetRole("mod", RoleModerator)
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	moderate := Require(PermModerate)(ok)
+	post := RequireWrite(PermPost)(ok)
+
+	as := func(id string) func(*http.Request) {
+		return func(r *http.Request) {
+			if id == "" {
+				return
+			}
+			sess, _ := Login(id, "password123")
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: sess.Token})
+		}
+	}
+	serve := func(h http.HandlerFunc, method string, set func(*http.Request), hdr ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/moderate", nil)
+		set(req)
+		if len(hdr) == 2 {
+			req.Header.Set(hdr[0], hdr[1])
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	if rec := serve(moderate, "GET", as("")); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+		t.Errorf("guest on /moderate: %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := serve(moderate, "GET", as(""), "Accept", "application/json"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("guest JSON request: %d", rec.Code)
+	}
+	if rec := serve(moderate, "POST", as("plain")); rec.Code != http.StatusForbidden {
+		t.Errorf("user on /moderate: %d", rec.Code)
+	}
+	if rec := serve(moderate, "POST", as("mod")); rec.Code != http.StatusOK {
+		t.Errorf("moderator on /moderate: %d", rec.Code)
+	}
+
+	if rec := serve(post, "GET", as("")); rec.Code != http.StatusOK {
+		t.Errorf("guest viewing a RequireWrite page: %d", rec.Code)
+	}
+	if rec := serve(post, "POST", as("")); rec.Code != http.StatusSeeOther {
+		t.Errorf("guest posting: %d", rec.Code)
+	}
+	if rec := serve(post, "POST", as("plain")); rec.Code != http.StatusOK {
+		t.Errorf("user posting: %d", rec.Code)
+	}
+}
+
+func TestMigrateRoles(t *testing.T) {
+	legacy := `{"root":{"id":"root","admin":true,"member":false},"pat":{"id":"pat","admin":false,"member":true}}`
+	data.SaveFile("accounts.json", legacy)
+	t.Cleanup(func() {
+		mutex.Lock()
+		data.SaveJSON("accounts.json", accounts)
+		mutex.Unlock()
+	})
+
+	apply := func() {
+		if err := data.CurrentStore().Tx(migrateRoles); err != nil {
+			t.Fatalf("migrateRoles: %v", err)
+		}
+	}
+	apply()
+	migrated, _ := data.LoadFile("accounts.json")
+	apply()
+	if again, _ := data.LoadFile("accounts.json"); string(again) != string(migrated) {
+		t.Error("migration is not idempotent")
+	}
+
+	var got map[string]*Account
+	json.Unmarshal(migrated, &got)
+	if got["root"].GetRole() != RoleAdmin || got["pat"].GetRole() != RoleMember {
+		t.Errorf("migrated roles: root=%s pat=%s", got["root"].GetRole(), got["pat"].GetRole())
+	}
+	var raw map[string]map[string]any
+	json.Unmarshal(migrated, &raw)
+	if _, ok := raw["root"]["admin"]; ok {
+		t.Error("admin flag kept")
+	}
+}
@@ -0,0 +1,530 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mu/data"
+)
+
+// ============================================
+// SESSIONS
+// ============================================
+
+// A session token is 32 random bytes handed to the browser once. Only its
+// SHA-256 is kept, as the key in sessions.json, so the file cannot be used
+// to sign in. Sessions end after Idle without use or at Absolute after
+// login, whichever comes first; each use slides the idle window.
+
+const (
+	sessionCookie = "session"
+	tokenBytes    = 32
+	sessionIDSize = 8
+
+	// how often LastSeen is written back, so busy sessions don't rewrite
+	// sessions.json on every request
+	touchInterval = time.Minute
+	purgeInterval = time.Hour
+
+	maxAgentLen = 256
+)
+
+// SessionPolicy limits how long sessions last. Zero fields fall back to the
+// defaults.
+type SessionPolicy struct {
+	Idle     time.Duration // ends a session not used for this long
+	Absolute time.Duration // ends a session this long after login
+}
+
+// DefaultSessionPolicy applies unless overridden by SetSessionPolicy or
+// MU_SESSION_IDLE and MU_SESSION_MAX, e.g. MU_SESSION_IDLE=7d.
+var DefaultSessionPolicy = SessionPolicy{
+	Idle:     14 * 24 * time.Hour,
+	Absolute: 90 * 24 * time.Hour,
+}
+
+var (
+	policyMu      sync.RWMutex
+	sessionPolicy = DefaultSessionPolicy
+
+	purgerOnce sync.Once
+
+	// now is the clock used for sessions, replaced in tests
+	now = time.Now
+)
+
+func init() {
+	p := DefaultSessionPolicy
+	envDuration("MU_SESSION_IDLE", &p.Idle)
+	envDuration("MU_SESSION_MAX", &p.Absolute)
+	SetSessionPolicy(p)
+
+	data.RegisterMigration(data.Migration{
+		Version: 2,
+		Name:    "hash session tokens and add expiry",
+		Apply:   migrateSessions,
+	})
+}
+
+func envDuration(name string, dst *time.Duration) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return
+	}
+	d, err := parseDuration(v)
+	if err != nil || d <= 0 {
+		fmt.Printf("[auth] Ignoring invalid %s=%q\n", name, v)
+		return
+	}
+	*dst = d
+}
+
+// parseDuration accepts Go durations plus a "d" suffix for days.
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("bad day count %q", days)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// SetSessionPolicy replaces the session limits. Existing sessions are
+// judged by the new limits from their next use.
+func SetSessionPolicy(p SessionPolicy) {
+	if p.Idle <= 0 {
+		p.Idle = DefaultSessionPolicy.Idle
+	}
+	if p.Absolute <= 0 {
+		p.Absolute = DefaultSessionPolicy.Absolute
+	}
+
+	policyMu.Lock()
+	sessionPolicy = p
+	policyMu.Unlock()
+}
+
+// GetSessionPolicy returns the session limits in effect.
+func GetSessionPolicy() SessionPolicy {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	return sessionPolicy
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashToken(tk string) string {
+	sum := sha256.Sum256([]byte(tk))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSession starts a session for an account. The caller must hold mutex.
+func newSession(account string) *Session {
+	tk := GenerateToken()
+	t := now()
+
+	b := make([]byte, sessionIDSize)
+	rand.Read(b)
+
+	sess := &Session{
+		ID:       hex.EncodeToString(b),
+		Type:     "account",
+		Account:  account,
+		Created:  t,
+		LastSeen: t,
+		Expires:  t.Add(GetSessionPolicy().Absolute),
+		CSRF:     randomString(tokenBytes),
+	}
+	sessions[hashToken(tk)] = sess
+	data.SaveJSON("sessions.json", sessions)
+
+	out := *sess
+	out.Token = tk
+	return &out
+}
+
+// expired reports whether a session has passed either limit at t.
+func (s *Session) expired(t time.Time, p SessionPolicy) bool {
+	if !s.Expires.IsZero() && !t.Before(s.Expires) {
+		return true
+	}
+	if s.Created.Add(p.Absolute).Before(t) {
+		return true
+	}
+	return t.Sub(s.LastSeen) > p.Idle
+}
+
+// useSession looks up a token, ends the session if it has expired, and
+// otherwise records the use. ip and agent are empty when the caller has no
+// request. It returns a copy carrying the token.
+func useSession(tk, ip, agent string) (*Session, error) {
+	if tk == "" {
+		return nil, errors.New("session not found")
+	}
+	key := hashToken(tk)
+	t := now()
+	p := GetSessionPolicy()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	sess, ok := sessions[key]
+	if !ok {
+		return nil, errors.New("session not found")
+	}
+	if sess.expired(t, p) {
+		delete(sessions, key)
+		data.SaveJSON("sessions.json", sessions)
+		return nil, errors.New("session expired")
+	}
+
+	if len(agent) > maxAgentLen {
+		agent = agent[:maxAgentLen]
+	}
+	changed := t.Sub(sess.LastSeen) >= touchInterval
+	if ip != "" && ip != sess.IP {
+		sess.IP = ip
+		changed = true
+	}
+	if agent != "" && agent != sess.Agent {
+		sess.Agent = agent
+		changed = true
+	}
+	if sess.Type == "account" && sess.CSRF == "" {
+		// sessions from before CSRF tokens
+		sess.CSRF = randomString(tokenBytes)
+		changed = true
+	}
+	if changed {
+		sess.LastSeen = t
+		data.SaveJSON("sessions.json", sessions)
+	}
+
+	out := *sess
+	out.Token = tk
+	return &out, nil
+}
+
+// SessionExpiry is when a session will end if it is not used again.
+func SessionExpiry(sess *Session) time.Time {
+	idle := sess.LastSeen.Add(GetSessionPolicy().Idle)
+	if !sess.Expires.IsZero() && sess.Expires.Before(idle) {
+		return sess.Expires
+	}
+	return idle
+}
+
+// ListSessions returns an account's sessions, most recently used first.
+func ListSessions(account string) []*Session {
+	t := now()
+	p := GetSessionPolicy()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var list []*Session
+	for _, sess := range sessions {
+		if sess.Account == account && !sess.expired(t, p) {
+			c := *sess
+			list = append(list, &c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+	return list
+}
+
+// RevokeSession ends one of an account's sessions by ID.
+func RevokeSession(account, id string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for key, sess := range sessions {
+		if sess.Account == account && sess.ID == id {
+			delete(sessions, key)
+			data.SaveJSON("sessions.json", sessions)
+			return nil
+		}
+	}
+	return errors.New("session not found")
+}
+
+// RevokeSessions ends every session of an account except the one with ID
+// keep, which may be empty, and returns how many were ended.
+func RevokeSessions(account, keep string) int {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	n := 0
+	for key, sess := range sessions {
+		if sess.Account == account && (keep == "" || sess.ID != keep) {
+			delete(sessions, key)
+			n++
+		}
+	}
+	if n > 0 {
+		data.SaveJSON("sessions.json", sessions)
+	}
+	return n
+}
+
+// PurgeSessions removes expired sessions and returns how many it removed.
+func PurgeSessions() int {
+	t := now()
+	p := GetSessionPolicy()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	n := 0
+	for key, sess := range sessions {
+		if sess.expired(t, p) {
+			delete(sessions, key)
+			n++
+		}
+	}
+	if n > 0 {
+		data.SaveJSON("sessions.json", sessions)
+	}
+	return n
+}
+
+// startPurger removes expired sessions in the background every hour.
+func startPurger() {
+	purgerOnce.Do(func() {
+		go func() {
+			for {
+				if n := PurgeSessions(); n > 0 {
+					fmt.Printf("[auth] Purged %d expired sessions\n", n)
+				}
+				if n := liftExpiredSuspensions(); n > 0 {
+					fmt.Printf("[auth] Lifted %d expired suspensions\n", n)
+				}
+				time.Sleep(purgeInterval)
+			}
+		}()
+	})
+}
+
+// ============================================
+// COOKIES
+// ============================================
+
+// SetSessionCookie gives the browser a session's token. The cookie lasts
+// until the session's absolute expiry and is kept from scripts.
+func SetSessionCookie(w http.ResponseWriter, r *http.Request, sess *Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    sess.Token,
+		Path:     "/",
+		Expires:  sess.Expires,
+		HttpOnly: true,
+		Secure:   IsSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ClearSessionCookie removes the session cookie from the browser.
+func ClearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   IsSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// IsSecure reports whether the request arrived over HTTPS, directly or
+// through a proxy.
+func IsSecure(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// ClientIP returns the address a request came from. X-Forwarded-For is
+// only believed when the request arrives from a trusted proxy, and then the
+// right-most hop not added by a trusted proxy is taken, since hops further
+// left are whatever the client sent.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	ip := host
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		ip = hop
+		if !trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// ============================================
+// TRUSTED PROXIES
+// ============================================
+
+// Proxies whose X-Forwarded-For header is believed are set with
+// MU_TRUSTED_PROXIES, a comma separated list of addresses or CIDR ranges,
+// e.g. MU_TRUSTED_PROXIES=127.0.0.1,10.0.0.0/8. With none set, requests
+// are keyed by the address that connected.
+
+var (
+	proxyMu        sync.RWMutex
+	trustedProxies []netip.Prefix
+)
+
+func init() {
+	v := strings.TrimSpace(os.Getenv("MU_TRUSTED_PROXIES"))
+	if v == "" {
+		return
+	}
+	if err := SetTrustedProxies(strings.Split(v, ",")); err != nil {
+		fmt.Printf("[auth] Ignoring invalid MU_TRUSTED_PROXIES=%q: %v\n", v, err)
+	}
+}
+
+// SetTrustedProxies replaces the proxies whose X-Forwarded-For header
+// ClientIP believes. Each entry is an address or a CIDR range.
+func SetTrustedProxies(list []string) error {
+	var prefixes []netip.Prefix
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	proxyMu.Lock()
+	defer proxyMu.Unlock()
+	trustedProxies = prefixes
+	return nil
+}
+
+// trustedProxy reports whether ip belongs to a trusted proxy.
+func trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	proxyMu.RLock()
+	defer proxyMu.RUnlock()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================
+// MIGRATION
+// ============================================
+
+// migrateSessions rewrites sessions saved before tokens were hashed, which
+// were keyed by ID with the token stored alongside. Old cookies keep
+// working because the new key is the hash of the old token, and their idle
+// window starts at the migration. Sessions already past the absolute limit
+// are dropped.
+func migrateSessions(tx data.Tx) error {
+	b, err := tx.Get("sessions.json")
+	if data.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("parse sessions.json: %w", err)
+	}
+
+	type legacySession struct {
+		Session
+		Token string `json:"token"`
+	}
+
+	t := now()
+	p := GetSessionPolicy()
+	out := make(map[string]*Session, len(raw))
+	changed := false
+	for key, msg := range raw {
+		var s legacySession
+		if err := json.Unmarshal(msg, &s); err != nil {
+			return fmt.Errorf("parse session %s: %w", key, err)
+		}
+		if s.Token == "" {
+			out[key] = &s.Session
+			continue
+		}
+
+		changed = true
+		sess := s.Session
+		b := make([]byte, sessionIDSize)
+		rand.Read(b)
+		sess.ID = hex.EncodeToString(b)
+		sess.LastSeen = t
+		sess.Expires = sess.Created.Add(p.Absolute)
+		if !t.Before(sess.Expires) {
+			continue
+		}
+		out[hashToken(s.Token)] = &sess
+	}
+	if !changed {
+		return nil
+	}
+
+	nb, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	return tx.Put("sessions.json", nb)
+}
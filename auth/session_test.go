@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mu/data"
+)
+
+// useClock replaces the session clock with one the test can move.
+func useClock(t *testing.T) *time.Time {
+	t.Helper()
+	clock := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+	return &clock
+}
+
+func createTestAccount(t *testing.T, id string) {
+	t.Helper()
+	if err := Create(&Account{ID: id, Name: id, Secret: "password123", Created: time.Now()}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	t.Cleanup(func() { DeleteAccount(id) })
+}
+
+func TestSessionExpiry(t *testing.T) {
+	clock := useClock(t)
+	SetSessionPolicy(SessionPolicy{Idle: time.Hour, Absolute: 3 * time.Hour})
+	t.Cleanup(func() { SetSessionPolicy(DefaultSessionPolicy) })
+	createTestAccount(t, "expiry")
+
+	sess, err := Login("expiry", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sess.Token) < 40 {
+		t.Errorf("token %q is too short to be opaque", sess.Token)
+	}
+
+	// each use slides the idle window
+	for i := 0; i < 2; i++ {
+		*clock = clock.Add(50 * time.Minute)
+		if _, err := ParseToken(sess.Token); err != nil {
+			t.Fatalf("session ended after %d uses: %v", i, err)
+		}
+	}
+
+	*clock = clock.Add(61 * time.Minute)
+	if _, err := ParseToken(sess.Token); err == nil {
+		t.Error("session survived an idle hour")
+	}
+
+	// the absolute limit holds however often the session is used
+	sess, _ = Login("expiry", "password123")
+	for i := 0; i < 5; i++ {
+		*clock = clock.Add(40 * time.Minute)
+		ParseToken(sess.Token)
+	}
+	if _, err := ParseToken(sess.Token); err == nil {
+		t.Error("session outlived its absolute limit")
+	}
+}
+
+func TestPurgeSessions(t *testing.T) {
+	clock := useClock(t)
+	createTestAccount(t, "purge")
+
+	stale, _ := Login("purge", "password123")
+	*clock = clock.Add(DefaultSessionPolicy.Idle - time.Hour)
+	fresh, _ := Login("purge", "password123")
+	*clock = clock.Add(2 * time.Hour)
+
+	if n := PurgeSessions(); n != 1 {
+		t.Errorf("purged %d sessions, want 1", n)
+	}
+	if _, err := ParseToken(stale.Token); err == nil {
+		t.Error("stale session survived the purge")
+	}
+	if _, err := ParseToken(fresh.Token); err != nil {
+		t.Errorf("fresh session purged: %v", err)
+	}
+
+	var stored map[string]*Session
+	b, _ := data.LoadFile("sessions.json")
+	json.Unmarshal(b, &stored)
+	if _, ok := stored[fresh.Token]; ok {
+		t.Error("sessions.json is keyed by the raw token")
+	}
+	if _, ok := stored[hashToken(fresh.Token)]; !ok {
+		t.Error("sessions.json is missing the fresh session")
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	useClock(t)
+	createTestAccount(t, "revoker")
+	createTestAccount(t, "bystander")
+
+	phone, _ := Login("revoker", "password123")
+	laptop, _ := Login("revoker", "password123")
+	other, _ := Login("bystander", "password123")
+
+	req := httptest.NewRequest("GET", "/account", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: laptop.Token})
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0")
+	req.RemoteAddr = "203.0.113.7:5123"
+	if _, err := GetSession(req); err != nil {
+		t.Fatal(err)
+	}
+
+	list := ListSessions("revoker")
+	if len(list) != 2 {
+		t.Fatalf("listed %d sessions, want 2", len(list))
+	}
+	for _, s := range list {
+		if s.ID == laptop.ID && (s.IP != "203.0.113.7" || s.Agent == "") {
+			t.Errorf("request not recorded: %+v", s)
+		}
+	}
+
+	if err := RevokeSession("bystander", phone.ID); err == nil {
+		t.Error("revoked another account's session")
+	}
+	if err := RevokeSession("revoker", phone.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseToken(phone.Token); err == nil {
+		t.Error("revoked session still valid")
+	}
+
+	Login("revoker", "password123")
+	if n := RevokeSessions("revoker", laptop.ID); n != 1 {
+		t.Errorf("revoked %d other sessions, want 1", n)
+	}
+	if n := RevokeSessions("revoker", ""); n != 1 {
+		t.Errorf("log out everywhere ended %d sessions, want 1", n)
+	}
+	if _, err := ParseToken(other.Token); err != nil {
+		t.Error("another account's session was revoked")
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	useClock(t)
+	createTestAccount(t, "cookie")
+	sess, _ := Login("cookie", "password123")
+
+	req := httptest.NewRequest("POST", "/login", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rec := httptest.NewRecorder()
+	SetSessionCookie(rec, req, sess)
+
+	c := rec.Result().Cookies()[0]
+	if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode || c.Path != "/" {
+		t.Errorf("cookie flags = %+v", c)
+	}
+	if !c.Expires.Equal(sess.Expires.Truncate(time.Second)) {
+		t.Errorf("cookie expires %v, want %v", c.Expires, sess.Expires)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	t.Cleanup(func() { SetTrustedProxies(nil) })
+	ip := func(remote string, fwd ...string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remote
+		for _, f := range fwd {
+			req.Header.Add("X-Forwarded-For", f)
+		}
+		return ClientIP(req)
+	}
+
+	if got := ip("203.0.113.9:4000", "198.51.100.1"); got != "203.0.113.9" {
+		t.Errorf("untrusted peer: X-Forwarded-For believed, got %s", got)
+	}
+
+	if err := SetTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote string
+		fwd    []string
+		want   string
+	}{
+		{"10.0.0.2:80", nil, "10.0.0.2"},
+		{"10.0.0.2:80", []string{"198.51.100.7"}, "198.51.100.7"},
+		// a spoofed first hop is ignored in favour of what the proxy saw
+		{"10.0.0.2:80", []string{"1.2.3.4, 198.51.100.7"}, "198.51.100.7"},
+		{"127.0.0.1:80", []string{"1.2.3.4", "198.51.100.7, 10.1.1.1"}, "198.51.100.7"},
+		{"10.0.0.2:80", []string{"junk, 10.1.1.1"}, "10.1.1.1"},
+		{"203.0.113.9:4000", []string{"198.51.100.7"}, "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		if got := ip(tt.remote, tt.fwd...); got != tt.want {
+			t.Errorf("ClientIP(%s, %q) = %s, want %s", tt.remote, tt.fwd, got, tt.want)
+		}
+	}
+
+	if err := SetTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("invalid proxy accepted")
+	}
+}
+
+func TestMigrateLegacySessions(t *testing.T) {
+	clock := useClock(t)
+
+	id := "6f1c2a9e-2b7d-4c1e-9a55-0d7e4f3b8a21"
+	token := base64.StdEncoding.EncodeToString([]byte(id))
+	legacy := map[string]map[string]interface{}{
+		id:        {"id": id, "type": "account", "token": token, "account": "old", "created": clock.Add(-24 * time.Hour)},
+		"expired": {"id": "expired", "type": "account", "token": "eA==", "account": "old", "created": clock.Add(-365 * 24 * time.Hour)},
+	}
+	b, _ := json.Marshal(legacy)
+	data.SaveFile("sessions.json", string(b))
+	defer data.SaveJSON("sessions.json", map[string]*Session{})
+
+	apply := func() {
+		if err := data.CurrentStore().Tx(migrateSessions); err != nil {
+			t.Fatalf("migrateSessions: %v", err)
+		}
+	}
+	apply()
+	migrated, _ := data.LoadFile("sessions.json")
+	apply()
+	if again, _ := data.LoadFile("sessions.json"); string(again) != string(migrated) {
+		t.Error("migration is not idempotent")
+	}
+
+	mutex.Lock()
+	sessions = map[string]*Session{}
+	json.Unmarshal(migrated, &sessions)
+	mutex.Unlock()
+
+	if len(sessions) != 1 {
+		t.Fatalf("kept %d sessions, want 1", len(sessions))
+	}
+	sess, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("old cookie no longer works: %v", err)
+	}
+	if sess.ID == id || sess.Expires.IsZero() {
+		t.Errorf("migrated session = %+v", sess)
+	}
+}
@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mu/data"
+)
+
+// ============================================
+// INVITES
+// ============================================
+
+// Instances can close signups to the public. When signups are by
+// invitation, a new account needs a code from an existing one. Accounts
+// with the invite permission create codes that work a set number of times
+// before they expire; all but admins are held to a quota of active codes.
+// Codes are shown once and kept only as hashes.
+
+const invitesFile = "invites.json"
+
+var (
+	// ErrInvalidInvite is returned for an unknown, used up or expired code.
+	ErrInvalidInvite = errors.New("invalid or expired invite code")
+
+	errInviteQuota = errors.New("you have no invites left; wait for one to be used or expire, or revoke one")
+)
+
+// Invite is a signup code. The code itself is never stored.
+type Invite struct {
+	ID      string    `json:"id"`
+	Creator string    `json:"creator"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	MaxUses int       `json:"max_uses"`
+	UsedBy  []string  `json:"used_by,omitempty"`
+}
+
+// Active reports whether an invite can still be used.
+func (i *Invite) Active() bool {
+	return now().Before(i.Expires) && len(i.UsedBy) < i.MaxUses
+}
+
+var (
+	inviteMu sync.Mutex
+	invites  = map[string]*Invite{} // keyed by code hash
+)
+
+func init() {
+	data.RegisterSensitive(invitesFile)
+}
+
+func loadInvites() {
+	inviteMu.Lock()
+	defer inviteMu.Unlock()
+	b, _ := data.LoadFile(invitesFile)
+	json.Unmarshal(b, &invites)
+}
+
+// CreateInvite makes a code from creator that works uses times until ttl
+// has passed. quota caps the creator's active invites; 0 means no cap.
+func CreateInvite(creator string, uses int, ttl time.Duration, quota int) (string, *Invite, error) {
+	if uses < 1 {
+		return "", nil, errors.New("an invite must allow at least one use")
+	}
+	if ttl <= 0 {
+		return "", nil, errors.New("an invite must expire")
+	}
+	if _, err := GetAccount(creator); err != nil {
+		return "", nil, err
+	}
+
+	code := newCode()
+	t := now()
+	inv := &Invite{
+		ID:      hashToken(code)[:10],
+		Creator: creator,
+		Created: t,
+		Expires: t.Add(ttl),
+		MaxUses: uses,
+	}
+
+	inviteMu.Lock()
+	defer inviteMu.Unlock()
+
+	if quota > 0 {
+		active := 0
+		for _, i := range invites {
+			if i.Creator == creator && i.Active() {
+				active++
+			}
+		}
+		if active >= quota {
+			return "", nil, errInviteQuota
+		}
+	}
+	invites[hashToken(code)] = inv
+	pruneInvites(t)
+	data.SaveJSON(invitesFile, invites)
+
+	c2 := *inv
+	return code, &c2, nil
+}
+
+// newCode returns a random 80 bit code written as four groups of four
+// characters, as used for invites and password resets.
+func newCode() string {
+	b := make([]byte, 10)
+	rand.Read(b)
+	c := strings.ToLower(b32.EncodeToString(b))
+	return c[:4] + "-" + c[4:8] + "-" + c[8:12] + "-" + c[12:]
+}
+
+// normalizeCode accepts codes from newCode typed in any case, with or
+// without dashes.
+func normalizeCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if len(code) != 16 {
+		return ""
+	}
+	return code[:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:]
+}
+
+// CheckInvite reports whether a code can be used, without using it.
+func CheckInvite(code string) error {
+	inviteMu.Lock()
+	defer inviteMu.Unlock()
+	if inv, ok := invites[hashToken(normalizeCode(code))]; ok && inv.Active() {
+		return nil
+	}
+	return ErrInvalidInvite
+}
+
+// CreateInvited creates an account using up one use of an invite code. The
+// use is given back if the account cannot be created.
+func CreateInvited(acc *Account, code string) error {
+	key := hashToken(normalizeCode(code))
+
+	inviteMu.Lock()
+	inv, ok := invites[key]
+	if !ok || !inv.Active() {
+		inviteMu.Unlock()
+		return ErrInvalidInvite
+	}
+	inv.UsedBy = append(inv.UsedBy, acc.ID)
+	data.SaveJSON(invitesFile, invites)
+	inviteMu.Unlock()
+
+	if err := Create(acc); err != nil {
+		inviteMu.Lock()
+		if n := slices.Index(inv.UsedBy, acc.ID); n >= 0 {
+			inv.UsedBy = slices.Delete(inv.UsedBy, n, n+1)
+		}
+		data.SaveJSON(invitesFile, invites)
+		inviteMu.Unlock()
+		return err
+	}
+	fmt.Printf("[auth] %s joined with an invite from %s\n", acc.ID, inv.Creator)
+	return nil
+}
+
+// ListInvites returns the invites created by an account, or by everyone if
+// creator is empty, newest first.
+func ListInvites(creator string) []*Invite {
+	inviteMu.Lock()
+	defer inviteMu.Unlock()
+
+	var list []*Invite
+	for _, inv := range invites {
+		if creator == "" || inv.Creator == creator {
+			c := *inv
+			c.UsedBy = append([]string(nil), inv.UsedBy...)
+			list = append(list, &c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.After(list[j].Created)
+	})
+	return list
+}
+
+// RevokeInvite deletes an invite. creator must match unless it is empty,
+// which admins use to revoke anyone's.
+func RevokeInvite(creator, id string) error {
+	inviteMu.Lock()
+	defer inviteMu.Unlock()
+	for key, inv := range invites {
+		if inv.ID == id && (creator == "" || inv.Creator == creator) {
+			delete(invites, key)
+			data.SaveJSON(invitesFile, invites)
+			return nil
+		}
+	}
+	return errors.New("invite not found")
+}
+
+// revokeAccountInvites deletes the invites an account created.
+func revokeAccountInvites(account string) {
+	inviteMu.Lock()
+	defer inviteMu.Unlock()
+	changed := false
+	for key, inv := range invites {
+		if inv.Creator == account {
+			delete(invites, key)
+			changed = true
+		}
+	}
+	if changed {
+		data.SaveJSON(invitesFile, invites)
+	}
+}
+
+// pruneInvites drops invites that expired over a week ago. The caller must
+// hold inviteMu.
+func pruneInvites(t time.Time) {
+	for key, inv := range invites {
+		if t.Sub(inv.Expires) > 7*24*time.Hour {
+			delete(invites, key)
+		}
+	}
+}
+
+// ============================================
+// APPROVAL
+// ============================================
+
+// When signups need approval, new accounts are created pending. They can
+// sign in but hold no permissions until an admin approves them.
+
+// PendingAccounts returns accounts awaiting approval, oldest first.
+func PendingAccounts() []*Account {
+	var list []*Account
+	for _, acc := range GetAllAccounts() {
+		if acc.Pending {
+			list = append(list, acc)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list
+}
+
+// ApproveAccount lets a pending account act with its role.
+func ApproveAccount(id string) error {
+	acc, err := GetAccount(id)
+	if err != nil {
+		return err
+	}
+	if !acc.Pending {
+		return errors.New("account is not awaiting approval")
+	}
+	c := *acc
+	c.Pending = false
+	return UpdateAccount(&c)
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvites(t *testing.T) {
+	clock := useClock(t)
+	createTestAccount(t, "inviter")
+
+	if _, _, err := CreateInvite("inviter", 0, time.Hour, 0); err == nil {
+		t.Error("invite with no uses created")
+	}
+	if _, _, err := CreateInvite("nobody", 1, time.Hour, 0); err == nil {
+		t.Error("invite from a missing account created")
+	}
+
+	code, inv, err := CreateInvite("inviter", 2, 24*time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(code, inv.ID) || strings.Contains(strings.ReplaceAll(code, "-", ""), inv.ID) {
+		t.Error("invite ID reveals the code")
+	}
+	*clock = clock.Add(time.Minute)
+	if _, _, err := CreateInvite("inviter", 1, time.Hour, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := CreateInvite("inviter", 1, time.Hour, 2); err != errInviteQuota {
+		t.Errorf("third invite over a quota of 2: %v", err)
+	}
+
+	// codes work in any case and without dashes, up to their uses
+	join := func(id, code string) error {
+		t.Helper()
+		err := CreateInvited(&Account{ID: id, Name: id, Secret: "password123", Created: *clock}, code)
+		if err == nil {
+			t.Cleanup(func() { DeleteAccount(id) })
+		}
+		return err
+	}
+	if err := join("guest1", strings.ToUpper(code)); err != nil {
+		t.Fatal(err)
+	}
+	if err := join("inviter", code); err == nil {
+		t.Error("existing account created")
+	}
+	if err := join("guest2", strings.ReplaceAll(code, "-", "")); err != nil {
+		t.Fatalf("failed create gave back its use: %v", err)
+	}
+	if err := join("guest3", code); err != ErrInvalidInvite {
+		t.Errorf("used up code: %v", err)
+	}
+	if list := ListInvites("inviter"); len(list) != 2 || strings.Join(list[1].UsedBy, ",") != "guest1,guest2" {
+		t.Errorf("ListInvites = %+v", list)
+	}
+
+	// used up invites no longer count against the quota, expired ones
+	// stop working
+	code, inv, err = CreateInvite("inviter", 1, time.Hour, 2)
+	if err != nil {
+		t.Fatalf("quota counted a used up invite: %v", err)
+	}
+	*clock = clock.Add(time.Hour)
+	if err := CheckInvite(code); err != ErrInvalidInvite {
+		t.Errorf("expired code: %v", err)
+	}
+
+	if err := RevokeInvite("guest1", inv.ID); err == nil {
+		t.Error("revoked someone else's invite")
+	}
+	if err := RevokeInvite("inviter", inv.ID); err != nil {
+		t.Error(err)
+	}
+
+	DeleteAccount("inviter")
+	if list := ListInvites("inviter"); len(list) != 0 {
+		t.Errorf("invites outlived their creator: %+v", list)
+	}
+}
+
+func TestPendingAccount(t *testing.T) {
+	if err := Create(&Account{ID: "waiting", Name: "waiting", Secret: "password123", Created: time.Now(), Pending: true}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { DeleteAccount("waiting") })
+
+	sess, err := Login("waiting", "password123")
+	if err != nil {
+		t.Fatalf("pending account cannot sign in: %v", err)
+	}
+	h := Require(PermChat)(func(w http.ResponseWriter, r *http.Request) {})
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/chat", nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: sess.Token})
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	if rec := serve(); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "approval") {
+		t.Errorf("pending account chatted: %d %q", rec.Code, rec.Body.String())
+	}
+	if list := PendingAccounts(); len(list) != 1 || list[0].ID != "waiting" {
+		t.Errorf("PendingAccounts = %v", list)
+	}
+
+	if err := ApproveAccount("waiting"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(); rec.Code != http.StatusOK {
+		t.Errorf("approved account refused: %d", rec.Code)
+	}
+	if err := ApproveAccount("waiting"); err == nil {
+		t.Error("approved twice")
+	}
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"mu/data"
+)
+
+// ============================================
+// SUSPENSIONS
+// ============================================
+
+// Admins can suspend an account for a while or ban it outright, giving a
+// reason. Either way the account is logged out, cannot sign in again and
+// holds no permissions, so tokens it issued cannot post, flag or chat. A
+// suspension lifts by itself once it expires; a ban lasts until an admin
+// lifts it. The reason is shown to the account when it is refused.
+
+// Suspension is a restriction placed on an account.
+type Suspension struct {
+	Reason string    `json:"reason"`
+	By     string    `json:"by"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until,omitempty"` // zero for a ban
+}
+
+// Active reports whether a suspension is in force.
+func (s *Suspension) Active() bool {
+	return s != nil && (s.Until.IsZero() || now().Before(s.Until))
+}
+
+// Ban reports whether a suspension has no expiry.
+func (s *Suspension) Ban() bool {
+	return s.Until.IsZero()
+}
+
+// SuspendedError is the refusal given to a suspended or banned account.
+type SuspendedError struct {
+	Suspension
+}
+
+func (e *SuspendedError) Error() string {
+	msg := "your account is banned"
+	if !e.Ban() {
+		msg = "your account is suspended until " + e.Until.Local().Format("2006-01-02 15:04")
+	}
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// Suspended returns the refusal for an account under an active suspension,
+// or nil.
+func (a *Account) Suspended() *SuspendedError {
+	if !a.Suspension.Active() {
+		return nil
+	}
+	return &SuspendedError{Suspension: *a.Suspension}
+}
+
+// Suspend restricts an account for d, or bans it when d is 0, and ends its
+// sessions. A reason is required. The last admin cannot be suspended.
+func Suspend(id, by, reason string, d time.Duration) (*Suspension, error) {
+	if reason == "" {
+		return nil, errors.New("a reason is required")
+	}
+	if d < 0 {
+		return nil, errors.New("a suspension cannot end in the past")
+	}
+	acc, err := GetAccount(id)
+	if err != nil {
+		return nil, err
+	}
+	if isLastAdmin(acc) {
+		return nil, errLastAdmin
+	}
+
+	t := now()
+	s := &Suspension{Reason: reason, By: by, Since: t}
+	if d > 0 {
+		s.Until = t.Add(d)
+	}
+	c := *acc
+	c.Suspension = s
+	if err := UpdateAccount(&c); err != nil {
+		return nil, err
+	}
+	n := RevokeSessions(id, "")
+	fmt.Printf("[auth] %s suspended by %s (%s), %d sessions ended\n", id, by, reason, n)
+	return s, nil
+}
+
+// Unsuspend lifts an account's suspension or ban.
+func Unsuspend(id string) error {
+	acc, err := GetAccount(id)
+	if err != nil {
+		return err
+	}
+	if acc.Suspension == nil {
+		return nil
+	}
+	c := *acc
+	c.Suspension = nil
+	return UpdateAccount(&c)
+}
+
+// liftExpiredSuspensions clears suspensions that have run out. They stop
+// applying on expiry regardless; this only tidies the account records.
+func liftExpiredSuspensions() int {
+	var lifted []*Account
+	mutex.Lock()
+	for id, acc := range accounts {
+		if acc.Suspension != nil && !acc.Suspension.Active() {
+			c := *acc
+			c.Suspension = nil
+			accounts[id] = &c
+			lifted = append(lifted, &c)
+		}
+	}
+	if len(lifted) > 0 {
+		data.SaveJSON("accounts.json", accounts)
+	}
+	mutex.Unlock()
+
+	for _, acc := range lifted {
+		notifyAccountChange(acc, false)
+	}
+	return len(lifted)
+}
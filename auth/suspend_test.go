@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSuspend(t *testing.T) {
+	clock := useClock(t)
+	createTestAccount(t, "troll")
+	sess, _ := Login("troll", "password123")
+
+	if _, err := Suspend("troll", "admin", "", time.Hour); err == nil {
+		t.Error("suspended without a reason")
+	}
+	s, err := Suspend("troll", "admin", "spam", 24*time.Hour)
+	if err != nil || s.Ban() || !s.Until.Equal(clock.Add(24*time.Hour)) {
+		t.Fatalf("Suspend = %+v, %v", s, err)
+	}
+	if _, err := useSession(sess.Token, "", ""); err == nil {
+		t.Error("session survived the suspension")
+	}
+
+	_, err = Login("troll", "password123")
+	var suspended *SuspendedError
+	if !errors.As(err, &suspended) || !strings.Contains(err.Error(), "spam") {
+		t.Fatalf("login while suspended: %v", err)
+	}
+	acc, _ := GetAccount("troll")
+	if acc.Can(PermChat) || acc.Can(PermPost) || acc.Can(PermFlag) {
+		t.Error("suspended account keeps its permissions")
+	}
+	if err := acc.Refusal(); err == nil || !strings.Contains(err.Error(), "spam") {
+		t.Errorf("Refusal = %v", err)
+	}
+
+	// the suspension lifts by itself
+	*clock = clock.Add(24 * time.Hour)
+	if _, err := Login("troll", "password123"); err != nil {
+		t.Errorf("login after expiry: %v", err)
+	}
+	if n := liftExpiredSuspensions(); n != 1 {
+		t.Errorf("lifted %d suspensions, want 1", n)
+	}
+	if acc, _ := GetAccount("troll"); acc.Suspension != nil {
+		t.Error("expired suspension kept")
+	}
+
+	// a ban lasts until lifted
+	s, _ = Suspend("troll", "admin", "abuse", 0)
+	if !s.Ban() {
+		t.Error("no expiry is not a ban")
+	}
+	*clock = clock.Add(365 * 24 * time.Hour)
+	if _, err := Login("troll", "password123"); !strings.Contains(err.Error(), "banned: abuse") {
+		t.Errorf("login while banned: %v", err)
+	}
+	Unsuspend("troll")
+	if _, err := Login("troll", "password123"); err != nil {
+		t.Errorf("login after lifting the ban: %v", err)
+	}
+}
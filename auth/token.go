@@ -0,0 +1,314 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mu/api"
+	"mu/data"
+)
+
+// ============================================
+// API TOKENS
+// ============================================
+
+// Personal API tokens let scripts act as an account. A token is sent in the
+// X-Micro-Token header or as "Authorization: Bearer <token>" and resolves
+// to the same identity as the account's cookie session, limited to the
+// token's scopes. Like session tokens only the SHA-256 is stored.
+
+// Token scopes.
+const (
+	ScopeRead  = "read"  // GET anything a signed in user can see
+	ScopeChat  = "chat"  // post to /chat and join chat rooms
+	ScopePosts = "posts" // create, edit and flag posts
+)
+
+// Scopes lists every scope with a short description, in display order.
+var Scopes = [][2]string{
+	{ScopeRead, "Read pages and JSON feeds"},
+	{ScopeChat, "Send chat prompts"},
+	{ScopePosts, "Write, edit and flag posts"},
+}
+
+const (
+	tokensFile   = "tokens.json"
+	tokenPrefix  = "mu_"
+	maxTokenName = 64
+)
+
+// APIToken is a personal access token. The token itself is only shown once,
+// when it is created.
+type APIToken struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Account  string    `json:"account"`
+	Scopes   []string  `json:"scopes"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	LastUsed time.Time `json:"last_used,omitempty"`
+	LastIP   string    `json:"last_ip,omitempty"`
+}
+
+// HasScope reports whether the token grants scope.
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+var (
+	tokenMu   sync.Mutex
+	apiTokens = map[string]*APIToken{} // keyed by token hash
+
+	errTokenNotFound = errors.New("token not found")
+)
+
+type sessionKey struct{}
+
+func init() {
+	data.RegisterSensitive(tokensFile)
+}
+
+func loadTokens() {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+	b, _ := data.LoadFile(tokensFile)
+	json.Unmarshal(b, &apiTokens)
+}
+
+// CreateAPIToken issues a token for an account that expires after ttl. It
+// returns the token, which cannot be recovered later, and its record.
+func CreateAPIToken(account, name string, scopes []string, ttl time.Duration) (string, *APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, errors.New("token name is required")
+	}
+	if len(name) > maxTokenName {
+		return "", nil, errors.New("token name is too long")
+	}
+	if len(scopes) == 0 {
+		return "", nil, errors.New("choose at least one scope")
+	}
+	for _, s := range scopes {
+		if !validScope(s) {
+			return "", nil, errors.New("unknown scope " + s)
+		}
+	}
+	if ttl <= 0 {
+		return "", nil, errors.New("token must expire")
+	}
+	if _, err := GetAccount(account); err != nil {
+		return "", nil, err
+	}
+
+	tk := tokenPrefix + GenerateToken()
+	b := make([]byte, sessionIDSize)
+	rand.Read(b)
+	t := now()
+
+	tok := &APIToken{
+		ID:      hex.EncodeToString(b),
+		Name:    name,
+		Account: account,
+		Scopes:  append([]string(nil), scopes...),
+		Created: t,
+		Expires: t.Add(ttl),
+	}
+
+	tokenMu.Lock()
+	apiTokens[hashToken(tk)] = tok
+	data.SaveJSON(tokensFile, apiTokens)
+	tokenMu.Unlock()
+
+	c := *tok
+	return tk, &c, nil
+}
+
+func validScope(s string) bool {
+	for _, sc := range Scopes {
+		if sc[0] == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveAPIToken returns the token record for tk if it exists and has not
+// expired, recording the use.
+func ResolveAPIToken(tk, ip string) (*APIToken, error) {
+	if !strings.HasPrefix(tk, tokenPrefix) {
+		return nil, errTokenNotFound
+	}
+	key := hashToken(tk)
+	t := now()
+
+	tokenMu.Lock()
+	tok, ok := apiTokens[key]
+	if !ok {
+		tokenMu.Unlock()
+		return nil, errTokenNotFound
+	}
+	if !t.Before(tok.Expires) {
+		tokenMu.Unlock()
+		return nil, errors.New("token expired")
+	}
+	if t.Sub(tok.LastUsed) >= touchInterval || ip != tok.LastIP {
+		tok.LastUsed = t
+		tok.LastIP = ip
+		data.SaveJSON(tokensFile, apiTokens)
+	}
+	c := *tok
+	tokenMu.Unlock()
+
+	if _, err := GetAccount(c.Account); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ListAPITokens returns an account's tokens, newest first, including
+// expired ones so they can be seen and removed.
+func ListAPITokens(account string) []*APIToken {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+
+	var list []*APIToken
+	for _, tok := range apiTokens {
+		if tok.Account == account {
+			c := *tok
+			list = append(list, &c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.After(list[j].Created)
+	})
+	return list
+}
+
+// RevokeAPIToken deletes one of an account's tokens by ID.
+func RevokeAPIToken(account, id string) error {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+
+	for key, tok := range apiTokens {
+		if tok.Account == account && tok.ID == id {
+			delete(apiTokens, key)
+			data.SaveJSON(tokensFile, apiTokens)
+			return nil
+		}
+	}
+	return errTokenNotFound
+}
+
+// revokeAccountTokens deletes every token of an account and returns how
+// many there were.
+func revokeAccountTokens(account string) int {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+
+	n := 0
+	for key, tok := range apiTokens {
+		if tok.Account == account {
+			delete(apiTokens, key)
+			n++
+		}
+	}
+	if n > 0 {
+		data.SaveJSON(tokensFile, apiTokens)
+	}
+	return n
+}
+
+// requestToken returns the API token sent with a request, if any.
+func requestToken(r *http.Request) string {
+	if tk := strings.TrimSpace(r.Header.Get(api.TokenHeader)); tk != "" {
+		return tk
+	}
+	if h := r.Header.Get("Authorization"); len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// tokenScope returns the scope a token needs for a request, or "" if no
+// token may make it. Account, admin and sign-in pages are for the browser
+// only, so a leaked token cannot be used to mint more tokens.
+func tokenScope(r *http.Request) string {
+	p := r.URL.Path
+	for _, prefix := range []string{"/account", "/admin", "/moderate", "/settings", "/login", "/logout", "/signup", "/reset"} {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return ""
+		}
+	}
+
+	switch {
+	case strings.EqualFold(r.Header.Get("Upgrade"), "websocket"):
+		// joining a chat room lets the token speak in it
+		return ScopeChat
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return ScopeRead
+	case p == "/chat":
+		return ScopeChat
+	case p == "/posts", p == "/post", strings.HasPrefix(p, "/post/"), p == "/flag":
+		return ScopePosts
+	}
+	return ""
+}
+
+// TokenMiddleware authenticates requests that carry an API token. The
+// token's account becomes the request's session, so handlers calling
+// GetSession see the same identity as for a cookie. Requests without a
+// token pass through untouched.
+func TokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tk := requestToken(r)
+		if tk == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tok, err := ResolveAPIToken(tk, ClientIP(r))
+		if err != nil {
+			tokenError(w, http.StatusUnauthorized, "invalid or expired token")
+			return
+		}
+		scope := tokenScope(r)
+		if scope == "" || !tok.HasScope(scope) {
+			tokenError(w, http.StatusForbidden, "token lacks the scope for this request")
+			return
+		}
+
+		sess := &Session{
+			ID:       tok.ID,
+			Type:     "token",
+			Account:  tok.Account,
+			Created:  tok.Created,
+			LastSeen: tok.LastUsed,
+			Expires:  tok.Expires,
+			IP:       tok.LastIP,
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, sess)))
+	})
+}
+
+func tokenError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	b, _ := json.Marshal(map[string]string{"error": msg})
+	w.Write(b)
+}
+
+// IsTokenRequest reports whether a request was authenticated with an API
+// token rather than a cookie.
+func IsTokenRequest(r *http.Request) bool {
+	sess, ok := r.Context().Value(sessionKey{}).(*Session)
+	return ok && sess.Type == "token"
+}
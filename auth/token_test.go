@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"mu/data"
+)
+
+func TestAPITokenLifecycle(t *testing.T) {
+	clock := useClock(t)
+	createTestAccount(t, "scripter")
+
+	tk, tok, err := CreateAPIToken("scripter", "cron", []string{ScopeRead}, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := CreateAPIToken("scripter", "bad", []string{"admin"}, time.Hour); err == nil {
+		t.Error("unknown scope accepted")
+	}
+	if _, _, err := CreateAPIToken("scripter", "forever", []string{ScopeRead}, 0); err == nil {
+		t.Error("token without expiry accepted")
+	}
+
+	var stored map[string]*APIToken
+	b, _ := data.LoadFile(tokensFile)
+	json.Unmarshal(b, &stored)
+	if strings.Contains(string(b), tk) || stored[hashToken(tk)] == nil {
+		t.Error("tokens.json does not hold just the token hash")
+	}
+
+	*clock = clock.Add(time.Hour)
+	got, err := ResolveAPIToken(tk, "198.51.100.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Account != "scripter" || !got.LastUsed.Equal(*clock) || got.LastIP != "198.51.100.4" {
+		t.Errorf("resolved token = %+v", got)
+	}
+
+	*clock = clock.Add(24 * time.Hour)
+	if _, err := ResolveAPIToken(tk, ""); err == nil {
+		t.Error("expired token accepted")
+	}
+	if list := ListAPITokens("scripter"); len(list) != 1 || list[0].ID != tok.ID {
+		t.Errorf("ListAPITokens = %+v", list)
+	}
+
+	if err := RevokeAPIToken("someone-else", tok.ID); err == nil {
+		t.Error("revoked another account's token")
+	}
+	if err := RevokeAPIToken("scripter", tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(ListAPITokens("scripter")) != 0 {
+		t.Error("revoked token still listed")
+	}
+}
+
+func TestTokenMiddleware(t *testing.T) {
+	useClock(t)
+	createTestAccount(t, "bot")
+
+	reader, _, _ := CreateAPIToken("bot", "reader", []string{ScopeRead}, time.Hour)
+	chatter, _, _ := CreateAPIToken("bot", "chatter", []string{ScopeRead, ScopeChat}, time.Hour)
+
+	var seen *Session
+	h := TokenMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = GetSession(r)
+	}))
+	serve := func(method, path string, set func(r *http.Request)) int {
+		seen = nil
+		req := httptest.NewRequest(method, path, nil)
+		if set != nil {
+			set(req)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	header := func(tk string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("X-Micro-Token", tk) }
+	}
+	bearer := func(tk string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+tk) }
+	}
+
+	if code := serve("GET", "/news", header(reader)); code != http.StatusOK || seen == nil || seen.Account != "bot" || seen.Type != "token" {
+		t.Errorf("GET /news with token: %d %+v", code, seen)
+	}
+	if code := serve("POST", "/chat", header(reader)); code != http.StatusForbidden {
+		t.Errorf("read token posted to /chat: %d", code)
+	}
+	if code := serve("POST", "/chat", bearer(chatter)); code != http.StatusOK || seen == nil {
+		t.Errorf("chat token refused /chat: %d", code)
+	}
+	if code := serve("POST", "/posts", bearer(chatter)); code != http.StatusForbidden {
+		t.Errorf("chat token posted to /posts: %d", code)
+	}
+	if code := serve("GET", "/account", header(chatter)); code != http.StatusForbidden {
+		t.Errorf("token reached /account: %d", code)
+	}
+	if code := serve("GET", "/news", header("mu_not-a-token")); code != http.StatusUnauthorized {
+		t.Errorf("unknown token: %d", code)
+	}
+
+	// without a token the cookie session is used as before
+	sess, _ := Login("bot", "password123")
+	code := serve("GET", "/account", func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: sess.Token})
+	})
+	if code != http.StatusOK || seen == nil || seen.Type != "account" {
+		t.Errorf("cookie session through middleware: %d %+v", code, seen)
+	}
+
+	DeleteAccount("bot")
+	if code := serve("GET", "/news", header(chatter)); code != http.StatusUnauthorized {
+		t.Errorf("token outlived its account: %d", code)
+	}
+}
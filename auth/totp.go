@@ -0,0 +1,369 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"mu/data"
+)
+
+// ============================================
+// TWO-FACTOR AUTHENTICATION
+// ============================================
+
+// Accounts can add RFC 6238 time-based one-time passwords, as used by
+// authenticator apps. Once enabled, Login no longer returns a session but a
+// short-lived challenge that VerifyLogin exchanges for one given a current
+// code or an unused recovery code. Secrets live in totp.json, which is
+// encrypted at rest; recovery codes are kept only as SHA-256 hashes.
+
+const (
+	totpFile      = "totp.json"
+	totpIssuer    = "Mu"
+	totpPeriod    = 30 // seconds
+	totpDigits    = 6
+	totpSkew      = 1 // steps either side of now that are accepted
+	totpKeyBytes  = 20
+	recoveryCodes = 10
+
+	challengeTTL      = 5 * time.Minute
+	challengeAttempts = 5
+)
+
+var (
+	// ErrInvalidCode is returned when a one-time or recovery code does not
+	// match.
+	ErrInvalidCode = errors.New("invalid code")
+
+	errNoChallenge = errors.New("sign-in expired, please log in again")
+
+	b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// SecondFactorRequired is returned by Login for accounts with two-factor
+// authentication. Pass Challenge and a code to VerifyLogin.
+type SecondFactorRequired struct {
+	Challenge string
+}
+
+func (e *SecondFactorRequired) Error() string {
+	return "two-factor code required"
+}
+
+// twoFactor is an account's TOTP state.
+type twoFactor struct {
+	Secret   string    `json:"secret,omitempty"`  // base32, set once enabled
+	Pending  string    `json:"pending,omitempty"` // base32, awaiting a first code
+	Enabled  time.Time `json:"enabled,omitempty"`
+	LastStep int64     `json:"last_step,omitempty"` // newest step used, to stop replays
+	Recovery []string  `json:"recovery,omitempty"`  // hashes of unused recovery codes
+}
+
+type challenge struct {
+	account  string
+	expires  time.Time
+	attempts int
+}
+
+var (
+	totpMu     sync.Mutex
+	twoFactors = map[string]*twoFactor{}
+
+	// challenges are pending second steps, keyed by token hash. They are
+	// short-lived and not persisted.
+	challenges = map[string]*challenge{}
+)
+
+func init() {
+	data.RegisterSensitive(totpFile)
+}
+
+func loadTOTP() {
+	totpMu.Lock()
+	defer totpMu.Unlock()
+	b, _ := data.LoadFile(totpFile)
+	json.Unmarshal(b, &twoFactors)
+}
+
+// TOTPEnabled reports whether an account signs in with a second factor.
+func TOTPEnabled(account string) bool {
+	totpMu.Lock()
+	defer totpMu.Unlock()
+	tf := twoFactors[account]
+	return tf != nil && tf.Secret != ""
+}
+
+// TOTPStatus returns when two-factor authentication was enabled for an
+// account and how many recovery codes remain. enabled is zero when it is
+// off.
+func TOTPStatus(account string) (enabled time.Time, recovery int) {
+	totpMu.Lock()
+	defer totpMu.Unlock()
+	tf := twoFactors[account]
+	if tf == nil || tf.Secret == "" {
+		return time.Time{}, 0
+	}
+	return tf.Enabled, len(tf.Recovery)
+}
+
+// BeginTOTP starts enrollment by creating a new secret for an account. It
+// returns the secret and an otpauth:// URI for authenticator apps. The
+// secret takes effect once EnableTOTP confirms a code from it.
+func BeginTOTP(account string) (secret, uri string, err error) {
+	if _, err := GetAccount(account); err != nil {
+		return "", "", err
+	}
+
+	key := make([]byte, totpKeyBytes)
+	rand.Read(key)
+	secret = b32.EncodeToString(key)
+
+	totpMu.Lock()
+	tf := twoFactors[account]
+	if tf == nil {
+		tf = &twoFactor{}
+		twoFactors[account] = tf
+	}
+	tf.Pending = secret
+	data.SaveJSON(totpFile, twoFactors)
+	totpMu.Unlock()
+
+	return secret, totpURI(account, secret), nil
+}
+
+// PendingTOTP returns the secret and URI of an enrollment that has been
+// started but not confirmed, if any.
+func PendingTOTP(account string) (secret, uri string, ok bool) {
+	totpMu.Lock()
+	defer totpMu.Unlock()
+	tf := twoFactors[account]
+	if tf == nil || tf.Pending == "" {
+		return "", "", false
+	}
+	return tf.Pending, totpURI(account, tf.Pending), true
+}
+
+func totpURI(account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+account) + "?" + v.Encode()
+}
+
+// EnableTOTP finishes enrollment once code matches the pending secret. It
+// returns recovery codes, which are shown to the user once.
+func EnableTOTP(account, code string) ([]string, error) {
+	totpMu.Lock()
+	defer totpMu.Unlock()
+
+	tf := twoFactors[account]
+	if tf == nil || tf.Pending == "" {
+		return nil, errors.New("two-factor setup has not been started")
+	}
+	step, ok := matchTOTP(tf.Pending, code, 0)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	tf.Secret = tf.Pending
+	tf.Pending = ""
+	tf.Enabled = now()
+	tf.LastStep = step
+	codes := newRecoveryCodes(tf)
+	data.SaveJSON(totpFile, twoFactors)
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces an account's recovery codes, returning
+// the new ones.
+func RegenerateRecoveryCodes(account string) ([]string, error) {
+	totpMu.Lock()
+	defer totpMu.Unlock()
+
+	tf := twoFactors[account]
+	if tf == nil || tf.Secret == "" {
+		return nil, errors.New("two-factor authentication is not enabled")
+	}
+	codes := newRecoveryCodes(tf)
+	data.SaveJSON(totpFile, twoFactors)
+	return codes, nil
+}
+
+// newRecoveryCodes replaces tf's recovery codes. The caller must hold
+// totpMu.
+func newRecoveryCodes(tf *twoFactor) []string {
+	codes := make([]string, recoveryCodes)
+	tf.Recovery = make([]string, recoveryCodes)
+	for i := range codes {
+		b := make([]byte, 5)
+		rand.Read(b)
+		c := strings.ToLower(b32.EncodeToString(b))
+		codes[i] = c[:4] + "-" + c[4:]
+		tf.Recovery[i] = hashToken(codes[i])
+	}
+	return codes
+}
+
+// VerifyTOTP checks a current one-time code, or uses up a recovery code,
+// for an account with two-factor authentication enabled.
+func VerifyTOTP(account, code string) error {
+	totpMu.Lock()
+	defer totpMu.Unlock()
+
+	tf := twoFactors[account]
+	if tf == nil || tf.Secret == "" {
+		return errors.New("two-factor authentication is not enabled")
+	}
+
+	if step, ok := matchTOTP(tf.Secret, code, tf.LastStep); ok {
+		tf.LastStep = step
+		data.SaveJSON(totpFile, twoFactors)
+		return nil
+	}
+
+	code = strings.ToLower(strings.TrimSpace(code))
+	if len(code) == 8 && !strings.Contains(code, "-") {
+		code = code[:4] + "-" + code[4:]
+	}
+	sum := hashToken(code)
+	for i, h := range tf.Recovery {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(sum)) == 1 {
+			tf.Recovery = append(tf.Recovery[:i], tf.Recovery[i+1:]...)
+			data.SaveJSON(totpFile, twoFactors)
+			fmt.Printf("[auth] Recovery code used by %s, %d left\n", account, len(tf.Recovery))
+			return nil
+		}
+	}
+	return ErrInvalidCode
+}
+
+// ResetTOTP turns off two-factor authentication for an account, for when a
+// user does so themselves or an admin resets a lost device.
+func ResetTOTP(account string) {
+	totpMu.Lock()
+	defer totpMu.Unlock()
+	if _, ok := twoFactors[account]; ok {
+		delete(twoFactors, account)
+		data.SaveJSON(totpFile, twoFactors)
+	}
+}
+
+// matchTOTP checks code against secret within the allowed skew and returns
+// the matching step. Steps at or before after are refused so a code cannot
+// be used twice.
+func matchTOTP(secret, code string, after int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := b32.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	cur := now().Unix() / totpPeriod
+	for step := cur - totpSkew; step <= cur+totpSkew; step++ {
+		if step <= after {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode computes the RFC 6238 code for a time step.
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, v%mod)
+}
+
+// newChallenge starts the second login step for an account.
+func newChallenge(account string) string {
+	tk := GenerateToken()
+	t := now()
+
+	totpMu.Lock()
+	defer totpMu.Unlock()
+	for key, c := range challenges {
+		if !t.Before(c.expires) {
+			delete(challenges, key)
+		}
+	}
+	challenges[hashToken(tk)] = &challenge{account: account, expires: t.Add(challengeTTL)}
+	return tk
+}
+
+// VerifyLogin completes a login started by Login for an account with
+// two-factor authentication. A challenge allows a few attempts before the
+// password must be entered again.
+func VerifyLogin(tk, code string) (*Session, error) {
+	key := hashToken(tk)
+
+	totpMu.Lock()
+	c, ok := challenges[key]
+	if !ok || !now().Before(c.expires) {
+		delete(challenges, key)
+		totpMu.Unlock()
+		return nil, errNoChallenge
+	}
+	c.attempts++
+	if c.attempts > challengeAttempts {
+		delete(challenges, key)
+		totpMu.Unlock()
+		return nil, errNoChallenge
+	}
+	account := c.account
+	totpMu.Unlock()
+
+	if until := LockedUntil(account); !until.IsZero() {
+		return nil, &LockedError{Until: until}
+	}
+	if err := VerifyTOTP(account, code); err != nil {
+		if err == ErrInvalidCode {
+			recordLockout(loginFailed(account))
+		}
+		return nil, err
+	}
+	loginSucceeded(account)
+	if acc, err := GetAccount(account); err == nil && acc.Suspended() != nil {
+		return nil, acc.Suspended()
+	}
+
+	totpMu.Lock()
+	delete(challenges, key)
+	totpMu.Unlock()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := accounts[account]; !ok {
+		return nil, errors.New("account does not exist")
+	}
+	return newSession(account), nil
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// codeAt returns the code an authenticator app would show at t.
+func codeAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	key, err := b32.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, at.Unix()/totpPeriod)
+}
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, truncated to six digits
+	key := []byte("12345678901234567890")
+	for _, tc := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	} {
+		if got := totpCode(key, tc.unix/totpPeriod); got != tc.want {
+			t.Errorf("code at %d = %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestTOTPLogin(t *testing.T) {
+	clock := useClock(t)
+	createTestAccount(t, "twofa")
+
+	secret, uri, err := BeginTOTP("twofa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(uri, "otpauth://totp/Mu:twofa?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("uri = %s", uri)
+	}
+	if _, err := Login("twofa", "password123"); err != nil {
+		t.Fatal("login required a code before setup was confirmed")
+	}
+
+	if _, err := EnableTOTP("twofa", "000000"); err != ErrInvalidCode {
+		t.Errorf("wrong code enabled 2FA: %v", err)
+	}
+	codes, err := EnableTOTP("twofa", codeAt(t, secret, *clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodes {
+		t.Errorf("got %d recovery codes", len(codes))
+	}
+	for _, h := range twoFactors["twofa"].Recovery {
+		if h == codes[0] {
+			t.Error("recovery code stored in the clear")
+		}
+	}
+
+	login := func() string {
+		t.Helper()
+		_, err := Login("twofa", "password123")
+		var second *SecondFactorRequired
+		if !errors.As(err, &second) {
+			t.Fatalf("Login = %v, want a second factor challenge", err)
+		}
+		return second.Challenge
+	}
+
+	// the code used to enable cannot be replayed
+	ch := login()
+	if _, err := VerifyLogin(ch, codeAt(t, secret, *clock)); err != ErrInvalidCode {
+		t.Errorf("replayed code: %v", err)
+	}
+
+	// codes from the neighbouring step are accepted for clock drift
+	*clock = clock.Add(totpPeriod * time.Second)
+	sess, err := VerifyLogin(ch, codeAt(t, secret, clock.Add(totpPeriod*time.Second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseToken(sess.Token); err != nil {
+		t.Errorf("session from second step unusable: %v", err)
+	}
+	if _, err := VerifyLogin(ch, codeAt(t, secret, *clock)); err == nil {
+		t.Error("challenge reused after success")
+	}
+
+	// a recovery code works once
+	ch = login()
+	if _, err := VerifyLogin(ch, strings.ToUpper(codes[3])); err != nil {
+		t.Fatalf("recovery code refused: %v", err)
+	}
+	ch = login()
+	if _, err := VerifyLogin(ch, codes[3]); err != ErrInvalidCode {
+		t.Errorf("recovery code reused: %v", err)
+	}
+	if _, left := TOTPStatus("twofa"); left != recoveryCodes-1 {
+		t.Errorf("%d recovery codes left", left)
+	}
+
+	// challenges expire and allow only a few attempts
+	*clock = clock.Add(challengeTTL)
+	if _, err := VerifyLogin(ch, codeAt(t, secret, *clock)); err != errNoChallenge {
+		t.Errorf("expired challenge: %v", err)
+	}
+	ch = login()
+	for i := 0; i < challengeAttempts; i++ {
+		VerifyLogin(ch, "000000")
+	}
+	*clock = clock.Add(time.Minute)
+	if _, err := VerifyLogin(ch, codeAt(t, secret, *clock)); err != errNoChallenge {
+		t.Errorf("challenge survived %d wrong codes: %v", challengeAttempts, err)
+	}
+
+	// an admin reset turns it off
+	ResetTOTP("twofa")
+	if sess, err := Login("twofa", "password123"); err != nil || sess == nil {
+		t.Errorf("login after reset: %v", err)
+	}
+}
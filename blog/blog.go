@@ -2,6 +2,7 @@ package blog
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
@@ -59,6 +60,16 @@ func Load() {
 
 	// Register with admin system
 	admin.RegisterDeleter("post", &postDeleter{})
+
+	// Include posts in account exports and closures
+	auth.RegisterAccountData(auth.AccountData{
+		Name:   "posts",
+		Export: exportPosts,
+		Remove: removePosts,
+	})
+
+	// Index posts for search and chat
+	go loadIndex()
 }
 
 // postDeleter implements admin.ContentDeleter interface
@@ -83,6 +94,98 @@ func (d *postDeleter) Get(id string) interface{} {
 
 func (d *postDeleter) RefreshCache() {
 	updateCache()
+	syncIndex()
+}
+
+// ============================================
+// SEARCH INDEX
+// ============================================
+
+// indexPost adds a post to the search index, or removes it while hidden.
+func indexPost(post Post) {
+	id := "post_" + post.ID
+	if admin.IsHidden("post", post.ID) {
+		data.Delete(id)
+		return
+	}
+
+	data.Index(id, "post", post.Title, post.Content, map[string]interface{}{
+		"url":       "/post?id=" + post.ID,
+		"author":    post.Author,
+		"author_id": post.AuthorID,
+		"published": post.CreatedAt.Format(time.RFC3339),
+	})
+}
+
+// indexing counts posts queued by queueIndex that are not indexed yet.
+var indexing sync.WaitGroup
+
+// queueIndex indexes a post in the background, so creating or editing one
+// does not wait on the embedding provider. The post is looked up when its
+// turn comes and dropped from the index if it has been deleted by then.
+func queueIndex(id string) {
+	indexing.Add(1)
+	go func() {
+		defer indexing.Done()
+
+		mutex.RLock()
+		var post *Post
+		for _, p := range posts {
+			if p.ID == id {
+				c := *p
+				post = &c
+				break
+			}
+		}
+		mutex.RUnlock()
+
+		if post == nil {
+			data.Delete("post_" + id)
+			return
+		}
+		indexPost(*post)
+	}()
+}
+
+// snapshot copies the posts so they can be indexed without holding the lock.
+func snapshot() []Post {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	list := make([]Post, len(posts))
+	for i, post := range posts {
+		list[i] = *post
+	}
+	return list
+}
+
+// loadIndex indexes every post and drops entries for posts that no longer
+// exist. Unchanged posts keep their embeddings.
+func loadIndex() {
+	list := snapshot()
+	known := make(map[string]bool, len(list))
+	for _, post := range list {
+		known["post_"+post.ID] = true
+		indexPost(post)
+	}
+
+	for _, entry := range data.GetByType("post", 0) {
+		if !known[entry.ID] {
+			data.Delete(entry.ID)
+		}
+	}
+}
+
+// syncIndex brings the index in line with moderation, removing hidden
+// posts and restoring approved ones.
+func syncIndex() {
+	for _, post := range snapshot() {
+		hidden := admin.IsHidden("post", post.ID)
+		indexed := data.GetByID("post_"+post.ID) != nil
+		if hidden == indexed {
+			indexPost(post)
+		}
+	}
 }
 
 // Save blog posts to disk
@@ -309,6 +412,13 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	list := postsList
 	mutex.RUnlock()
 
+	moderate := ""
+	if auth.Can(r, auth.PermModerate) {
+		moderate = `<div style="margin-bottom: 15px;">
+			<a href="/moderate" style="color: #666; text-decoration: none; font-size: 14px;">Moderate</a>
+		</div>`
+	}
+
 	// Create the blog page with posting form
 	content := fmt.Sprintf(`<div id="blog">
 		<div style="margin-bottom: 30px;">
@@ -321,14 +431,12 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 				</div>
 			</form>
 		</div>
-		<div style="margin-bottom: 15px;">
-			<a href="/moderate" style="color: #666; text-decoration: none; font-size: 14px;">Moderate</a>
-		</div>
+		%s
 		<hr style='margin: 0 0 30px 0; border: none; border-top: 2px solid #333;'>
 		<div id="posts-list">
 			%s
 		</div>
-	</div>`, list)
+	</div>`, moderate, list)
 
 	html := app.RenderHTMLForRequest("Posts", "Share your thoughts", content, r)
 	w.Write([]byte(html))
@@ -360,6 +468,8 @@ func CreatePost(title, content, author, authorID string) (string, error) {
 	// Update cached HTML
 	updateCache()
 
+	queueIndex(id)
+
 	return id, nil
 }
 
@@ -379,34 +489,49 @@ func GetPost(id string) *Post {
 // DeletePost removes a post by ID
 func DeletePost(id string) error {
 	mutex.Lock()
-	defer mutex.Unlock()
-
+	found := false
 	for i, post := range posts {
 		if post.ID == id {
 			posts = append(posts[:i], posts[i+1:]...)
 			save()
 			updateCacheUnlocked()
-			return nil
+			found = true
+			break
 		}
 	}
-	return fmt.Errorf("post not found")
+	mutex.Unlock()
+
+	if !found {
+		return fmt.Errorf("post not found")
+	}
+
+	data.Delete("post_" + id)
+	return nil
 }
 
 // UpdatePost updates an existing post
 func UpdatePost(id, title, content string) error {
 	mutex.Lock()
-	defer mutex.Unlock()
-
+	var updated *Post
 	for i, post := range posts {
 		if post.ID == id {
 			posts[i].Title = title
 			posts[i].Content = content
 			save()
 			updateCacheUnlocked()
-			return nil
+			p := *posts[i]
+			updated = &p
+			break
 		}
 	}
-	return fmt.Errorf("post not found")
+	mutex.Unlock()
+
+	if updated == nil {
+		return fmt.Errorf("post not found")
+	}
+
+	queueIndex(updated.ID)
+	return nil
 }
 
 // RefreshCache updates the cached HTML
@@ -428,6 +553,49 @@ func GetPostsByAuthor(authorName string) []*Post {
 	return userPosts
 }
 
+// exportPosts returns copies of an account's posts for its data export.
+func exportPosts(acc *auth.Account) any {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	list := []Post{}
+	for _, post := range posts {
+		if post.AuthorID == acc.ID {
+			list = append(list, *post)
+		}
+	}
+	return list
+}
+
+// removePosts deletes an account's posts when it is closed, or with
+// anonymize keeps them under "Anonymous", as guests post.
+func removePosts(acc *auth.Account, anonymize bool) {
+	var mine []Post
+	mutex.Lock()
+	for _, post := range posts {
+		if post.AuthorID == acc.ID {
+			if anonymize {
+				post.Author = "Anonymous"
+				post.AuthorID = ""
+			}
+			mine = append(mine, *post)
+		}
+	}
+	if anonymize && len(mine) > 0 {
+		save()
+		updateCacheUnlocked()
+	}
+	mutex.Unlock()
+
+	for _, post := range mine {
+		if anonymize {
+			queueIndex(post.ID)
+		} else {
+			DeletePost(post.ID)
+		}
+	}
+}
+
 // handlePost processes the POST request to create a new blog post
 // PostHandler serves individual blog posts (public, no auth required)
 func PostHandler(w http.ResponseWriter, r *http.Request) {
@@ -465,7 +633,7 @@ func PostHandler(w http.ResponseWriter, r *http.Request) {
 
 		postID, err := createPostFromValues(r, title, content)
 		if err != nil {
-			http.Error(w, "Failed to save post", http.StatusInternalServerError)
+			postError(w, err)
 			return
 		}
 
@@ -655,7 +823,7 @@ func PostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	showLogout := auth.ValidateToken(token) == nil
 
-	html := app.RenderHTMLWithLogout(title, post.Content[:min(len(post.Content), 150)], content, showLogout)
+	html := app.WithCSRF(app.RenderHTMLWithLogout(title, post.Content[:min(len(post.Content), 150)], content, showLogout), r)
 	w.Write([]byte(html))
 }
 
@@ -789,6 +957,9 @@ func createPostFromValues(r *http.Request, title, content string) (string, error
 	authorID := ""
 	if sess, err := auth.GetSession(r); err == nil {
 		if acc, err := auth.GetAccount(sess.Account); err == nil {
+			if err := acc.Refusal(); err != nil {
+				return "", err
+			}
 			author = acc.Name
 			authorID = acc.ID
 		}
@@ -803,6 +974,17 @@ func createPostFromValues(r *http.Request, title, content string) (string, error
 	return postID, nil
 }
 
+// postError writes the response for a post that was not saved, telling a
+// suspended or unapproved author why.
+func postError(w http.ResponseWriter, err error) {
+	var suspended *auth.SuspendedError
+	if errors.As(err, &suspended) || errors.Is(err, auth.ErrPending) {
+		http.Error(w, "Forbidden - "+err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, "Failed to save post", http.StatusInternalServerError)
+}
+
 func handlePost(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
@@ -818,7 +1000,7 @@ func handlePost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if _, err := createPostFromValues(r, title, content); err != nil {
-		http.Error(w, "Failed to save post", http.StatusInternalServerError)
+		postError(w, err)
 		return
 	}
 
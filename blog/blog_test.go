@@ -1,18 +1,26 @@
 package blog
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"mu/admin"
+	"mu/auth"
+	"mu/data"
 )
 
 func TestMain(m *testing.M) {
 	tmpDir, _ := os.MkdirTemp("", "mu_test_blog")
 	originalHome := os.Getenv("HOME")
 	_ = os.Setenv("HOME", tmpDir)
-
 	code := m.Run()
 
+	data.FlushIndex()
 	_ = os.Setenv("HOME", originalHome)
 	os.RemoveAll(tmpDir)
 	os.Exit(code)
@@ -93,3 +101,92 @@ func TestPostCRUD(t *testing.T) {
 		t.Error("Post not deleted")
 	}
 }
+
+func TestPostSearchIndex(t *testing.T) {
+	data.ClearIndex()
+	prev := data.SetEmbedder(data.NewHashEmbedder(64))
+	t.Cleanup(func() {
+		data.SetEmbedder(prev)
+		data.ClearIndex()
+	})
+	posts = []*Post{}
+
+	id, err := CreatePost("Desert gardens", "Notes on growing dates and figs with very little water.", "Tester", "tester")
+	if err != nil {
+		t.Fatalf("CreatePost failed: %v", err)
+	}
+	indexing.Wait()
+
+	entry := data.GetByID("post_" + id)
+	if entry == nil {
+		t.Fatal("created post was not indexed")
+	}
+	if entry.Type != "post" || entry.Metadata["author"] != "Tester" || entry.Metadata["author_id"] != "tester" {
+		t.Errorf("unexpected entry: type %q, metadata %v", entry.Type, entry.Metadata)
+	}
+
+	if err := UpdatePost(id, "Orchard notes", "Pruning olive trees before the spring rains arrive."); err != nil {
+		t.Fatalf("UpdatePost failed: %v", err)
+	}
+	indexing.Wait()
+	if got := data.Search("olive pruning", 5); len(got) == 0 || got[0].ID != "post_"+id {
+		t.Errorf("edited post not found by new content: %v", got)
+	}
+	if entry := data.GetByID("post_" + id); entry == nil || entry.Title != "Orchard notes" {
+		t.Errorf("index not updated on edit: %+v", entry)
+	}
+
+	// three flags hide a post, approval brings it back
+	admin.RegisterDeleter("post", &postDeleter{})
+	for _, user := range []string{"a", "b", "c"} {
+		admin.Add("post", id, user)
+	}
+	(&postDeleter{}).RefreshCache()
+	if data.GetByID("post_"+id) != nil {
+		t.Error("hidden post still indexed")
+	}
+	if err := admin.Approve("post", id); err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+	if data.GetByID("post_"+id) == nil {
+		t.Error("approved post not re-indexed")
+	}
+
+	if err := DeletePost(id); err != nil {
+		t.Fatalf("DeletePost failed: %v", err)
+	}
+	if data.GetByID("post_"+id) != nil {
+		t.Error("deleted post still indexed")
+	}
+}
+
+func TestSuspendedAuthor(t *testing.T) {
+	if err := auth.Create(&auth.Account{ID: "suspended", Name: "suspended", Secret: "password123", Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auth.DeleteAccount("suspended") })
+	sess, err := auth.Login("suspended", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a session from before the suspension, or a request racing it
+	acc, _ := auth.GetAccount("suspended")
+	c := *acc
+	c.Suspension = &auth.Suspension{Reason: "spam", Since: time.Now()}
+	auth.UpdateAccount(&c)
+
+	form := url.Values{"title": {"Hi"}, "content": {"This post should not be saved, because its author is suspended for spam."}}
+	req := httptest.NewRequest("POST", "/posts", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "session", Value: sess.Token})
+	rec := httptest.NewRecorder()
+	handlePost(rec, req)
+
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "spam") {
+		t.Errorf("post by suspended account: %d %q", rec.Code, rec.Body.String())
+	}
+	for _, post := range GetPostsByAuthor("suspended") {
+		t.Errorf("post saved: %+v", post)
+	}
+}
@@ -107,6 +107,55 @@ type Client struct {
 var rooms = make(map[string]*ChatRoom)
 var roomsMutex sync.RWMutex
 
+// exportMessages returns an account's messages still held in chat rooms.
+// Rooms keep only their latest messages, in memory.
+func exportMessages(acc *auth.Account) any {
+	type message struct {
+		Room      string    `json:"room"`
+		Content   string    `json:"content"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+	list := []message{}
+
+	roomsMutex.RLock()
+	defer roomsMutex.RUnlock()
+	for _, room := range rooms {
+		room.mutex.RLock()
+		for _, msg := range room.Messages {
+			if msg.UserID == acc.ID && !msg.IsLLM {
+				list = append(list, message{Room: room.ID, Content: msg.Content, Timestamp: msg.Timestamp})
+			}
+		}
+		room.mutex.RUnlock()
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Timestamp.Before(list[j].Timestamp)
+	})
+	return list
+}
+
+// removeMessages drops a closed account's room messages, or with anonymize
+// keeps them under "anonymous".
+func removeMessages(acc *auth.Account, anonymize bool) {
+	roomsMutex.RLock()
+	defer roomsMutex.RUnlock()
+	for _, room := range rooms {
+		room.mutex.Lock()
+		kept := room.Messages[:0]
+		for _, msg := range room.Messages {
+			if msg.UserID == acc.ID && !msg.IsLLM {
+				if !anonymize {
+					continue
+				}
+				msg.UserID = "anonymous"
+			}
+			kept = append(kept, msg)
+		}
+		room.Messages = kept
+		room.mutex.Unlock()
+	}
+}
+
 // getOrCreateRoom gets an existing room or creates a new one
 func getOrCreateRoom(id string) *ChatRoom {
 	roomsMutex.Lock()
@@ -405,6 +454,13 @@ func Load() {
 	// Register LLM analyzer for content moderation
 	admin.SetAnalyzer(&llmAnalyzer{})
 
+	// Include room messages in account exports and closures
+	auth.RegisterAccountData(auth.AccountData{
+		Name:   "chat_messages",
+		Export: exportMessages,
+		Remove: removeMessages,
+	})
+
 	// Load existing summaries from disk
 	if b, err := data.LoadFile("chat_summaries.json"); err == nil {
 		if err := json.Unmarshal(b, &summaries); err != nil {
@@ -438,15 +494,15 @@ func generateSummaries() {
 	newSummaries := map[string]string{}
 
 	for topic, prompt := range prompts {
-		// Search for relevant content for each topic
-		ragEntries := data.Search(topic, 3)
+		// Search for recent news in each topic
+		results := searchTopic(topic, topic, data.SearchOptions{
+			Types: []string{"news"},
+			Since: time.Now().Add(-48 * time.Hour),
+			Limit: 3,
+		})
 		var ragContext []string
-		for _, entry := range ragEntries {
-			contentStr := fmt.Sprintf("%s: %s", entry.Title, entry.Content)
-			if len(contentStr) > 500 {
-				contentStr = contentStr[:500]
-			}
-			ragContext = append(ragContext, contentStr)
+		for _, res := range results {
+			ragContext = append(ragContext, ragText(res))
 		}
 
 		resp, err := askLLM(context.Background(), &Prompt{
@@ -488,6 +544,15 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 
 	// Check if this is a WebSocket upgrade request
 	if r.Header.Get("Upgrade") == "websocket" && roomID != "" {
+		// joining is a GET, so the route's permission check does not cover it
+		if !auth.Can(r, auth.PermChat) {
+			msg := "chat permission required"
+			if err := auth.Refusal(r); err != nil {
+				msg = err.Error()
+			}
+			http.Error(w, "Forbidden - "+msg, http.StatusForbidden)
+			return
+		}
 		room := getOrCreateRoom(roomID)
 		if room == nil {
 			http.Error(w, "Invalid room ID", http.StatusBadRequest)
@@ -4,12 +4,25 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 
 	"mu/data"
 )
 
+func TestMain(m *testing.M) {
+	tmpDir, _ := os.MkdirTemp("", "mu_test_chat")
+	originalHome := os.Getenv("HOME")
+	_ = os.Setenv("HOME", tmpDir)
+	code := m.Run()
+
+	data.FlushIndex()
+	_ = os.Setenv("HOME", originalHome)
+	os.RemoveAll(tmpDir)
+	os.Exit(code)
+}
+
 func TestHandlerJSONFlowUsesBackend(t *testing.T) {
 	reset := setBackendOverride(&fakeBackend{resp: "handler answer"})
 	defer reset()
@@ -82,8 +82,13 @@ func BuildPrompt(question, topic string, history History) (*Prompt, string, []*d
 		searchQuery = t + " " + q
 	}
 
-	ragEntries := data.Search(searchQuery, 3)
-	ragContext := formatRagContext(ragEntries)
+	results := searchTopic(searchQuery, t, data.SearchOptions{Limit: 3})
+	ragContext := formatRagContext(results)
+
+	ragEntries := make([]*data.IndexEntry, len(results))
+	for i, res := range results {
+		ragEntries[i] = res.Entry
+	}
 
 	return &Prompt{
 		Rag:      ragContext,
@@ -92,14 +97,69 @@ func BuildPrompt(question, topic string, history History) (*Prompt, string, []*d
 	}, searchQuery, ragEntries
 }
 
-func formatRagContext(entries []*data.IndexEntry) []string {
-	var ragContext []string
-	for _, entry := range entries {
-		contextStr := fmt.Sprintf("%s: %s", entry.Title, entry.Content)
-		if len(contextStr) > 500 {
-			contextStr = contextStr[:500]
+// searchTopic runs a RAG search restricted to entries in the topic's
+// category, widening to everything opts allows when the topic has no
+// matches of its own. Reports of the same news story count once.
+func searchTopic(query, topic string, opts data.SearchOptions) []data.SearchResult {
+	limit := opts.Limit
+	if limit > 0 {
+		// leave room for duplicates of the same story
+		opts.Limit = limit * 3
+	}
+
+	if topic != "" {
+		scoped := opts
+		scoped.Metadata = map[string]string{"category": topic}
+		if resp := data.SearchWith(query, scoped); len(resp.Results) > 0 {
+			return distinctStories(resp.Results, limit)
+		}
+	}
+	return distinctStories(data.SearchWith(query, opts).Results, limit)
+}
+
+// distinctStories keeps the best ranked result of each news story, as
+// tagged by the news clustering, up to limit results.
+func distinctStories(results []data.SearchResult, limit int) []data.SearchResult {
+	seen := map[string]bool{}
+	var out []data.SearchResult
+	for _, res := range results {
+		if story, _ := res.Entry.Metadata["story"].(string); story != "" {
+			if seen[story] {
+				continue
+			}
+			seen[story] = true
 		}
-		if url, ok := entry.Metadata["url"].(string); ok && len(url) > 0 {
+		out = append(out, res)
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out
+}
+
+// maxRagRunes caps the context taken from one result, enough for the title
+// and a full matching passage.
+const maxRagRunes = 800
+
+// ragText returns the title and the passage of a result that matched the
+// query, or the start of its content when it was not chunked.
+func ragText(res data.SearchResult) string {
+	body := res.Entry.Content
+	if res.Passage != "" {
+		body = res.Passage
+	}
+	text := fmt.Sprintf("%s: %s", res.Entry.Title, body)
+	if r := []rune(text); len(r) > maxRagRunes {
+		text = string(r[:maxRagRunes])
+	}
+	return text
+}
+
+func formatRagContext(results []data.SearchResult) []string {
+	var ragContext []string
+	for _, res := range results {
+		contextStr := ragText(res)
+		if url, ok := res.Entry.Metadata["url"].(string); ok && len(url) > 0 {
 			contextStr += fmt.Sprintf(" (Source: %s)", url)
 		}
 		ragContext = append(ragContext, contextStr)
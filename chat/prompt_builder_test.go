@@ -38,6 +38,47 @@ func TestBuildPromptWithTopicAndRAG(t *testing.T) {
 	}
 }
 
+func TestBuildPromptScopesRAGToTopic(t *testing.T) {
+	data.ClearIndex()
+	defer data.ClearIndex()
+	data.Index("crypto1", "news", "Bitcoin price falls", "Markets slide", map[string]interface{}{"category": "Crypto"})
+	data.Index("video1", "video", "Bitcoin price falls explained", "Crypto prices", map[string]interface{}{"category": "Finance"})
+	data.Index("tech1", "news", "Bitcoin price in chips", "Nothing to see", map[string]interface{}{"category": "Tech"})
+
+	_, _, ragEntries := BuildPrompt("bitcoin price", "Crypto", nil)
+	if len(ragEntries) != 1 || ragEntries[0].ID != "crypto1" {
+		t.Fatalf("expected only the Crypto entry, got %+v", ragEntries)
+	}
+
+	// a topic with nothing in its category falls back to the whole index
+	_, _, ragEntries = BuildPrompt("bitcoin price", "Sports", nil)
+	if len(ragEntries) != 3 {
+		t.Fatalf("expected fallback to all entries, got %d", len(ragEntries))
+	}
+}
+
+func TestBuildPromptSkipsDuplicateStories(t *testing.T) {
+	data.ClearIndex()
+	defer data.ClearIndex()
+	data.Index("bbc1", "news", "Flooding closes coastal roads", "Storm flooding", map[string]interface{}{"story": "bbc1"})
+	data.Index("aj1", "news", "Coastal roads closed by flooding", "Storm flooding", map[string]interface{}{"story": "bbc1"})
+	data.Index("gdn1", "news", "Flooding hits coastal farms", "Crops lost", map[string]interface{}{"story": "gdn1"})
+	data.Index("misc", "news", "Roads reopen after flooding", "Clean up", nil)
+
+	_, _, ragEntries := BuildPrompt("coastal flooding roads", "", nil)
+	if len(ragEntries) != 3 {
+		t.Fatalf("expected 3 rag entries, got %d", len(ragEntries))
+	}
+	seen := map[string]bool{}
+	for _, e := range ragEntries {
+		story, _ := e.Metadata["story"].(string)
+		if story != "" && seen[story] {
+			t.Fatalf("story %s used twice: %+v", story, ragEntries)
+		}
+		seen[story] = true
+	}
+}
+
 func TestRenderPromptTextIncludesQuestionAndSystem(t *testing.T) {
 	p := &Prompt{
 		Rag: []string{"context line"},
@@ -3,8 +3,11 @@ package config
 import (
 	"encoding/json"
 	"os"
-	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
+
+	"mu/data"
 )
 
 // Settings holds user-provided API keys and other mutable configuration.
@@ -19,17 +22,36 @@ type Settings struct {
 	ChatThinking    string `json:"chat_thinking"`
 	SummaryModel    string `json:"summary_model"`
 	SummaryThinking string `json:"summary_thinking"`
+
+	// Who may create accounts; see the Signup constants
+	SignupMode  string `json:"signup_mode"`
+	InviteQuota int    `json:"invite_quota"` // active invites per account, admins exempt
 }
 
+// Signup modes.
+const (
+	SignupOpen     = "open"     // anyone may sign up
+	SignupInvite   = "invite"   // an invite code is required
+	SignupApproval = "approval" // new accounts wait for an admin, unless invited
+)
+
+// SignupModes lists the signup modes in the order settings offers them.
+var SignupModes = []string{SignupOpen, SignupInvite, SignupApproval}
+
+// DefaultInviteQuota applies when no quota is set.
+const DefaultInviteQuota = 5
+
 var (
 	mu       sync.RWMutex
 	settings Settings
 	hooks    []func(Settings)
 )
 
-func settingsPath() string {
-	dir := os.ExpandEnv("$HOME/.mu")
-	return filepath.Join(dir, "data", "settings.json")
+const settingsKey = "settings.json"
+
+func init() {
+	// holds API keys
+	data.RegisterSensitive(settingsKey)
 }
 
 // Load reads settings from disk. Missing files are ignored.
@@ -37,7 +59,7 @@ func Load() {
 	mu.Lock()
 	defer mu.Unlock()
 
-	b, err := os.ReadFile(settingsPath())
+	b, err := data.LoadFile(settingsKey)
 	if err != nil {
 		return
 	}
@@ -55,9 +77,7 @@ func Save() error {
 		return err
 	}
 
-	path := settingsPath()
-	_ = os.MkdirAll(filepath.Dir(path), 0o700)
-	return os.WriteFile(path, b, 0o600)
+	return data.SaveFile(settingsKey, string(b))
 }
 
 // Get returns a copy of current settings, applying environment fallbacks.
@@ -78,6 +98,13 @@ func Get() Settings {
 		s.ReminderSource = "quran"
 	}
 
+	if s.SignupMode == "" {
+		s.SignupMode = SignupOpen
+	}
+	if s.InviteQuota <= 0 {
+		s.InviteQuota = DefaultInviteQuota
+	}
+
 	// Empty NewsSources means "use defaults from feeds.json"
 
 	return s
@@ -90,6 +117,41 @@ func RegisterUpdateHook(fn func(Settings)) {
 	hooks = append(hooks, fn)
 }
 
+// Changes returns the fields that differ between two settings, keyed by
+// JSON name, for the audit log. API keys are masked to their last four
+// characters.
+func Changes(old, new Settings) (before, after map[string]any) {
+	fields := func(s Settings) map[string]any {
+		m := map[string]any{}
+		b, _ := json.Marshal(s)
+		json.Unmarshal(b, &m)
+		for k, v := range m {
+			if key, ok := v.(string); ok && strings.HasSuffix(k, "_api_key") {
+				m[k] = maskKey(key)
+			}
+		}
+		return m
+	}
+	o, n := fields(old), fields(new)
+	before, after = map[string]any{}, map[string]any{}
+	for k := range n {
+		if !reflect.DeepEqual(o[k], n[k]) {
+			before[k], after[k] = o[k], n[k]
+		}
+	}
+	return before, after
+}
+
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		if key == "" {
+			return ""
+		}
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
+
 // Update replaces settings and persists them.
 func Update(new Settings) error {
 	mu.Lock()
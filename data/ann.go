@@ -0,0 +1,580 @@
+package data
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================
+// APPROXIMATE NEAREST NEIGHBOUR INDEX (HNSW)
+// ============================================
+
+const (
+	annM              = 16  // links per node on upper layers
+	annM0             = 32  // links per node on layer 0
+	annEfConstruction = 100 // candidate list size while inserting
+	annEfSearch       = 64  // minimum candidate list size while searching
+	annMinEntries     = 1000
+	annFile           = "index_ann.json"
+)
+
+// hnswNode is one embedding in the graph. links[l] holds the neighbours on
+// layer l; a node exists on layers 0..len(links)-1.
+type hnswNode struct {
+	id    string
+	slot  int // dense position used for visited bitsets
+	hash  string
+	vec   []float64
+	inv   float64 // 1 / |vec|
+	links [][]*hnswNode
+}
+
+// hnsw is a Hierarchical Navigable Small World graph over entry embeddings.
+// It is kept in sync by Index and ClearIndex and answers cosine similarity
+// queries without visiting every entry.
+type hnsw struct {
+	mu       sync.RWMutex
+	nodes    map[string]*hnswNode
+	slots    []*hnswNode
+	free     []int
+	entry    *hnswNode
+	maxLevel int
+	dims     int
+	rng      *rand.Rand
+}
+
+var ann = newHNSW()
+
+// visitedPool recycles the bitsets used to mark nodes seen during a search.
+var visitedPool = sync.Pool{New: func() interface{} { return new([]uint64) }}
+
+func acquireVisited(n int) *[]uint64 {
+	v := visitedPool.Get().(*[]uint64)
+	words := (n + 63) / 64
+	if cap(*v) < words {
+		*v = make([]uint64, words)
+	} else {
+		*v = (*v)[:words]
+		clear(*v)
+	}
+	return v
+}
+
+// visit marks slot as seen and reports whether it was already seen.
+func visit(v []uint64, slot int) bool {
+	w, bit := slot/64, uint64(1)<<(slot%64)
+	seen := v[w]&bit != 0
+	v[w] |= bit
+	return seen
+}
+
+func newHNSW() *hnsw {
+	return &hnsw{
+		nodes: make(map[string]*hnswNode),
+		rng:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// annEnabled reports whether searches may use the graph. MU_ANN=off forces
+// brute force scoring.
+func annEnabled() bool {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("MU_ANN")))
+	return v != "off" && v != "0" && v != "false"
+}
+
+func inverseNorm(v []float64) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+	if sum == 0 {
+		return 0
+	}
+	return 1 / math.Sqrt(sum)
+}
+
+func dot(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// distance is 1 - cosine similarity between a query and a node.
+func (n *hnswNode) distance(q []float64, qinv float64) float64 {
+	return 1 - dot(q, n.vec)*qinv*n.inv
+}
+
+type annCandidate struct {
+	node *hnswNode
+	dist float64
+}
+
+// nearHeap pops the closest candidate first.
+type nearHeap []annCandidate
+
+func (h nearHeap) Len() int            { return len(h) }
+func (h nearHeap) Less(i, j int) bool  { return h[i].dist < h[j].dist }
+func (h nearHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nearHeap) Push(x interface{}) { *h = append(*h, x.(annCandidate)) }
+func (h *nearHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// farHeap pops the furthest candidate first.
+type farHeap []annCandidate
+
+func (h farHeap) Len() int            { return len(h) }
+func (h farHeap) Less(i, j int) bool  { return h[i].dist > h[j].dist }
+func (h farHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *farHeap) Push(x interface{}) { *h = append(*h, x.(annCandidate)) }
+func (h *farHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+func (g *hnsw) randomLevel() int {
+	mult := 1 / math.Log(annM)
+	return int(math.Floor(-math.Log(1-g.rng.Float64()) * mult))
+}
+
+func maxLinks(level int) int {
+	if level == 0 {
+		return annM0
+	}
+	return annM
+}
+
+// searchLayer runs a best-first search on one layer and returns up to ef
+// candidates ordered nearest first.
+func (g *hnsw) searchLayer(q []float64, qinv float64, eps []*hnswNode, ef, level int) []annCandidate {
+	vp := acquireVisited(len(g.slots))
+	defer visitedPool.Put(vp)
+	visited := *vp
+
+	candidates := &nearHeap{}
+	results := &farHeap{}
+
+	for _, ep := range eps {
+		visit(visited, ep.slot)
+		c := annCandidate{node: ep, dist: ep.distance(q, qinv)}
+		heap.Push(candidates, c)
+		heap.Push(results, c)
+	}
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(annCandidate)
+		if results.Len() >= ef && c.dist > (*results)[0].dist {
+			break
+		}
+		if level >= len(c.node.links) {
+			continue
+		}
+
+		for _, n := range c.node.links[level] {
+			if visit(visited, n.slot) {
+				continue
+			}
+
+			d := n.distance(q, qinv)
+			if results.Len() < ef || d < (*results)[0].dist {
+				heap.Push(candidates, annCandidate{node: n, dist: d})
+				heap.Push(results, annCandidate{node: n, dist: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	out := make([]annCandidate, results.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(results).(annCandidate)
+	}
+	return out
+}
+
+// descend greedily walks from the entry point down to level+1 and returns
+// the closest node found.
+func (g *hnsw) descend(q []float64, qinv float64, level int) *hnswNode {
+	ep := g.entry
+	for l := g.maxLevel; l > level; l-- {
+		if found := g.searchLayer(q, qinv, []*hnswNode{ep}, 1, l); len(found) > 0 {
+			ep = found[0].node
+		}
+	}
+	return ep
+}
+
+// add inserts or replaces the embedding for id.
+func (g *hnsw) add(id, hash string, vec []float64) {
+	inv := inverseNorm(vec)
+	if inv == 0 {
+		g.remove(id)
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if existing, ok := g.nodes[id]; ok {
+		if existing.hash == hash && hash != "" {
+			return
+		}
+		g.removeUnlocked(existing)
+	}
+
+	if g.dims != 0 && len(vec) != g.dims {
+		// the embedding model changed; start a fresh graph
+		g.resetUnlocked()
+	}
+
+	level := g.randomLevel()
+	node := &hnswNode{
+		id:    id,
+		hash:  hash,
+		vec:   vec,
+		inv:   inv,
+		links: make([][]*hnswNode, level+1),
+	}
+	g.insertUnlocked(node)
+}
+
+// place registers a node and assigns it a slot.
+func (g *hnsw) place(node *hnswNode) {
+	if n := len(g.free); n > 0 {
+		node.slot = g.free[n-1]
+		g.free = g.free[:n-1]
+		g.slots[node.slot] = node
+	} else {
+		node.slot = len(g.slots)
+		g.slots = append(g.slots, node)
+	}
+	g.nodes[node.id] = node
+}
+
+func (g *hnsw) insertUnlocked(node *hnswNode) {
+	g.place(node)
+	g.dims = len(node.vec)
+	level := len(node.links) - 1
+
+	if g.entry == nil {
+		g.entry = node
+		g.maxLevel = level
+		return
+	}
+
+	ep := g.descend(node.vec, node.inv, level)
+	eps := []*hnswNode{ep}
+
+	for l := min(level, g.maxLevel); l >= 0; l-- {
+		found := g.searchLayer(node.vec, node.inv, eps, annEfConstruction, l)
+
+		limit := maxLinks(l)
+		for _, c := range found {
+			if len(node.links[l]) >= limit {
+				break
+			}
+			node.links[l] = append(node.links[l], c.node)
+			g.link(c.node, node, l)
+		}
+
+		eps = eps[:0]
+		for _, c := range found {
+			eps = append(eps, c.node)
+		}
+	}
+
+	if level > g.maxLevel {
+		g.entry = node
+		g.maxLevel = level
+	}
+}
+
+// link adds a back-link from n to node on layer l, dropping n's furthest
+// neighbour if it is over capacity.
+func (g *hnsw) link(n, node *hnswNode, l int) {
+	n.links[l] = append(n.links[l], node)
+
+	limit := maxLinks(l)
+	if len(n.links[l]) <= limit {
+		return
+	}
+
+	worst, worstDist := 0, -1.0
+	for i, m := range n.links[l] {
+		if d := m.distance(n.vec, n.inv); d > worstDist {
+			worst, worstDist = i, d
+		}
+	}
+	n.links[l] = append(n.links[l][:worst], n.links[l][worst+1:]...)
+}
+
+func (g *hnsw) remove(id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if node, ok := g.nodes[id]; ok {
+		g.removeUnlocked(node)
+	}
+}
+
+// removeUnlocked unlinks a node and reconnects neighbours that lost a link
+// to the removed node's other neighbours.
+func (g *hnsw) removeUnlocked(node *hnswNode) {
+	delete(g.nodes, node.id)
+	g.slots[node.slot] = nil
+	g.free = append(g.free, node.slot)
+
+	for l := range node.links {
+		for _, n := range g.nodes {
+			if l >= len(n.links) {
+				continue
+			}
+			links := n.links[l]
+			idx := -1
+			for i, m := range links {
+				if m == node {
+					idx = i
+					break
+				}
+			}
+			if idx < 0 {
+				continue
+			}
+			n.links[l] = append(links[:idx], links[idx+1:]...)
+
+			// repair with the closest of the removed node's neighbours
+			var best *hnswNode
+			bestDist := math.MaxFloat64
+			for _, cand := range node.links[l] {
+				if cand == n || cand == node || hasLink(n.links[l], cand) {
+					continue
+				}
+				if _, alive := g.nodes[cand.id]; !alive {
+					continue
+				}
+				if d := cand.distance(n.vec, n.inv); d < bestDist {
+					best, bestDist = cand, d
+				}
+			}
+			if best != nil {
+				n.links[l] = append(n.links[l], best)
+			}
+		}
+	}
+
+	if g.entry == node {
+		g.entry = nil
+		g.maxLevel = 0
+		for _, n := range g.nodes {
+			if g.entry == nil || len(n.links)-1 > g.maxLevel {
+				g.entry = n
+				g.maxLevel = len(n.links) - 1
+			}
+		}
+	}
+	if len(g.nodes) == 0 {
+		g.dims = 0
+	}
+}
+
+func hasLink(links []*hnswNode, n *hnswNode) bool {
+	for _, m := range links {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *hnsw) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.resetUnlocked()
+}
+
+func (g *hnsw) resetUnlocked() {
+	g.nodes = make(map[string]*hnswNode)
+	g.slots = nil
+	g.free = nil
+	g.entry = nil
+	g.maxLevel = 0
+	g.dims = 0
+}
+
+func (g *hnsw) len() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.nodes)
+}
+
+// search returns up to k entry IDs mapped to their cosine similarity with q.
+func (g *hnsw) search(q []float64, k int) map[string]float64 {
+	qinv := inverseNorm(q)
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.entry == nil || qinv == 0 || len(q) != g.dims {
+		return nil
+	}
+
+	ef := k
+	if ef < annEfSearch {
+		ef = annEfSearch
+	}
+
+	ep := g.descend(q, qinv, 0)
+	found := g.searchLayer(q, qinv, []*hnswNode{ep}, ef, 0)
+	if len(found) > k {
+		found = found[:k]
+	}
+
+	hits := make(map[string]float64, len(found))
+	for _, c := range found {
+		hits[c.node.id] = 1 - c.dist
+	}
+	return hits
+}
+
+// ============================================
+// ANN PERSISTENCE
+// ============================================
+
+type annSnapshot struct {
+	Entry    string                     `json:"entry"`
+	MaxLevel int                        `json:"max_level"`
+	Nodes    map[string]annSnapshotNode `json:"nodes"`
+}
+
+type annSnapshotNode struct {
+	Hash  string     `json:"hash"`
+	Links [][]string `json:"links"`
+}
+
+// annVector is one embedding to place in the graph.
+type annVector struct {
+	hash string
+	vec  []float64
+}
+
+func (g *hnsw) snapshot() *annSnapshot {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	snap := &annSnapshot{
+		MaxLevel: g.maxLevel,
+		Nodes:    make(map[string]annSnapshotNode, len(g.nodes)),
+	}
+	if g.entry != nil {
+		snap.Entry = g.entry.id
+	}
+
+	for id, n := range g.nodes {
+		links := make([][]string, len(n.links))
+		for l, layer := range n.links {
+			ids := make([]string, 0, len(layer))
+			for _, m := range layer {
+				ids = append(ids, m.id)
+			}
+			links[l] = ids
+		}
+		snap.Nodes[id] = annSnapshotNode{Hash: n.hash, Links: links}
+	}
+	return snap
+}
+
+// restore rebuilds the graph from a snapshot and the loaded entries and
+// their chunks. Nodes whose embedding changed or disappeared are dropped,
+// and embeddings missing from the snapshot are inserted afresh.
+func (g *hnsw) restore(snap *annSnapshot, entries map[string]*IndexEntry) {
+	vecs := map[string]annVector{}
+	for _, entry := range entries {
+		annVectors(entry, vecs)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.resetUnlocked()
+
+	if snap != nil {
+		for id, sn := range snap.Nodes {
+			v, ok := vecs[id]
+			if !ok || v.hash != sn.Hash {
+				continue
+			}
+			if g.dims != 0 && len(v.vec) != g.dims {
+				continue
+			}
+			inv := inverseNorm(v.vec)
+			if inv == 0 {
+				continue
+			}
+			g.dims = len(v.vec)
+			g.place(&hnswNode{
+				id:    id,
+				hash:  sn.Hash,
+				vec:   v.vec,
+				inv:   inv,
+				links: make([][]*hnswNode, len(sn.Links)),
+			})
+		}
+
+		for id, n := range g.nodes {
+			for l, ids := range snap.Nodes[id].Links {
+				for _, lid := range ids {
+					if m, ok := g.nodes[lid]; ok && l < len(m.links) {
+						n.links[l] = append(n.links[l], m)
+					}
+				}
+			}
+		}
+
+		if e, ok := g.nodes[snap.Entry]; ok {
+			g.entry = e
+			g.maxLevel = len(e.links) - 1
+		} else {
+			for _, n := range g.nodes {
+				if g.entry == nil || len(n.links)-1 > g.maxLevel {
+					g.entry = n
+					g.maxLevel = len(n.links) - 1
+				}
+			}
+		}
+	}
+
+	for id, v := range vecs {
+		if _, ok := g.nodes[id]; ok {
+			continue
+		}
+		if g.dims != 0 && len(v.vec) != g.dims {
+			continue
+		}
+		inv := inverseNorm(v.vec)
+		if inv == 0 {
+			continue
+		}
+		g.insertUnlocked(&hnswNode{
+			id:    id,
+			hash:  v.hash,
+			vec:   v.vec,
+			inv:   inv,
+			links: make([][]*hnswNode, g.randomLevel()+1),
+		})
+	}
+}
@@ -0,0 +1,423 @@
+package data
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================
+// BACKUP & RESTORE
+// ============================================
+
+// A backup is a gzipped tar of every key in the store: accounts, sessions,
+// posts, flags, settings, the index with its vectors and graph, and the
+// caches. Files kept outside the store, such as the audit log, are added
+// under extra/ once their package registers them. manifest.json comes
+// first and lists each file with its size and SHA-256 so a restore can
+// check the archive before touching anything.
+const (
+	BackupFormat = 2
+
+	manifestName = "manifest.json"
+	backupPrefix = "data/"
+	extraPrefix  = "extra/"
+)
+
+var (
+	extraMu    sync.RWMutex
+	extraFiles = map[string]func() string{}
+)
+
+// RegisterBackupFile adds a file kept outside the store to backups under
+// name. path is called at backup and restore time for where it lives.
+func RegisterBackupFile(name string, path func() string) {
+	extraMu.Lock()
+	defer extraMu.Unlock()
+	if n, err := cleanKey(name); err == nil {
+		extraFiles[n] = path
+	}
+}
+
+// extraPath returns where a registered outside file lives.
+func extraPath(name string) (string, bool) {
+	extraMu.RLock()
+	defer extraMu.RUnlock()
+	path, ok := extraFiles[name]
+	if !ok {
+		return "", false
+	}
+	return path(), true
+}
+
+// Manifest describes the contents of a backup.
+type Manifest struct {
+	Format    int            `json:"format"`
+	Created   time.Time      `json:"created"`
+	Version   string         `json:"version"`
+	GoVersion string         `json:"go_version"`
+	Schema    int            `json:"schema"`
+	Store     string         `json:"store"`
+	Files     []ManifestFile `json:"files"`
+	Extra     []ManifestFile `json:"extra,omitempty"` // files outside the store
+}
+
+// ManifestFile is one key, or one outside file, in a backup.
+type ManifestFile struct {
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Backup writes a consistent snapshot of the store to w. Unsaved index
+// changes are flushed first and writes are held off while the keys are
+// read, so the snapshot never mixes old and new files.
+func Backup(w io.Writer) (*Manifest, error) {
+	return backup(w, true)
+}
+
+// backup writes the snapshot, flushing the index first if asked to.
+func backup(w io.Writer, flush bool) (*Manifest, error) {
+	if flush && indexDirty.Load() {
+		FlushIndex()
+	}
+
+	files, err := snapshotStore()
+	if err != nil {
+		return nil, err
+	}
+	extra, err := snapshotExtra()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{
+		Format:    BackupFormat,
+		Created:   time.Now().UTC(),
+		Version:   buildVersion(),
+		GoVersion: runtime.Version(),
+		Store:     storeKind(CurrentStore()),
+	}
+	for _, f := range files {
+		if f.key == schemaFile {
+			var s schemaState
+			if err := json.Unmarshal(f.val, &s); err != nil {
+				return nil, fmt.Errorf("read %s: %w", schemaFile, err)
+			}
+			m.Schema = s.Version
+		}
+		m.Files = append(m.Files, manifestFile(f))
+	}
+	for _, f := range extra {
+		m.Extra = append(m.Extra, manifestFile(f))
+	}
+
+	mb, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := writeTarFile(tw, manifestName, mb, m.Created); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if err := writeTarFile(tw, backupPrefix+f.key, f.val, m.Created); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range extra {
+		if err := writeTarFile(tw, extraPrefix+f.key, f.val, m.Created); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Restore replaces the contents of the store with a backup written by
+// Backup. The whole archive is read and checked against its manifest
+// first; nothing is changed if any file is missing, extra or corrupt.
+// Keys not in the backup are removed. Outside files in the backup replace
+// the current ones; those it lacks are left alone. Backups from an older
+// schema are migrated at the next startup; newer ones are refused. The
+// server should be stopped, since its in-memory state would otherwise
+// overwrite the restored files.
+func Restore(r io.Reader) (*Manifest, error) {
+	m, files, extra, err := readBackup(r)
+	if err != nil {
+		return nil, err
+	}
+	if latest := LatestSchema(); m.Schema > latest {
+		return nil, fmt.Errorf("backup is at schema %d but this build only knows up to %d", m.Schema, latest)
+	}
+	paths := make(map[string]string, len(m.Extra))
+	for _, f := range m.Extra {
+		path, ok := extraPath(f.Key)
+		if !ok {
+			return nil, fmt.Errorf("backup has %s, which this build does not keep", f.Key)
+		}
+		paths[f.Key] = path
+	}
+
+	writeGate.Lock()
+	defer writeGate.Unlock()
+
+	s := CurrentStore()
+	existing, err := s.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Tx(func(tx Tx) error {
+		for _, f := range m.Files {
+			if err := tx.Put(f.Key, files[f.Key]); err != nil {
+				return err
+			}
+		}
+		for _, key := range existing {
+			if _, ok := files[key]; ok {
+				continue
+			}
+			if err := tx.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range m.Extra {
+		path := paths[f.Key]
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("restore %s: %w", f.Key, err)
+		}
+		if err := writeFileAtomic(path, extra[f.Key], 0600); err != nil {
+			return nil, fmt.Errorf("restore %s: %w", f.Key, err)
+		}
+	}
+	return m, nil
+}
+
+// VerifyBackup reads a backup and checks every file against its manifest
+// without restoring it.
+func VerifyBackup(r io.Reader) (*Manifest, error) {
+	m, _, _, err := readBackup(r)
+	return m, err
+}
+
+type storeFile struct {
+	key string
+	val []byte
+}
+
+// snapshotStore reads every key while writes are held off.
+func snapshotStore() ([]storeFile, error) {
+	writeGate.Lock()
+	defer writeGate.Unlock()
+
+	s := CurrentStore()
+	keys, err := s.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]storeFile, 0, len(keys))
+	for _, key := range keys {
+		val, err := s.Get(key)
+		if IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", key, err)
+		}
+		files = append(files, storeFile{key, val})
+	}
+	return files, nil
+}
+
+// snapshotExtra reads the registered outside files that exist. They are
+// not held still by writeGate; a line being appended to a log may be
+// caught half written.
+func snapshotExtra() ([]storeFile, error) {
+	extraMu.RLock()
+	names := make([]string, 0, len(extraFiles))
+	for name := range extraFiles {
+		names = append(names, name)
+	}
+	extraMu.RUnlock()
+	sort.Strings(names)
+
+	var files []storeFile
+	for _, name := range names {
+		path, _ := extraPath(name)
+		val, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", name, err)
+		}
+		files = append(files, storeFile{name, val})
+	}
+	return files, nil
+}
+
+func manifestFile(f storeFile) ManifestFile {
+	sum := sha256.Sum256(f.val)
+	return ManifestFile{
+		Key:    f.key,
+		Size:   int64(len(f.val)),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+}
+
+func writeTarFile(tw *tar.Writer, name string, b []byte, mod time.Time) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(b)),
+		ModTime: mod,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(b)
+	return err
+}
+
+// readBackup reads an archive and returns its manifest, store files and
+// outside files once every file has been checked.
+func readBackup(r io.Reader) (*Manifest, map[string][]byte, map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	var m *Manifest
+	files := map[string][]byte{}
+	extra := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, tr); err != nil {
+			return nil, nil, nil, fmt.Errorf("read %s: %w", hdr.Name, err)
+		}
+
+		if hdr.Name == manifestName {
+			if m != nil {
+				return nil, nil, nil, errors.New("archive has more than one manifest")
+			}
+			m = &Manifest{}
+			if err := json.Unmarshal(buf.Bytes(), m); err != nil {
+				return nil, nil, nil, fmt.Errorf("bad manifest: %w", err)
+			}
+			continue
+		}
+
+		dst := files
+		name, ok := strings.CutPrefix(hdr.Name, backupPrefix)
+		if !ok {
+			dst = extra
+			name, ok = strings.CutPrefix(hdr.Name, extraPrefix)
+		}
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("unexpected file %s in archive", hdr.Name)
+		}
+		key, err := cleanKey(name)
+		if err != nil || key != name {
+			return nil, nil, nil, fmt.Errorf("invalid key %q in archive", name)
+		}
+		dst[key] = buf.Bytes()
+	}
+
+	if m == nil {
+		return nil, nil, nil, errors.New("archive has no manifest")
+	}
+	if m.Format < 1 || m.Format > BackupFormat {
+		return nil, nil, nil, fmt.Errorf("unsupported backup format %d", m.Format)
+	}
+
+	if err := checkManifest(m.Files, files); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkManifest(m.Extra, extra); err != nil {
+		return nil, nil, nil, err
+	}
+	return m, files, extra, nil
+}
+
+// checkManifest reports an error unless files holds exactly the listed
+// files, each matching its size and checksum.
+func checkManifest(list []ManifestFile, files map[string][]byte) error {
+	listed := make(map[string]bool, len(list))
+	for _, f := range list {
+		b, ok := files[f.Key]
+		if !ok {
+			return fmt.Errorf("%s is missing from the archive", f.Key)
+		}
+		sum := sha256.Sum256(b)
+		if int64(len(b)) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return fmt.Errorf("%s does not match its checksum", f.Key)
+		}
+		listed[f.Key] = true
+	}
+	for key := range files {
+		if !listed[key] {
+			return fmt.Errorf("%s is not in the manifest", key)
+		}
+	}
+	return nil
+}
+
+// buildVersion returns the module version mu was built from, or "devel".
+func buildVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "devel"
+}
+
+func storeKind(s Store) string {
+	switch s.(type) {
+	case *fileStore:
+		return StoreFile
+	case *kvStore:
+		return StoreKV
+	}
+	return fmt.Sprintf("%T", s)
+}
@@ -0,0 +1,151 @@
+package data
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	useEmbedder(t, NewHashEmbedder(8))
+	logFile := filepath.Join(t.TempDir(), "logs", "audit.jsonl")
+	os.MkdirAll(filepath.Dir(logFile), 0700)
+	os.WriteFile(logFile, []byte("before\n"), 0600)
+	RegisterBackupFile("logs/audit.jsonl", func() string { return logFile })
+	t.Cleanup(func() {
+		extraMu.Lock()
+		delete(extraFiles, "logs/audit.jsonl")
+		extraMu.Unlock()
+	})
+
+	SaveJSON("accounts.json", map[string]string{"alice": "Alice"})
+	SaveFile("news/last_refresh.txt", "yesterday")
+	Index("post_1", "post", "Backups", "Keep a copy of everything.", nil)
+
+	var buf bytes.Buffer
+	m, err := Backup(&buf)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	keys := map[string]bool{}
+	for _, f := range m.Files {
+		keys[f.Key] = true
+	}
+	for _, want := range []string{"accounts.json", "news/last_refresh.txt", "index.json", vectorsFile} {
+		if !keys[want] {
+			t.Errorf("backup is missing %s", want)
+		}
+	}
+	if len(m.Extra) != 1 || m.Extra[0].Key != "logs/audit.jsonl" {
+		t.Errorf("backup extra files = %+v", m.Extra)
+	}
+	archive := buf.Bytes()
+
+	if _, err := VerifyBackup(bytes.NewReader(archive)); err != nil {
+		t.Fatalf("VerifyBackup failed: %v", err)
+	}
+
+	SaveJSON("accounts.json", map[string]string{"mallory": "Mallory"})
+	SaveFile("stray.json", "{}")
+	os.WriteFile(logFile, []byte("before\nafter\n"), 0600)
+
+	if _, err := Restore(bytes.NewReader(archive)); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	var accounts map[string]string
+	if err := LoadJSON("accounts.json", &accounts); err != nil || accounts["alice"] != "Alice" {
+		t.Errorf("accounts not restored: %v %v", accounts, err)
+	}
+	if _, err := LoadFile("stray.json"); !IsNotFound(err) {
+		t.Errorf("key created after the backup survived restore: %v", err)
+	}
+	if b, _ := LoadFile("news/last_refresh.txt"); string(b) != "yesterday" {
+		t.Errorf("nested key restored as %q", b)
+	}
+	if b, _ := os.ReadFile(logFile); string(b) != "before\n" {
+		t.Errorf("outside file restored as %q", b)
+	}
+}
+
+func TestRestoreRejectsTamperedBackup(t *testing.T) {
+	useTempStore(t)
+
+	SaveJSON("accounts.json", map[string]string{"alice": "Alice"})
+	SaveJSON("blog.json", []string{"hello"})
+
+	var buf bytes.Buffer
+	if _, err := Backup(&buf); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	tampered := rewriteBackup(t, buf.Bytes(), func(name string, b []byte) []byte {
+		if name == "data/accounts.json" {
+			return []byte(`{"alice":"Admin"}`)
+		}
+		return b
+	})
+	dropped := rewriteBackup(t, buf.Bytes(), func(name string, b []byte) []byte {
+		if name == "data/blog.json" {
+			return nil
+		}
+		return b
+	})
+
+	SaveJSON("accounts.json", map[string]string{"bob": "Bob"})
+
+	for name, archive := range map[string][]byte{"tampered": tampered, "dropped": dropped, "truncated": buf.Bytes()[:buf.Len()/2]} {
+		if _, err := Restore(bytes.NewReader(archive)); err == nil {
+			t.Errorf("%s backup restored without error", name)
+		}
+	}
+
+	var accounts map[string]string
+	LoadJSON("accounts.json", &accounts)
+	if accounts["bob"] != "Bob" {
+		t.Errorf("failed restore changed the store: %v", accounts)
+	}
+}
+
+// rewriteBackup copies an archive, passing each file through edit. A nil
+// result drops the file.
+func rewriteBackup(t *testing.T, archive []byte, edit func(name string, b []byte) []byte) []byte {
+	t.Helper()
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+
+	var out bytes.Buffer
+	gw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(tr)
+		if strings.HasPrefix(hdr.Name, backupPrefix) {
+			if b = edit(hdr.Name, b); b == nil {
+				continue
+			}
+		}
+		hdr.Size = int64(len(b))
+		tw.WriteHeader(hdr)
+		tw.Write(b)
+	}
+	tw.Close()
+	gw.Close()
+	return out.Bytes()
+}
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ============================================
+// INVERTED INDEX & HYBRID RANKING
+// ============================================
+
+// RankWeights tunes how BM25 keyword scores and vector similarity are fused.
+// Each signal ranks the candidates on its own and the ranks are combined
+// with reciprocal rank fusion: score = Σ weight / (RRFK + rank).
+type RankWeights struct {
+	Text          float64 // weight of the BM25 ranking
+	Vector        float64 // weight of the cosine similarity ranking
+	RRFK          float64 // rank fusion constant, larger flattens rank differences
+	MinSimilarity float64 // cosine below this does not count as a vector hit
+	K1            float64 // BM25 term frequency saturation
+	B             float64 // BM25 length normalisation
+}
+
+// DefaultRankWeights are used unless overridden by SetRankWeights or the
+// MU_SEARCH_TEXT_WEIGHT, MU_SEARCH_VECTOR_WEIGHT and MU_SEARCH_MIN_SIMILARITY
+// environment variables.
+var DefaultRankWeights = RankWeights{
+	Text:          1.0,
+	Vector:        1.0,
+	RRFK:          60,
+	MinSimilarity: 0.4,
+	K1:            1.2,
+	B:             0.75,
+}
+
+// titleBoost is how many times each title term is counted, so a title match
+// outweighs the same word buried in the body.
+const titleBoost = 3
+
+var (
+	rankWeightsMu sync.RWMutex
+	rankWeights   = DefaultRankWeights
+
+	textIndex = newInvertedIndex()
+)
+
+func init() {
+	w := DefaultRankWeights
+	envFloat("MU_SEARCH_TEXT_WEIGHT", &w.Text)
+	envFloat("MU_SEARCH_VECTOR_WEIGHT", &w.Vector)
+	envFloat("MU_SEARCH_MIN_SIMILARITY", &w.MinSimilarity)
+	SetRankWeights(w)
+}
+
+func envFloat(name string, dst *float64) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		fmt.Printf("[data] Ignoring invalid %s=%q\n", name, v)
+		return
+	}
+	*dst = f
+}
+
+// SetRankWeights replaces the weights used by Search. Zero RRFK, K1 or B
+// fall back to the defaults.
+func SetRankWeights(w RankWeights) {
+	if w.RRFK <= 0 {
+		w.RRFK = DefaultRankWeights.RRFK
+	}
+	if w.K1 <= 0 {
+		w.K1 = DefaultRankWeights.K1
+	}
+	if w.B <= 0 {
+		w.B = DefaultRankWeights.B
+	}
+
+	rankWeightsMu.Lock()
+	rankWeights = w
+	rankWeightsMu.Unlock()
+}
+
+// GetRankWeights returns the weights currently used by Search.
+func GetRankWeights() RankWeights {
+	rankWeightsMu.RLock()
+	defer rankWeightsMu.RUnlock()
+	return rankWeights
+}
+
+// invertedIndex maps stemmed terms to the entries containing them.
+type invertedIndex struct {
+	mu       sync.RWMutex
+	postings map[string]map[string]int // term -> entry id -> term frequency
+	docs     map[string]map[string]int // entry id -> term -> term frequency
+	lengths  map[string]int
+	total    int
+}
+
+func newInvertedIndex() *invertedIndex {
+	return &invertedIndex{
+		postings: map[string]map[string]int{},
+		docs:     map[string]map[string]int{},
+		lengths:  map[string]int{},
+	}
+}
+
+// add indexes an entry's title and content, replacing any previous terms.
+func (ix *invertedIndex) add(id, title, content string) {
+	tf := map[string]int{}
+	length := 0
+	for _, t := range analyze(title) {
+		tf[t] += titleBoost
+		length += titleBoost
+	}
+	for _, t := range analyze(content) {
+		tf[t]++
+		length++
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.removeUnlocked(id)
+	if length == 0 {
+		return
+	}
+
+	for t, n := range tf {
+		p := ix.postings[t]
+		if p == nil {
+			p = map[string]int{}
+			ix.postings[t] = p
+		}
+		p[id] = n
+	}
+	ix.docs[id] = tf
+	ix.lengths[id] = length
+	ix.total += length
+}
+
+func (ix *invertedIndex) remove(id string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.removeUnlocked(id)
+}
+
+func (ix *invertedIndex) removeUnlocked(id string) {
+	tf, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	for t := range tf {
+		if p := ix.postings[t]; p != nil {
+			delete(p, id)
+			if len(p) == 0 {
+				delete(ix.postings, t)
+			}
+		}
+	}
+	ix.total -= ix.lengths[id]
+	delete(ix.docs, id)
+	delete(ix.lengths, id)
+}
+
+func (ix *invertedIndex) reset() {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.postings = map[string]map[string]int{}
+	ix.docs = map[string]map[string]int{}
+	ix.lengths = map[string]int{}
+	ix.total = 0
+}
+
+// score returns the BM25 score of every entry containing at least one of
+// the query terms.
+func (ix *invertedIndex) score(query []string, k1, b float64) map[string]float64 {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	n := float64(len(ix.docs))
+	if n == 0 || len(query) == 0 {
+		return nil
+	}
+	avgLen := float64(ix.total) / n
+
+	seen := map[string]bool{}
+	scores := map[string]float64{}
+	for _, t := range query {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+
+		p := ix.postings[t]
+		if len(p) == 0 {
+			continue
+		}
+		df := float64(len(p))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		for id, freq := range p {
+			f := float64(freq)
+			norm := k1 * (1 - b + b*float64(ix.lengths[id])/avgLen)
+			scores[id] += idf * f * (k1 + 1) / (f + norm)
+		}
+	}
+	return scores
+}
+
+// rankOrder returns ids sorted by descending score, ties broken by id so
+// results are stable.
+func rankOrder(scores map[string]float64) []string {
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// fuseRanks combines the keyword and vector rankings with weighted
+// reciprocal rank fusion.
+func fuseRanks(text, vector map[string]float64, w RankWeights) map[string]float64 {
+	fused := make(map[string]float64, len(text)+len(vector))
+	for rank, id := range rankOrder(text) {
+		fused[id] += w.Text / (w.RRFK + float64(rank+1))
+	}
+	for rank, id := range rankOrder(vector) {
+		fused[id] += w.Vector / (w.RRFK + float64(rank+1))
+	}
+	return fused
+}
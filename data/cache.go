@@ -0,0 +1,172 @@
+package data
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ============================================
+// EMBEDDING CACHE
+// ============================================
+
+const (
+	embeddingCacheFile    = "embedding_cache.json"
+	defaultEmbedCacheSize = 2000
+)
+
+// embeddingCache holds recently computed vectors keyed by embedder and text.
+var embeddingCache = newLRUCache(embedCacheSizeFromEnv())
+
+func embedCacheSizeFromEnv() int {
+	v := strings.TrimSpace(os.Getenv("MU_EMBED_CACHE_SIZE"))
+	if v == "" {
+		return defaultEmbedCacheSize
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		fmt.Printf("[data] Ignoring invalid MU_EMBED_CACHE_SIZE=%q\n", v)
+		return defaultEmbedCacheSize
+	}
+	return n
+}
+
+// lruCache is a fixed capacity map that evicts the least recently used
+// vector when full. It tracks whether it changed since it was last saved so
+// the persist worker only rewrites it when needed.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List // front is most recently used
+	items    map[string]*list.Element
+	evicted  uint64
+	dirty    bool
+}
+
+type cacheItem struct {
+	Key string    `json:"key"`
+	Vec []float64 `json:"vec"`
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (c *lruCache) get(key string) ([]float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*cacheItem).Vec, true
+}
+
+func (c *lruCache) put(key string, vec []float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.putUnlocked(key, vec)
+	c.dirty = true
+}
+
+func (c *lruCache) putUnlocked(key string, vec []float64) {
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheItem).Vec = vec
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&cacheItem{Key: key, Vec: vec})
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheItem).Key)
+		c.evicted++
+	}
+}
+
+// remove drops key and reports whether it was cached.
+func (c *lruCache) remove(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	c.ll.Remove(el)
+	delete(c.items, key)
+	c.dirty = true
+	return true
+}
+
+func (c *lruCache) stats() (size, capacity int, evicted uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len(), c.capacity, c.evicted
+}
+
+// save writes the cache, least recently used first, if it changed. It is
+// called from saveIndex, which holds writeGate.
+func (c *lruCache) save(key string) error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	items := make([]cacheItem, 0, c.ll.Len())
+	for el := c.ll.Back(); el != nil; el = el.Prev() {
+		items = append(items, *el.Value.(*cacheItem))
+	}
+	c.dirty = false
+	c.mu.Unlock()
+
+	if err := saveJSON(key, items); err != nil {
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// load replaces the cache contents with a saved list. The older format, a
+// JSON object of bare text to vector, is also accepted.
+func (c *lruCache) load(b []byte) error {
+	var items []cacheItem
+	if err := json.Unmarshal(b, &items); err != nil {
+		var legacy map[string][]float64
+		if json.Unmarshal(b, &legacy) != nil {
+			return err
+		}
+		// keys written before embedders were pluggable are bare text
+		prefix := legacyEmbedderName() + "\x00"
+		for k, v := range legacy {
+			if !strings.Contains(k, "\x00") {
+				k = prefix + k
+			}
+			items = append(items, cacheItem{Key: k, Vec: v})
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = map[string]*list.Element{}
+	for _, it := range items {
+		if len(it.Vec) > 0 {
+			c.putUnlocked(it.Key, it.Vec)
+		}
+	}
+	return nil
+}
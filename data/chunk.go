@@ -0,0 +1,291 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ============================================
+// CHUNKED CONTENT
+// ============================================
+
+const (
+	// embedContentBytes is how much content goes into an entry's own
+	// embedding. Longer content is also split into chunks.
+	embedContentBytes = 500
+
+	chunkRunes   = 600 // target chunk length
+	chunkOverlap = 100 // runes shared with the previous chunk
+	maxChunks    = 16  // chunks embedded per entry; the rest is keyword only
+)
+
+// Chunk is an overlapping span of an entry's content with its own
+// embedding, so a long article can match on any paragraph and not just the
+// opening. Start and End are byte offsets into Content on rune boundaries.
+type Chunk struct {
+	Start         int       `json:"start"`
+	End           int       `json:"end"`
+	Embedding     []float64 `json:"embedding,omitempty"`
+	EmbeddingHash string    `json:"embedding_hash,omitempty"`
+}
+
+// ChunkText returns the text of chunk i, or "" if there is no such chunk.
+func (e *IndexEntry) ChunkText(i int) string {
+	if i < 0 || i >= len(e.Chunks) {
+		return ""
+	}
+	c := e.Chunks[i]
+	if c.Start < 0 || c.End > len(e.Content) || c.Start > c.End {
+		return ""
+	}
+	return e.Content[c.Start:c.End]
+}
+
+// embedText is the text embedded for an entry: the title plus the start of
+// the content, cut back to a rune boundary.
+func embedText(title, content string) string {
+	if len(content) == 0 {
+		return title
+	}
+	n := len(content)
+	if n > embedContentBytes {
+		n = embedContentBytes
+		for n > 0 && !utf8.RuneStart(content[n]) {
+			n--
+		}
+	}
+	return title + " " + content[:n]
+}
+
+// chunkEmbedText is the text embedded for a chunk. The title is included
+// so a paragraph keeps the context of the article it came from.
+func chunkEmbedText(title, text string) string {
+	return title + " " + text
+}
+
+// splitChunks divides content longer than the entry embedding covers into
+// overlapping chunks. Chunks end at whitespace where there is some near the
+// target length and start at the beginning of a word.
+func splitChunks(content string) []Chunk {
+	if len(content) <= embedContentBytes {
+		return nil
+	}
+
+	runes := []rune(content)
+	offsets := make([]int, 0, len(runes)+1)
+	for i := range content {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(content))
+
+	var chunks []Chunk
+	for start := 0; start < len(runes) && len(chunks) < maxChunks; {
+		end := start + chunkRunes
+		if end >= len(runes) {
+			chunks = append(chunks, Chunk{Start: offsets[start], End: len(content)})
+			break
+		}
+		for e := end; e > end-chunkRunes/4; e-- {
+			if unicode.IsSpace(runes[e]) {
+				end = e
+				break
+			}
+		}
+		chunks = append(chunks, Chunk{Start: offsets[start], End: offsets[end]})
+
+		next := end - chunkOverlap
+		for s := next; s < end; s++ {
+			if unicode.IsSpace(runes[s-1]) && !unicode.IsSpace(runes[s]) {
+				next = s
+				break
+			}
+		}
+		start = next
+	}
+	return chunks
+}
+
+// chunkNodeID names a chunk's node in the ANN graph.
+func chunkNodeID(id string, i int) string {
+	return id + "#" + strconv.Itoa(i)
+}
+
+// resolveNode maps an ANN node to its entry and chunk, -1 for the entry's
+// own embedding. The caller must hold indexMutex.
+func resolveNode(nodeID string) (*IndexEntry, int) {
+	if entry := index[nodeID]; entry != nil {
+		return entry, -1
+	}
+	at := strings.LastIndexByte(nodeID, '#')
+	if at < 0 {
+		return nil, -1
+	}
+	i, err := strconv.Atoi(nodeID[at+1:])
+	entry := index[nodeID[:at]]
+	if err != nil || entry == nil || i < 0 || i >= len(entry.Chunks) {
+		return nil, -1
+	}
+	return entry, i
+}
+
+// annVectors lists every embedding an entry contributes to the ANN graph,
+// keyed by node ID.
+func annVectors(entry *IndexEntry, out map[string]annVector) {
+	if len(entry.Embedding) > 0 {
+		out[entry.ID] = annVector{entry.EmbeddingHash, entry.Embedding}
+	}
+	for i, c := range entry.Chunks {
+		if len(c.Embedding) > 0 {
+			out[chunkNodeID(entry.ID, i)] = annVector{c.EmbeddingHash, c.Embedding}
+		}
+	}
+}
+
+// syncANN replaces old's nodes in the ANN graph with cur's. Either may be
+// nil.
+func syncANN(old, cur *IndexEntry) {
+	next := map[string]annVector{}
+	if cur != nil {
+		annVectors(cur, next)
+	}
+	if old != nil {
+		prev := map[string]annVector{}
+		annVectors(old, prev)
+		for id := range prev {
+			if _, ok := next[id]; !ok {
+				ann.remove(id)
+			}
+		}
+	}
+	for id, v := range next {
+		ann.add(id, v.hash, v.vec)
+	}
+}
+
+// embedEntry fills in the embeddings of entry and its chunks, reusing the
+// vectors of prev where the embedded text and embedder are unchanged. It
+// returns an error if anything is left without an embedding.
+func embedEntry(entry, prev *IndexEntry, model string) error {
+	reuse := map[string][]float64{}
+	if prev != nil && entryEmbedder(prev) == model {
+		if len(prev.Embedding) > 0 {
+			reuse[prev.EmbeddingHash] = prev.Embedding
+		}
+		for _, c := range prev.Chunks {
+			if len(c.Embedding) > 0 {
+				reuse[c.EmbeddingHash] = c.Embedding
+			}
+		}
+	}
+
+	// index 0 is the entry itself, then its chunks
+	texts := []string{embedText(entry.Title, entry.Content)}
+	for i := range entry.Chunks {
+		texts = append(texts, chunkEmbedText(entry.Title, entry.ChunkText(i)))
+	}
+
+	vecs := make([][]float64, len(texts))
+	hashes := make([]string, len(texts))
+	var missing []string
+	var missingAt []int
+	for i, text := range texts {
+		hashes[i] = embedTextHash(text)
+		if v, ok := reuse[hashes[i]]; ok {
+			vecs[i] = v
+			continue
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		missing = append(missing, text)
+		missingAt = append(missingAt, i)
+	}
+
+	var err error
+	if len(missing) > 0 {
+		var got [][]float64
+		if got, err = embedTexts(missing); err == nil {
+			for j, i := range missingAt {
+				vecs[i] = got[j]
+			}
+		}
+	}
+
+	if len(vecs[0]) > 0 {
+		entry.Embedding = vecs[0]
+		entry.EmbeddingHash = hashes[0]
+		entry.EmbeddingModel = model
+	}
+	for i := range entry.Chunks {
+		if v := vecs[i+1]; len(v) > 0 {
+			entry.Chunks[i].Embedding = v
+			entry.Chunks[i].EmbeddingHash = hashes[i+1]
+		}
+	}
+
+	if err == nil && !fullyEmbedded(entry, model) {
+		err = fmt.Errorf("embedder returned no vector for %s", entry.ID)
+	}
+	return err
+}
+
+// fullyEmbedded reports whether an entry and all its chunks have vectors
+// from the named embedder.
+func fullyEmbedded(entry *IndexEntry, model string) bool {
+	if len(entry.Embedding) == 0 || entryEmbedder(entry) != model {
+		return false
+	}
+	for _, c := range entry.Chunks {
+		if len(c.Embedding) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bestSimilarity returns the highest cosine similarity between q and an
+// entry's embeddings, and which chunk gave it (-1 for the entry itself).
+func bestSimilarity(entry *IndexEntry, q []float64) (float64, int) {
+	best, chunk := 0.0, -1
+	if len(entry.Embedding) == len(q) {
+		best = cosineSimilarity(q, entry.Embedding)
+	}
+	for i, c := range entry.Chunks {
+		if len(c.Embedding) != len(q) {
+			continue
+		}
+		if sim := cosineSimilarity(q, c.Embedding); sim > best {
+			best, chunk = sim, i
+		}
+	}
+	return best, chunk
+}
+
+// bestChunkByTerms returns the chunk containing the most query terms,
+// preferring earlier chunks on ties, or -1 if no chunk has any.
+func bestChunkByTerms(entry *IndexEntry, query []string) int {
+	if len(entry.Chunks) == 0 || len(query) == 0 {
+		return -1
+	}
+	want := map[string]bool{}
+	for _, t := range query {
+		want[t] = true
+	}
+
+	best, bestHits := -1, 0
+	for i := range entry.Chunks {
+		hits := 0
+		for _, t := range analyze(entry.ChunkText(i)) {
+			if want[t] {
+				hits++
+			}
+		}
+		if hits > bestHits {
+			best, bestHits = i, hits
+		}
+	}
+	return best
+}
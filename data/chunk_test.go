@@ -0,0 +1,101 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEmbedTextRuneSafe(t *testing.T) {
+	content := strings.Repeat("é", 400) // 800 bytes
+	got := embedText("Title", content)
+	if !utf8.ValidString(got) {
+		t.Fatal("embed text split a rune")
+	}
+	if len(got) > len("Title ")+embedContentBytes {
+		t.Errorf("embed text too long: %d bytes", len(got))
+	}
+}
+
+func TestSplitChunks(t *testing.T) {
+	if chunks := splitChunks(strings.Repeat("word ", 50)); chunks != nil {
+		t.Errorf("short content should not be chunked, got %d chunks", len(chunks))
+	}
+
+	var b strings.Builder
+	for i := 0; b.Len() < 6000; i++ {
+		b.WriteString("Ünïcödé wörds ")
+		if i%20 == 0 {
+			b.WriteString("\n\n")
+		}
+	}
+	content := b.String()
+	entry := &IndexEntry{Content: content, Chunks: splitChunks(content)}
+
+	if len(entry.Chunks) < 3 {
+		t.Fatalf("expected several chunks, got %d", len(entry.Chunks))
+	}
+	if entry.Chunks[0].Start != 0 || entry.Chunks[len(entry.Chunks)-1].End != len(content) {
+		t.Error("chunks do not cover the content")
+	}
+	for i, c := range entry.Chunks {
+		text := entry.ChunkText(i)
+		if !utf8.ValidString(text) {
+			t.Fatalf("chunk %d is not valid UTF-8", i)
+		}
+		if n := utf8.RuneCountInString(text); n > chunkRunes {
+			t.Errorf("chunk %d has %d runes", i, n)
+		}
+		if i > 0 {
+			prev := entry.Chunks[i-1]
+			if c.Start >= prev.End || c.Start <= prev.Start {
+				t.Errorf("chunk %d [%d,%d) does not overlap chunk %d [%d,%d)", i, c.Start, c.End, i-1, prev.Start, prev.End)
+			}
+		}
+	}
+}
+
+func TestSearchReturnsBestChunk(t *testing.T) {
+	useEmbedder(t, NewHashEmbedder(256))
+
+	var b strings.Builder
+	b.WriteString("The city council met on Tuesday to discuss the annual budget. ")
+	for b.Len() < 2000 {
+		b.WriteString("Members debated road repairs, school funding and park maintenance at length. ")
+	}
+	b.WriteString("Late in the session a proposal to build a desalination plant on the northern coast was approved. ")
+	for b.Len() < 4000 {
+		b.WriteString("The meeting closed with routine announcements about upcoming holidays. ")
+	}
+	Index("council", "news", "Council meeting", b.String(), nil)
+
+	entry := GetByID("council")
+	if entry == nil || len(entry.Chunks) < 2 {
+		t.Fatal("long article was not chunked")
+	}
+	for i, c := range entry.Chunks {
+		if len(c.Embedding) == 0 {
+			t.Errorf("chunk %d has no embedding", i)
+		}
+	}
+	if ann.len() != len(entry.Chunks)+1 {
+		t.Errorf("ANN has %d nodes, want %d", ann.len(), len(entry.Chunks)+1)
+	}
+
+	resp := SearchWith("desalination plant", SearchOptions{Limit: 1})
+	if len(resp.Results) != 1 {
+		t.Fatalf("expected one result, got %d", len(resp.Results))
+	}
+	res := resp.Results[0]
+	if res.Entry.ID != "council" {
+		t.Fatalf("unexpected result %s", res.Entry.ID)
+	}
+	if res.Chunk < 1 || !strings.Contains(res.Passage, "desalination") {
+		t.Errorf("expected the desalination paragraph, got chunk %d: %.80q", res.Chunk, res.Passage)
+	}
+
+	Delete("council")
+	if ann.len() != 0 {
+		t.Errorf("chunk nodes left in the ANN graph: %d", ann.len())
+	}
+}
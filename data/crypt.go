@@ -0,0 +1,359 @@
+package data
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ============================================
+// ENCRYPTION AT REST
+// ============================================
+
+// Keys registered with RegisterSensitive, such as settings.json with its
+// API keys and sessions.json with live session IDs, are sealed with
+// AES-256-GCM when a master key is configured. The store key is bound in as
+// associated data so a sealed value cannot be moved to another key.
+//
+// The master key is 32 bytes, base64 or hex encoded, from MU_MASTER_KEY or
+// the first line of the file named by MU_MASTER_KEY_FILE. Previous keys,
+// from MU_MASTER_KEY_OLD (comma separated) or the keyfile's later lines,
+// are used only to read values sealed before a rotation; Rekey reseals
+// everything with the current key. Keep the key outside $HOME/.mu so a
+// copy of the data directory does not carry it.
+//
+// Sealed layout: magic "MUENC" | version uint8 | key id [8] | nonce [12] |
+// ciphertext with GCM tag.
+const (
+	sealMagic   = "MUENC"
+	sealVersion = 1
+	keyIDSize   = 8
+)
+
+var (
+	errNoMasterKey = errors.New("value is encrypted but no master key is configured")
+	errUnknownKey  = errors.New("value is encrypted with an unknown master key")
+	errBadSealed   = errors.New("encrypted value is corrupt or was moved")
+)
+
+type masterKey struct {
+	id   [keyIDSize]byte
+	aead cipher.AEAD
+}
+
+var (
+	keysMu     sync.RWMutex
+	currentKey *masterKey
+	oldKeys    []*masterKey
+
+	sensitiveMu sync.RWMutex
+	sensitive   = map[string]bool{}
+)
+
+// RegisterSensitive marks store keys whose values are encrypted at rest.
+func RegisterSensitive(keys ...string) {
+	sensitiveMu.Lock()
+	defer sensitiveMu.Unlock()
+	for _, k := range keys {
+		if ck, err := cleanKey(k); err == nil {
+			sensitive[ck] = true
+		}
+	}
+}
+
+func isSensitive(key string) bool {
+	k, err := cleanKey(key)
+	if err != nil {
+		return false
+	}
+	sensitiveMu.RLock()
+	defer sensitiveMu.RUnlock()
+	return sensitive[k]
+}
+
+func sensitiveKeys() []string {
+	sensitiveMu.RLock()
+	defer sensitiveMu.RUnlock()
+	out := make([]string, 0, len(sensitive))
+	for k := range sensitive {
+		out = append(out, k)
+	}
+	return out
+}
+
+// LoadMasterKey reads the master key configuration from the environment.
+// With nothing configured encryption stays off and it returns nil.
+func LoadMasterKey() error {
+	var current string
+	var old []string
+	if path := strings.TrimSpace(os.Getenv("MU_MASTER_KEY_FILE")); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read MU_MASTER_KEY_FILE: %w", err)
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				old = append(old, line)
+			}
+		}
+		if len(old) > 0 {
+			current, old = old[0], old[1:]
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("MU_MASTER_KEY")); v != "" {
+		if current != "" {
+			old = append([]string{current}, old...)
+		}
+		current = v
+	}
+	for _, v := range strings.Split(os.Getenv("MU_MASTER_KEY_OLD"), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			old = append(old, v)
+		}
+	}
+	if current == "" && len(old) == 0 {
+		return nil
+	}
+
+	var cur []byte
+	if current != "" {
+		k, err := ParseMasterKey(current)
+		if err != nil {
+			return err
+		}
+		cur = k
+	}
+	prev := make([][]byte, len(old))
+	for i, v := range old {
+		k, err := ParseMasterKey(v)
+		if err != nil {
+			return err
+		}
+		prev[i] = k
+	}
+	return SetMasterKey(cur, prev...)
+}
+
+// ParseMasterKey decodes a base64 or hex encoded 32 byte key.
+func ParseMasterKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 64 {
+		if b, err := hex.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil && len(b) == 32 {
+			return b, nil
+		}
+	}
+	return nil, errors.New("master key must be 32 bytes, base64 or hex encoded")
+}
+
+// SetMasterKey sets the key used to seal sensitive values and any previous
+// keys still accepted for reading. A nil current key turns sealing off;
+// values are then written in the clear and old keys still read.
+func SetMasterKey(current []byte, old ...[]byte) error {
+	var cur *masterKey
+	if current != nil {
+		k, err := newMasterKey(current)
+		if err != nil {
+			return err
+		}
+		cur = k
+	}
+	var prev []*masterKey
+	for _, o := range old {
+		k, err := newMasterKey(o)
+		if err != nil {
+			return err
+		}
+		prev = append(prev, k)
+	}
+
+	keysMu.Lock()
+	currentKey, oldKeys = cur, prev
+	keysMu.Unlock()
+	return nil
+}
+
+// EncryptionEnabled reports whether sensitive values are sealed on write.
+func EncryptionEnabled() bool {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	return currentKey != nil
+}
+
+func newMasterKey(raw []byte) (*masterKey, error) {
+	if len(raw) != 32 {
+		return nil, errors.New("master key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	k := &masterKey{aead: aead}
+	sum := sha256.Sum256(raw)
+	copy(k.id[:], sum[:keyIDSize])
+	return k, nil
+}
+
+// sealValue encrypts val for key if key is sensitive and a master key is
+// set, and returns it unchanged otherwise.
+func sealValue(key string, val []byte) ([]byte, error) {
+	if !isSensitive(key) {
+		return val, nil
+	}
+	keysMu.RLock()
+	k := currentKey
+	keysMu.RUnlock()
+	if k == nil {
+		return val, nil
+	}
+
+	ck, _ := cleanKey(key)
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(sealMagic)+1+keyIDSize+len(nonce)+len(val)+k.aead.Overhead())
+	out = append(out, sealMagic...)
+	out = append(out, sealVersion)
+	out = append(out, k.id[:]...)
+	out = append(out, nonce...)
+	return k.aead.Seal(out, nonce, val, []byte(ck)), nil
+}
+
+// openValue decrypts a value written by sealValue. Values that were never
+// sealed, including those saved before encryption was turned on, are
+// returned as they are.
+func openValue(key string, val []byte) ([]byte, error) {
+	if !isSealed(val) {
+		return val, nil
+	}
+	head := len(sealMagic) + 1
+	if len(val) < head+keyIDSize {
+		return nil, errBadSealed
+	}
+	if val[len(sealMagic)] != sealVersion {
+		return nil, fmt.Errorf("unsupported encryption version %d", val[len(sealMagic)])
+	}
+	id := val[head : head+keyIDSize]
+
+	keysMu.RLock()
+	candidates := append([]*masterKey{currentKey}, oldKeys...)
+	keysMu.RUnlock()
+
+	for _, k := range candidates {
+		if k == nil || !bytes.Equal(k.id[:], id) {
+			continue
+		}
+		body := val[head+keyIDSize:]
+		if len(body) < k.aead.NonceSize() {
+			return nil, errBadSealed
+		}
+		ck, _ := cleanKey(key)
+		plain, err := k.aead.Open(nil, body[:k.aead.NonceSize()], body[k.aead.NonceSize():], []byte(ck))
+		if err != nil {
+			return nil, errBadSealed
+		}
+		return plain, nil
+	}
+	if candidates[0] == nil && len(candidates) == 1 {
+		return nil, errNoMasterKey
+	}
+	return nil, errUnknownKey
+}
+
+func isSealed(val []byte) bool {
+	return bytes.HasPrefix(val, []byte(sealMagic))
+}
+
+// sealedWith reports whether val is already in the form k would write: in
+// the clear when k is nil, otherwise sealed with k.
+func sealedWith(val []byte, k *masterKey) bool {
+	if k == nil {
+		return !isSealed(val)
+	}
+	head := len(sealMagic) + 1
+	return isSealed(val) && len(val) >= head+keyIDSize && bytes.Equal(val[head:head+keyIDSize], k.id[:])
+}
+
+// Rekey reseals every sensitive value with the current master key, or
+// writes them in the clear if there is none. Run it after rotating keys,
+// with the previous key still configured as an old key, and after turning
+// encryption on or off. It returns how many values were rewritten.
+func Rekey() (int, error) {
+	writeGate.Lock()
+	defer writeGate.Unlock()
+
+	keysMu.RLock()
+	cur := currentKey
+	keysMu.RUnlock()
+
+	n := 0
+	err := CurrentStore().Tx(func(tx Tx) error {
+		for _, key := range sensitiveKeys() {
+			raw, err := tx.Get(key)
+			if IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if sealedWith(raw, cur) {
+				continue
+			}
+			plain, err := openValue(key, raw)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			sealed, err := sealValue(key, plain)
+			if err != nil {
+				return err
+			}
+			if err := tx.Put(key, sealed); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// sealedTx seals and opens sensitive values passing through a raw store
+// transaction, so migrations see plain values.
+type sealedTx struct {
+	Tx
+}
+
+func (t sealedTx) Get(key string) ([]byte, error) {
+	b, err := t.Tx.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return openValue(key, b)
+}
+
+func (t sealedTx) Put(key string, val []byte) error {
+	b, err := sealValue(key, val)
+	if err != nil {
+		return err
+	}
+	return t.Tx.Put(key, b)
+}
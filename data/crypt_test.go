@@ -0,0 +1,139 @@
+package data
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+// useMasterKey sets the master key and a store for one test.
+func useMasterKey(t *testing.T, current []byte, old ...[]byte) {
+	t.Helper()
+	if err := SetMasterKey(current, old...); err != nil {
+		t.Fatal(err)
+	}
+	useTempStore(t)
+	t.Cleanup(func() { SetMasterKey(nil) })
+}
+
+func TestSensitiveValuesEncrypted(t *testing.T) {
+	RegisterSensitive("secret_test.json")
+	useMasterKey(t, testKey(1))
+
+	if err := SaveJSON("secret_test.json", map[string]string{"api_key": "sk-12345"}); err != nil {
+		t.Fatal(err)
+	}
+	SaveJSON("plain_test.json", map[string]string{"title": "hello"})
+
+	raw, _ := CurrentStore().Get("secret_test.json")
+	if bytes.Contains(raw, []byte("sk-12345")) || !isSealed(raw) {
+		t.Errorf("sensitive value stored in the clear: %q", raw)
+	}
+	if raw, _ := CurrentStore().Get("plain_test.json"); isSealed(raw) {
+		t.Error("value not marked sensitive was encrypted")
+	}
+
+	var got map[string]string
+	if err := LoadJSON("secret_test.json", &got); err != nil || got["api_key"] != "sk-12345" {
+		t.Fatalf("LoadJSON = %v, %v", got, err)
+	}
+
+	// a sealed value is bound to its key
+	CurrentStore().Put("other.json", raw)
+	RegisterSensitive("other.json")
+	if _, err := LoadFile("other.json"); err == nil {
+		t.Error("value moved to another key decrypted")
+	}
+
+	flipped := append([]byte(nil), raw...)
+	flipped[len(flipped)-1] ^= 1
+	CurrentStore().Put("secret_test.json", flipped)
+	if _, err := LoadFile("secret_test.json"); err == nil {
+		t.Error("tampered value decrypted")
+	}
+
+	CurrentStore().Put("secret_test.json", raw)
+	SetMasterKey(testKey(2))
+	if _, err := LoadFile("secret_test.json"); err == nil {
+		t.Error("value decrypted with the wrong key")
+	}
+	SetMasterKey(nil)
+	if _, err := LoadFile("secret_test.json"); err != errNoMasterKey {
+		t.Errorf("without a key got %v, want %v", err, errNoMasterKey)
+	}
+}
+
+func TestRekeyRotatesKeys(t *testing.T) {
+	RegisterSensitive("secret_test.json")
+	useMasterKey(t, nil)
+
+	// written before encryption was turned on
+	SaveFile("secret_test.json", `{"token":"abc"}`)
+
+	SetMasterKey(testKey(1))
+	if b, err := LoadFile("secret_test.json"); err != nil || string(b) != `{"token":"abc"}` {
+		t.Fatalf("plain value unreadable with a key set: %q %v", b, err)
+	}
+	if n, err := Rekey(); err != nil || n != 1 {
+		t.Fatalf("Rekey = %d, %v", n, err)
+	}
+	if n, _ := Rekey(); n != 0 {
+		t.Errorf("second Rekey rewrote %d values", n)
+	}
+
+	// rotate: new key, old one kept for reading
+	SetMasterKey(testKey(2), testKey(1))
+	if b, err := LoadFile("secret_test.json"); err != nil || string(b) != `{"token":"abc"}` {
+		t.Fatalf("old key did not read: %q %v", b, err)
+	}
+	if n, err := Rekey(); err != nil || n != 1 {
+		t.Fatalf("Rekey after rotation = %d, %v", n, err)
+	}
+
+	SetMasterKey(testKey(2))
+	if b, err := LoadFile("secret_test.json"); err != nil || string(b) != `{"token":"abc"}` {
+		t.Errorf("rekeyed value unreadable with the new key alone: %q %v", b, err)
+	}
+
+	// and back to the clear
+	SetMasterKey(nil, testKey(2))
+	Rekey()
+	if raw, _ := CurrentStore().Get("secret_test.json"); string(raw) != `{"token":"abc"}` {
+		t.Errorf("decrypted value = %q", raw)
+	}
+}
+
+func TestParseMasterKey(t *testing.T) {
+	key := testKey(7)
+	for _, s := range []string{
+		base64.StdEncoding.EncodeToString(key),
+		base64.RawURLEncoding.EncodeToString(key),
+		strings.Repeat("07", 32),
+	} {
+		if got, err := ParseMasterKey(s); err != nil || !bytes.Equal(got, key) {
+			t.Errorf("ParseMasterKey(%q) = %x, %v", s, got, err)
+		}
+	}
+	if _, err := ParseMasterKey("too short"); err == nil {
+		t.Error("short key accepted")
+	}
+}
+
+func TestLoadMasterKeyOldOnly(t *testing.T) {
+	t.Cleanup(func() { SetMasterKey(nil) })
+	t.Setenv("MU_MASTER_KEY", "")
+	t.Setenv("MU_MASTER_KEY_FILE", "")
+	t.Setenv("MU_MASTER_KEY_OLD", base64.StdEncoding.EncodeToString(testKey(3)))
+
+	if err := LoadMasterKey(); err != nil {
+		t.Fatal(err)
+	}
+	if EncryptionEnabled() {
+		t.Error("an old key alone turned encryption on")
+	}
+}
@@ -1,18 +1,12 @@
 package data
 
 import (
-	"bytes"
-	"container/heap"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"math"
-	"net"
-	"net/http"
 	"os"
-	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
@@ -21,46 +15,51 @@ import (
 	"time"
 )
 
-// SaveFile saves data to disk
+// SaveFile saves data to the current store
 func SaveFile(key, val string) error {
-	dir := os.ExpandEnv("$HOME/.mu")
-	path := filepath.Join(dir, "data")
-	file := filepath.Join(path, key)
-	os.MkdirAll(path, 0700)
-	os.WriteFile(file, []byte(val), 0644)
-	return nil
+	writeGate.RLock()
+	defer writeGate.RUnlock()
+	return putValue(key, []byte(val))
 }
 
-// LoadFile loads a file from disk
+// LoadFile loads a file from the current store
 func LoadFile(key string) ([]byte, error) {
-	dir := os.ExpandEnv("$HOME/.mu")
-	path := filepath.Join(dir, "data")
-	file := filepath.Join(path, key)
-	return os.ReadFile(file)
+	b, err := CurrentStore().Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return openValue(key, b)
 }
 
+// putValue writes to the current store, sealing sensitive values.
+func putValue(key string, val []byte) error {
+	b, err := sealValue(key, val)
+	if err != nil {
+		return err
+	}
+	return CurrentStore().Put(key, b)
+}
+
+// SaveJSON marshals val and saves it to the current store.
 func SaveJSON(key string, val interface{}) error {
+	writeGate.RLock()
+	defer writeGate.RUnlock()
+	return saveJSON(key, val)
+}
+
+// saveJSON is SaveJSON for callers already holding writeGate.
+func saveJSON(key string, val interface{}) error {
 	b, err := json.Marshal(val)
 	if err != nil {
 		return err
 	}
 
-	dir := os.ExpandEnv("$HOME/.mu")
-	path := filepath.Join(dir, "data")
-	file := filepath.Join(path, key)
-	os.MkdirAll(filepath.Dir(file), 0700)
-	os.WriteFile(file, b, 0644)
-
-	return nil
+	return putValue(key, b)
 }
 
-// LoadJSON loads JSON from disk into the provided struct pointer.
+// LoadJSON loads JSON from the current store into the provided struct pointer.
 func LoadJSON(key string, val interface{}) error {
-	dir := os.ExpandEnv("$HOME/.mu")
-	path := filepath.Join(dir, "data")
-	file := filepath.Join(path, key)
-
-	b, err := os.ReadFile(file)
+	b, err := LoadFile(key)
 	if err != nil {
 		return err
 	}
@@ -76,11 +75,14 @@ var (
 	indexMutex sync.RWMutex
 	index      = make(map[string]*IndexEntry)
 
-	embeddingCacheMu sync.RWMutex
-	embeddingCache   = make(map[string][]float64)
+	// writeGate is held for reading by every write to the store and for
+	// writing while a backup or restore needs the store to stand still.
+	writeGate sync.RWMutex
+
+	// indexDirty is set while index changes are waiting to be saved.
+	indexDirty atomic.Bool
 
 	embeddingsEnabled atomic.Bool
-	embeddingClient   = &http.Client{Timeout: 5 * time.Second}
 
 	persistRequestCh = make(chan struct{}, 1)
 	persistFlushCh   = make(chan chan struct{})
@@ -88,38 +90,28 @@ var (
 
 // IndexEntry represents a searchable piece of content
 type IndexEntry struct {
-	ID            string                 `json:"id"`
-	Type          string                 `json:"type"` // "news", "video", "market", "reminder"
-	Title         string                 `json:"title"`
-	Content       string                 `json:"content"`
-	TitleLower    string                 `json:"title_lower,omitempty"`
-	ContentLower  string                 `json:"content_lower,omitempty"`
-	Metadata      map[string]interface{} `json:"metadata"`
-	Embedding     []float64              `json:"embedding"`      // Vector embedding for semantic search
-	EmbeddingHash string                 `json:"embedding_hash"` // Hash of embedded text to avoid recompute
-	IndexedAt     time.Time              `json:"indexed_at"`
+	ID             string                 `json:"id"`
+	Type           string                 `json:"type"` // "news", "video", "market", "reminder"
+	Title          string                 `json:"title"`
+	Content        string                 `json:"content"`
+	TitleLower     string                 `json:"title_lower,omitempty"`
+	ContentLower   string                 `json:"content_lower,omitempty"`
+	Metadata       map[string]interface{} `json:"metadata"`
+	Embedding      []float64              `json:"embedding,omitempty"`       // Vector embedding for semantic search, saved in the vector file
+	EmbeddingHash  string                 `json:"embedding_hash"`            // Hash of embedded text to avoid recompute
+	EmbeddingModel string                 `json:"embedding_model,omitempty"` // Embedder name, empty for the original Ollama model
+	Chunks         []Chunk                `json:"chunks,omitempty"`          // Overlapping spans of long content, each embedded
+	IndexedAt      time.Time              `json:"indexed_at"`
 }
 
 // SearchResult represents a search hit with relevance score
 type SearchResult struct {
-	Entry *IndexEntry
-	Score float64
-}
-
-type resultHeap []SearchResult
-
-func (h resultHeap) Len() int           { return len(h) }
-func (h resultHeap) Less(i, j int) bool { return h[i].Score < h[j].Score }
-func (h resultHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
-func (h *resultHeap) Push(x interface{}) {
-	*h = append(*h, x.(SearchResult))
-}
-func (h *resultHeap) Pop() interface{} {
-	old := *h
-	n := len(old)
-	item := old[n-1]
-	*h = old[0 : n-1]
-	return item
+	Entry      *IndexEntry
+	Score      float64 // fused rank score, 1 when ranked first by every signal
+	TextScore  float64 // BM25 score, zero without a keyword match
+	Similarity float64 // cosine similarity, zero without a vector match
+	Chunk      int     // index of the best matching chunk, -1 if the entry has none
+	Passage    string  // text of the best matching chunk, empty if the entry has none
 }
 
 const (
@@ -194,6 +186,7 @@ func persistWorker() {
 }
 
 func schedulePersist() {
+	indexDirty.Store(true)
 	select {
 	case persistRequestCh <- struct{}{}:
 	default:
@@ -229,8 +222,8 @@ func Index(id, entryType, title, content string, metadata map[string]interface{}
 			existing.Content == content &&
 			reflect.DeepEqual(existing.Metadata, metadata)
 
-		// If nothing changed and we already have an embedding, skip re-indexing
-		if sameContent && len(existing.Embedding) > 0 {
+		// If nothing changed and we already have embeddings, skip re-indexing
+		if sameContent && fullyEmbedded(existing, CurrentEmbedder().Name()) {
 			return
 		}
 	}
@@ -243,45 +236,48 @@ func Index(id, entryType, title, content string, metadata map[string]interface{}
 		Content:      content,
 		ContentLower: strings.ToLower(content),
 		Metadata:     metadata,
+		Chunks:       splitChunks(content),
 		IndexedAt:    time.Now(),
 	}
 
-	// Generate embedding for semantic search
-	textToEmbed := title
-	if len(content) > 0 {
-		// Combine title and beginning of content for better embeddings
-		maxContent := 500
-		if len(content) < maxContent {
-			maxContent = len(content)
-		}
-		textToEmbed = title + " " + content[:maxContent]
-	}
+	// Generate embeddings for semantic search, reusing any whose text and
+	// provider haven't changed. Whatever fails is picked up by the backfill.
+	embedEntry(entry, existing, CurrentEmbedder().Name())
 
-	embedHash := fmt.Sprintf("%x", sha256.Sum256([]byte(textToEmbed)))
+	putEntry(entry)
+}
 
-	var embedding []float64
+func embedTextHash(text string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(text)))
+}
 
-	// Reuse existing embedding if the embedded text hasn't changed
-	if existing != nil && existing.EmbeddingHash == embedHash && len(existing.Embedding) > 0 {
-		embedding = existing.Embedding
-	} else {
-		var err error
-		embedding, err = getEmbedding(textToEmbed)
-		if err != nil {
-			embedding = nil
-		}
-	}
+// putEntry stores an entry, keeps the inverted index and ANN graph in sync
+// and schedules a save.
+func putEntry(entry *IndexEntry) {
+	indexMutex.Lock()
+	old := index[entry.ID]
+	index[entry.ID] = entry
+	indexMutex.Unlock()
 
-	if len(embedding) > 0 {
-		entry.Embedding = embedding
-		entry.EmbeddingHash = embedHash
-	}
+	textIndex.add(entry.ID, entry.Title, entry.Content)
+	syncANN(old, entry)
+
+	// Persist to disk
+	schedulePersist()
+}
 
+// Delete removes an entry from the index. Unknown ids are ignored.
+func Delete(id string) {
 	indexMutex.Lock()
-	index[id] = entry
+	old, ok := index[id]
+	delete(index, id)
 	indexMutex.Unlock()
+	if !ok {
+		return
+	}
 
-	// Persist to disk
+	textIndex.remove(id)
+	syncANN(old, nil)
 	schedulePersist()
 }
 
@@ -292,70 +288,155 @@ func GetByID(id string) *IndexEntry {
 	return index[id]
 }
 
-// Search performs semantic vector search with keyword fallback
+// Search ranks entries by BM25 keyword relevance fused with vector
+// similarity, so it still works when embeddings are unavailable.
 func Search(query string, limit int) []*IndexEntry {
+	return SearchWith(query, SearchOptions{Limit: limit}).Entries()
+}
+
+// search ranks entries by fusing BM25 keyword scores with vector
+// similarity. Keyword candidates come from the inverted index. Vector
+// candidates come from the ANN graph when the index is large enough and a
+// full scan otherwise; keyword hits the graph missed still get an exact
+// cosine so both signals see them. An entry's similarity is the best of its
+// own embedding and its chunks'. Entries failing the filters in opts are
+// dropped before either ranking is built.
+func search(query string, queryEmbedding []float64, opts SearchOptions) *SearchResponse {
+	w := GetRankWeights()
+	filtered := opts.filtered()
+	resp := &SearchResponse{}
+
+	terms := analyze(query)
+	text := textIndex.score(terms, w.K1, w.B)
+	vector := map[string]float64{}
+	chunk := map[string]int{} // best chunk by similarity
+
 	indexMutex.RLock()
-	snapshot := make([]*IndexEntry, 0, len(index))
-	for _, entry := range index {
-		snapshot = append(snapshot, entry)
-	}
-	indexMutex.RUnlock()
+	defer indexMutex.RUnlock()
 
-	if len(snapshot) == 0 {
-		return nil
+	if len(index) == 0 {
+		resp.Facets = facetCounts(nil, opts.Facets)
+		return resp
 	}
 
-	queryLower := strings.ToLower(query)
-
-	useVectors := embeddingsEnabled.Load()
-	var queryEmbedding []float64
-	if useVectors {
-		var err error
-		queryEmbedding, err = getEmbedding(query)
-		if err != nil || len(queryEmbedding) == 0 {
-			useVectors = false
+	if filtered {
+		for id := range text {
+			if !opts.matches(index[id]) {
+				delete(text, id)
+			}
 		}
 	}
 
-	if limit <= 0 || limit > len(snapshot) {
-		limit = len(snapshot)
+	if len(queryEmbedding) > 0 {
+		if annEnabled() && ann.len() >= annMinEntries {
+			k := (opts.Offset + opts.Limit) * 4
+			if k < annEfSearch {
+				k = annEfSearch
+			}
+			if filtered {
+				// filters discard some of the graph's candidates
+				k *= 4
+			}
+			for node, sim := range ann.search(queryEmbedding, k) {
+				entry, c := resolveNode(node)
+				if entry == nil || sim < w.MinSimilarity || sim <= vector[entry.ID] || (filtered && !opts.matches(entry)) {
+					continue
+				}
+				vector[entry.ID] = sim
+				chunk[entry.ID] = c
+			}
+			for id := range text {
+				if _, ok := vector[id]; ok {
+					continue
+				}
+				if entry := index[id]; entry != nil {
+					if sim, c := bestSimilarity(entry, queryEmbedding); sim >= w.MinSimilarity {
+						vector[id] = sim
+						chunk[id] = c
+					}
+				}
+			}
+		} else {
+			for id, entry := range index {
+				if filtered && !opts.matches(entry) {
+					continue
+				}
+				if sim, c := bestSimilarity(entry, queryEmbedding); sim >= w.MinSimilarity {
+					vector[id] = sim
+					chunk[id] = c
+				}
+			}
+		}
 	}
 
-	h := &resultHeap{}
-	heap.Init(h)
+	fused := fuseRanks(text, vector, w)
 
-	for _, entry := range snapshot {
-		score := rankEntry(entry, queryLower, queryEmbedding, useVectors)
-		if score <= 0 {
+	// scale so an entry ranked first by every signal scores 1
+	top := (w.Text + w.Vector) / (w.RRFK + 1)
+
+	results := make([]SearchResult, 0, len(fused))
+	for id, score := range fused {
+		entry := index[id]
+		if entry == nil || score <= 0 {
 			continue
 		}
-
-		if h.Len() < limit {
-			heap.Push(h, SearchResult{Entry: entry, Score: score})
+		score /= top
+		if score < opts.MinScore {
 			continue
 		}
-
-		if score > (*h)[0].Score {
-			heap.Pop(h)
-			heap.Push(h, SearchResult{Entry: entry, Score: score})
+		results = append(results, SearchResult{
+			Entry:      entry,
+			Score:      score,
+			TextScore:  text[id],
+			Similarity: vector[id],
+			Chunk:      -1,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
 		}
-	}
+		if a.TextScore != b.TextScore {
+			return a.TextScore > b.TextScore
+		}
+		return a.Entry.ID < b.Entry.ID
+	})
 
-	if h.Len() == 0 {
-		return nil
-	}
+	resp.Total = len(results)
+	resp.Facets = facetCounts(results, opts.Facets)
 
-	results := make([]SearchResult, h.Len())
-	for i := len(results) - 1; i >= 0; i-- {
-		results[i] = heap.Pop(h).(SearchResult)
+	if opts.Offset > 0 {
+		if opts.Offset >= len(results) {
+			results = nil
+		} else {
+			results = results[opts.Offset:]
+		}
+	}
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
 	}
 
-	entries := make([]*IndexEntry, len(results))
-	for i, r := range results {
-		entries[i] = r.Entry
+	// pick the passage of long entries: the chunk closest to the query
+	// vector, else the one with the most query terms, else the opening
+	for i := range results {
+		r := &results[i]
+		if len(r.Entry.Chunks) == 0 {
+			continue
+		}
+		c, ok := chunk[r.Entry.ID]
+		if !ok || c < 0 {
+			if c = bestChunkByTerms(r.Entry, terms); c < 0 {
+				c = 0
+			}
+		}
+		r.Chunk = c
+		r.Passage = r.Entry.ChunkText(c)
 	}
+	resp.Results = results
 
-	return entries
+	return resp
 }
 
 // GetByType returns all entries of a specific type
@@ -387,19 +468,57 @@ func ClearIndex() {
 	indexMutex.Lock()
 	index = make(map[string]*IndexEntry)
 	indexMutex.Unlock()
+	textIndex.reset()
+	ann.reset()
 	schedulePersist()
 }
 
 // saveIndex persists the index to disk
 func saveIndex() {
+	indexDirty.Store(false)
+
+	// encode a copy under the lock so indexing only waits for the copy,
+	// not for the store
 	indexMutex.RLock()
-	defer indexMutex.RUnlock()
+	meta, vecs := splitVectors(index)
+	vb := encodeVectors(vecs, vectorFormat)
+	mb, err := json.Marshal(meta)
+	graph := ann.snapshot()
+	indexMutex.RUnlock()
+	if err != nil {
+		fmt.Printf("[data] Failed to encode index: %v\n", err)
+		return
+	}
 
-	SaveJSON("index.json", index)
+	// the files below must agree with each other, so a backup waits for
+	// the whole set
+	writeGate.RLock()
+	defer writeGate.RUnlock()
+
+	// vectors first so a crash in between leaves index.json pointing at
+	// hashes the vector file already has, or at old ones it ignores
+	if err := CurrentStore().Put(vectorsFile, vb); err != nil {
+		fmt.Printf("[data] Failed to save embeddings: %v\n", err)
+	}
+
+	if err := putValue("index.json", mb); err != nil {
+		fmt.Printf("[data] Failed to save index: %v\n", err)
+	}
+
+	// the graph is saved alongside so restarts skip the rebuild
+	if err := saveJSON(annFile, graph); err != nil {
+		fmt.Printf("[data] Failed to save ANN graph: %v\n", err)
+	}
+
+	if err := embeddingCache.save(embeddingCacheFile); err != nil {
+		fmt.Printf("[data] Failed to save embedding cache: %v\n", err)
+	}
 }
 
 // Load loads the index from disk
 func Load() {
+	startCompactor()
+
 	b, err := LoadFile("index.json")
 	if err != nil {
 		return
@@ -410,98 +529,60 @@ func Load() {
 
 	json.Unmarshal(b, &index)
 
+	// indexes written before the vector file carry embeddings inline;
+	// they are kept and moved to the vector file by the next save
+	inline := 0
 	for _, entry := range index {
-		ensureLowerFields(entry)
-	}
-
-	// load embedding cache (best-effort)
-	if cacheBytes, err := LoadFile("embedding_cache.json"); err == nil && len(cacheBytes) > 0 {
-		var cache map[string][]float64
-		if err := json.Unmarshal(cacheBytes, &cache); err == nil && cache != nil {
-			embeddingCacheMu.Lock()
-			embeddingCache = cache
-			embeddingCacheMu.Unlock()
+		if len(entry.Embedding) > 0 {
+			inline++
 		}
 	}
-}
-
-// ============================================
-// VECTOR EMBEDDINGS VIA OLLAMA
-// ============================================
-
-// getEmbedding generates a vector embedding for text using Ollama
-func getEmbedding(text string) ([]float64, error) {
-	if !embeddingsEnabled.Load() {
-		return nil, errEmbeddingsDisabled
-	}
-
-	if strings.TrimSpace(text) == "" {
-		return nil, fmt.Errorf("empty text")
-	}
-
-	// return cached embedding if available
-	key := strings.TrimSpace(text)
-	embeddingCacheMu.RLock()
-	if cached, ok := embeddingCache[key]; ok && len(cached) > 0 {
-		embeddingCacheMu.RUnlock()
-		return cached, nil
-	}
-	embeddingCacheMu.RUnlock()
-
-	fmt.Printf("[data] Generating embedding for text (length: %d)\n", len(text))
 
-	// Ollama embedding endpoint
-	url := "http://localhost:11434/api/embeddings"
-
-	model := strings.TrimSpace(os.Getenv("OLLAMA_EMBED_MODEL"))
-	if model == "" {
-		model = defaultEmbedModel
+	if vb, err := LoadFile(vectorsFile); err == nil && len(vb) > 0 {
+		if vecs, err := decodeVectors(vb); err != nil {
+			fmt.Printf("[data] Failed to load embeddings: %v\n", err)
+		} else {
+			attachVectors(vecs)
+		}
 	}
 
-	requestBody := map[string]interface{}{
-		"model":  model,
-		"prompt": text,
+	if inline > 0 {
+		fmt.Printf("[data] Migrating %d inline embeddings to %s\n", inline, vectorsFile)
+		schedulePersist()
 	}
 
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, err
-	}
+	textIndex.reset()
+	for _, entry := range index {
+		ensureLowerFields(entry)
+		textIndex.add(entry.ID, entry.Title, entry.Content)
 
-	resp, err := embeddingClient.Post(url, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		var netErr net.Error
-		if errors.As(err, &netErr) {
-			disableEmbeddings(fmt.Sprintf("network error: %v", netErr))
+		// entries indexed before chunking get chunks now and their
+		// embeddings from the backfill
+		if entry.Chunks == nil {
+			entry.Chunks = splitChunks(entry.Content)
 		}
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("ollama error: %s", string(body))
 	}
 
-	var result struct {
-		Embedding []float64 `json:"embedding"`
+	var snap *annSnapshot
+	if err := LoadJSON(annFile, &snap); err != nil {
+		snap = nil
 	}
+	ann.restore(snap, index)
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	// load embedding cache (best-effort)
+	if cacheBytes, err := LoadFile(embeddingCacheFile); err == nil && len(cacheBytes) > 0 {
+		if err := embeddingCache.load(cacheBytes); err != nil {
+			fmt.Printf("[data] Failed to load embedding cache: %v\n", err)
+		}
 	}
 
-	// persist to cache asynchronously (best-effort)
-	embeddingCacheMu.Lock()
-	embeddingCache[key] = result.Embedding
-	embeddingCacheMu.Unlock()
-	go func(snapshot map[string][]float64) {
-		SaveJSON("embedding_cache.json", snapshot)
-	}(copyEmbeddingCache())
-
-	return result.Embedding, nil
+	go backfillEmbeddings()
 }
 
+// ============================================
+// VECTOR SIMILARITY
+// ============================================
+
 // cosineSimilarity calculates cosine similarity between two vectors
 func cosineSimilarity(a, b []float64) float64 {
 	if len(a) != len(b) {
@@ -522,40 +603,3 @@ func cosineSimilarity(a, b []float64) float64 {
 
 	return dotProduct / (math.Sqrt(normA) * math.Sqrt(normB))
 }
-
-func copyEmbeddingCache() map[string][]float64 {
-	embeddingCacheMu.RLock()
-	defer embeddingCacheMu.RUnlock()
-	cp := make(map[string][]float64, len(embeddingCache))
-	for k, v := range embeddingCache {
-		cp[k] = v
-	}
-	return cp
-}
-
-func rankEntry(entry *IndexEntry, queryLower string, queryEmbedding []float64, useVectors bool) float64 {
-	var score float64
-
-	if useVectors && len(entry.Embedding) > 0 && len(queryEmbedding) == len(entry.Embedding) {
-		similarity := cosineSimilarity(queryEmbedding, entry.Embedding)
-		if similarity > 0.3 {
-			titleHit := strings.Contains(entry.TitleLower, queryLower)
-			contentHit := strings.Contains(entry.ContentLower, queryLower)
-
-			if titleHit || contentHit || similarity >= 0.6 {
-				score = similarity
-			}
-		}
-	}
-
-	if score == 0 {
-		switch {
-		case strings.Contains(entry.TitleLower, queryLower):
-			score = 3.0
-		case strings.Contains(entry.ContentLower, queryLower):
-			score = 1.0
-		}
-	}
-
-	return score
-}
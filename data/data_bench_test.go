@@ -2,11 +2,14 @@ package data
 
 import (
 	"fmt"
+	"math/rand"
 	"strings"
+	"sync"
 	"testing"
 )
 
 func BenchmarkSearchFallback(b *testing.B) {
+	useTempStore(b)
 	b.ReportAllocs()
 	disableEmbeddings("benchmark")
 	ClearIndex()
@@ -24,6 +27,7 @@ func BenchmarkSearchFallback(b *testing.B) {
 }
 
 func BenchmarkIndexing(b *testing.B) {
+	useTempStore(b)
 	b.ReportAllocs()
 	disableEmbeddings("benchmark")
 	ClearIndex()
@@ -36,3 +40,62 @@ func BenchmarkIndexing(b *testing.B) {
 	}
 	FlushIndex()
 }
+
+const (
+	benchVectorEntries = 50000
+	benchVectorDims    = 64
+)
+
+var benchVectorOnce sync.Once
+
+// loadVectorBench fills the index with random unit vectors so vector search
+// can be measured without an embedding server.
+func loadVectorBench() {
+	benchVectorOnce.Do(func() {
+		ClearIndex()
+		rng := rand.New(rand.NewSource(1))
+		for i := 0; i < benchVectorEntries; i++ {
+			id := fmt.Sprintf("vec-%d", i)
+			putEntry(&IndexEntry{
+				ID:            id,
+				Type:          "news",
+				Title:         fmt.Sprintf("Headline %d", i),
+				TitleLower:    fmt.Sprintf("headline %d", i),
+				Embedding:     randomVector(rng, benchVectorDims),
+				EmbeddingHash: id,
+			})
+		}
+		FlushIndex()
+	})
+}
+
+func randomVector(rng *rand.Rand, dims int) []float64 {
+	v := make([]float64, dims)
+	for i := range v {
+		v[i] = rng.NormFloat64()
+	}
+	return v
+}
+
+func BenchmarkSearchVectorsBruteForce(b *testing.B) {
+	loadVectorBench()
+	b.Setenv("MU_ANN", "off")
+	rng := rand.New(rand.NewSource(2))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		search("query", randomVector(rng, benchVectorDims), SearchOptions{Limit: 3})
+	}
+}
+
+func BenchmarkSearchVectorsANN(b *testing.B) {
+	loadVectorBench()
+	rng := rand.New(rand.NewSource(2))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		search("query", randomVector(rng, benchVectorDims), SearchOptions{Limit: 3})
+	}
+}
@@ -1,8 +1,11 @@
 package data
 
 import (
+	"fmt"
 	"math"
+	"math/rand"
 	"os"
+	"sort"
 	"testing"
 )
 
@@ -10,9 +13,9 @@ func TestMain(m *testing.M) {
 	tmpDir, _ := os.MkdirTemp("", "mu_test_data")
 	originalHome := os.Getenv("HOME")
 	_ = os.Setenv("HOME", tmpDir)
-
 	code := m.Run()
 
+	FlushIndex()
 	_ = os.Setenv("HOME", originalHome)
 	os.RemoveAll(tmpDir)
 	os.Exit(code)
@@ -41,6 +44,7 @@ func TestCosineSimilarity(t *testing.T) {
 }
 
 func TestIndexingAndFallbackSearch(t *testing.T) {
+	useTempStore(t)
 	ClearIndex()
 
 	Index("1", "news", "Bitcoin hits new high", "Crypto markets are rallying today.", nil)
@@ -65,3 +69,153 @@ func TestIndexingAndFallbackSearch(t *testing.T) {
 		t.Error("Search returned wrong item")
 	}
 }
+
+func TestANNRecall(t *testing.T) {
+	g := newHNSW()
+	rng := rand.New(rand.NewSource(7))
+
+	vecs := map[string][]float64{}
+	for i := 0; i < 2000; i++ {
+		id := fmt.Sprintf("v%d", i)
+		vecs[id] = randomVector(rng, 32)
+		g.add(id, id, vecs[id])
+	}
+
+	const k = 10
+	var hits, total int
+	for q := 0; q < 50; q++ {
+		query := randomVector(rng, 32)
+
+		type scored struct {
+			id  string
+			sim float64
+		}
+		var exact []scored
+		for id, v := range vecs {
+			exact = append(exact, scored{id, cosineSimilarity(query, v)})
+		}
+		sort.Slice(exact, func(i, j int) bool { return exact[i].sim > exact[j].sim })
+
+		got := g.search(query, k)
+		for _, e := range exact[:k] {
+			if _, ok := got[e.id]; ok {
+				hits++
+			}
+			total++
+		}
+	}
+
+	if recall := float64(hits) / float64(total); recall < 0.9 {
+		t.Errorf("ANN recall@%d = %.2f, want >= 0.9", k, recall)
+	}
+}
+
+func TestANNRemoveAndSnapshot(t *testing.T) {
+	g := newHNSW()
+	rng := rand.New(rand.NewSource(9))
+
+	entries := map[string]*IndexEntry{}
+	for i := 0; i < 200; i++ {
+		id := fmt.Sprintf("v%d", i)
+		entries[id] = &IndexEntry{ID: id, Embedding: randomVector(rng, 16), EmbeddingHash: id}
+		g.add(id, id, entries[id].Embedding)
+	}
+
+	g.remove("v0")
+	if got := g.search(entries["v0"].Embedding, 5); got["v0"] != 0 {
+		t.Error("removed node still returned")
+	}
+	delete(entries, "v0")
+
+	restored := newHNSW()
+	restored.restore(g.snapshot(), entries)
+	if restored.len() != 199 {
+		t.Fatalf("restored %d nodes, want 199", restored.len())
+	}
+
+	target := entries["v42"].Embedding
+	if got := restored.search(target, 1); got["v42"] < 0.999 {
+		t.Errorf("expected exact match for v42 after restore, got %v", got)
+	}
+}
+
+func TestStem(t *testing.T) {
+	tests := map[string]string{
+		"prices":      "price",
+		"dropped":     "drop",
+		"dropping":    "drop",
+		"rallying":    "ralli",
+		"rallies":     "ralli",
+		"caresses":    "caress",
+		"relational":  "relat",
+		"generalize":  "gener",
+		"hopeful":     "hope",
+		"bitcoin":     "bitcoin",
+		"كتاب":        "كتاب",
+		"electricity": "electr",
+	}
+	for in, want := range tests {
+		if got := stem(in); got != want {
+			t.Errorf("stem(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestBM25Relevance(t *testing.T) {
+	useTempStore(t)
+	ClearIndex()
+	defer ClearIndex()
+
+	putEntry(&IndexEntry{ID: "drop", Type: "news", Title: "Bitcoin prices dropped sharply", Content: "Crypto markets fell as bitcoin lost ten percent."})
+	putEntry(&IndexEntry{ID: "conf", Type: "news", Title: "Bitcoin conference opens in Miami", Content: "Developers gather to discuss the protocol."})
+	putEntry(&IndexEntry{ID: "oil", Type: "news", Title: "Oil price drops", Content: "Crude fell on weak demand."})
+	putEntry(&IndexEntry{ID: "body", Type: "news", Title: "Weekly market wrap", Content: "Among other things the bitcoin price saw a drop on Tuesday."})
+	putEntry(&IndexEntry{ID: "none", Type: "news", Title: "Football results", Content: "The home side won again."})
+
+	results := search("bitcoin price drop", nil, SearchOptions{Limit: 10}).Results
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Entry.ID)
+	}
+	// every query term in the title first, a single shared term last
+	want := []string{"drop", "oil", "body", "conf"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("ranking = %v, want %v", got, want)
+	}
+}
+
+func TestHybridRankWeights(t *testing.T) {
+	useTempStore(t)
+	ClearIndex()
+	prev := GetRankWeights()
+	defer func() {
+		SetRankWeights(prev)
+		ClearIndex()
+	}()
+
+	// "keyword" matches the query text, "semantic" only matches by vector
+	putEntry(&IndexEntry{ID: "keyword", Title: "Solar panel prices", Embedding: []float64{0, 1}, EmbeddingHash: "k"})
+	putEntry(&IndexEntry{ID: "semantic", Title: "Photovoltaic module costs", Embedding: []float64{1, 0}, EmbeddingHash: "s"})
+	query := []float64{1, 0.1}
+
+	w := DefaultRankWeights
+	results := search("solar prices", query, SearchOptions{Limit: 10}).Results
+	if len(results) != 2 {
+		t.Fatalf("expected both entries with default weights, got %d", len(results))
+	}
+	if results[0].Similarity == 0 && results[1].Similarity == 0 {
+		t.Error("expected a vector match")
+	}
+
+	w.Text, w.Vector = 0, 1
+	SetRankWeights(w)
+	if results = search("solar prices", query, SearchOptions{Limit: 10}).Results; len(results) == 0 || results[0].Entry.ID != "semantic" {
+		t.Errorf("vector-only weights should rank the semantic match first, got %+v", results)
+	}
+
+	w.Text, w.Vector = 1, 0
+	SetRankWeights(w)
+	if results = search("solar prices", query, SearchOptions{Limit: 10}).Results; len(results) != 1 || results[0].Entry.ID != "keyword" {
+		t.Errorf("text-only weights should return only the keyword match, got %+v", results)
+	}
+}
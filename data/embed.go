@@ -0,0 +1,583 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================
+// EMBEDDING PROVIDERS
+// ============================================
+
+// Embedder turns text into vectors for semantic search.
+type Embedder interface {
+	// Name identifies the provider and model, e.g. "ollama:qwen3-embedding:0.6b".
+	// Vectors from embedders with different names are never mixed.
+	Name() string
+	// Embed returns one vector per text, in the same order.
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+const (
+	EmbedderOllama = "ollama"
+	EmbedderOpenAI = "openai"
+	EmbedderHash   = "hash"
+
+	defaultOllamaURL   = "http://localhost:11434"
+	defaultOpenAIURL   = "https://api.openai.com"
+	defaultOpenAIModel = "text-embedding-3-small"
+	defaultHashDims    = 256
+
+	embedBatchSize   = 32
+	embedAttempts    = 3
+	embedBackoff     = 200 * time.Millisecond
+	embedCooldownMin = 30 * time.Second
+	embedCooldownMax = 10 * time.Minute
+)
+
+var (
+	embedderMu sync.RWMutex
+	embedder   = embedderFromEnv()
+
+	embedTimeout = 30 * time.Second
+	embedHealth  providerHealth
+	backfilling  atomic.Bool
+
+	errEmbeddingsUnavailable = errors.New("embedding provider unavailable")
+)
+
+func init() {
+	if v := strings.TrimSpace(os.Getenv("MU_EMBED_TIMEOUT")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			embedTimeout = d
+		} else {
+			fmt.Printf("[data] Ignoring invalid MU_EMBED_TIMEOUT=%q\n", v)
+		}
+	}
+}
+
+// embedderFromEnv picks the provider from MU_EMBEDDER ("ollama", "openai" or
+// "hash"), MU_EMBED_URL, MU_EMBED_MODEL and MU_EMBED_API_KEY.
+func embedderFromEnv() Embedder {
+	url := strings.TrimSpace(os.Getenv("MU_EMBED_URL"))
+	model := strings.TrimSpace(os.Getenv("MU_EMBED_MODEL"))
+
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("MU_EMBEDDER"))) {
+	case EmbedderOpenAI:
+		return NewOpenAIEmbedder(url, model, os.Getenv("MU_EMBED_API_KEY"))
+	case EmbedderHash:
+		return NewHashEmbedder(defaultHashDims)
+	}
+
+	if model == "" {
+		model = strings.TrimSpace(os.Getenv("OLLAMA_EMBED_MODEL"))
+	}
+	return NewOllamaEmbedder(url, model)
+}
+
+// CurrentEmbedder returns the provider used for indexing and search.
+func CurrentEmbedder() Embedder {
+	embedderMu.RLock()
+	defer embedderMu.RUnlock()
+	return embedder
+}
+
+// SetEmbedder replaces the embedding provider and returns the previous one.
+// Entries embedded by another provider are re-embedded in the background.
+func SetEmbedder(e Embedder) Embedder {
+	embedderMu.Lock()
+	prev := embedder
+	embedder = e
+	embedderMu.Unlock()
+
+	embedHealth.reset()
+	go backfillEmbeddings()
+	return prev
+}
+
+// Embed returns vectors for texts using the current provider, serving
+// repeats from the cache and sending the rest in batches.
+func Embed(texts []string) ([][]float64, error) {
+	return embedTexts(texts)
+}
+
+// getEmbedding generates a vector embedding for a single text
+func getEmbedding(text string) ([]float64, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("empty text")
+	}
+	vecs, err := embedTexts([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vecs[0], nil
+}
+
+func embedTexts(texts []string) ([][]float64, error) {
+	if !embeddingsEnabled.Load() {
+		return nil, errEmbeddingsDisabled
+	}
+
+	e := CurrentEmbedder()
+	out := make([][]float64, len(texts))
+
+	// serve what we can from the cache, dedupe the rest
+	var missing []string
+	pending := map[string][]int{}
+	for i, text := range texts {
+		key := cacheKey(e.Name(), text)
+		if cached, ok := embeddingCache.get(key); ok {
+			out[i] = cached
+			continue
+		}
+		if _, ok := pending[key]; !ok {
+			missing = append(missing, strings.TrimSpace(text))
+		}
+		pending[key] = append(pending[key], i)
+	}
+
+	if len(missing) == 0 {
+		return out, nil
+	}
+
+	if !embedHealth.ready(time.Now()) {
+		return nil, errEmbeddingsUnavailable
+	}
+
+	fmt.Printf("[data] Generating %d embedding(s) via %s\n", len(missing), e.Name())
+
+	for start := 0; start < len(missing); start += embedBatchSize {
+		end := start + embedBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		batch := missing[start:end]
+
+		vecs, err := embedWithRetry(e, batch)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, text := range batch {
+			key := cacheKey(e.Name(), text)
+			embeddingCache.put(key, vecs[i])
+			for _, j := range pending[key] {
+				out[j] = vecs[i]
+			}
+		}
+	}
+
+	// the cache is saved with the index
+	schedulePersist()
+
+	return out, nil
+}
+
+// embedWithRetry calls the provider, retrying transient failures with
+// exponential backoff. When every attempt fails the provider is marked
+// down and skipped until its cooldown expires.
+func embedWithRetry(e Embedder, texts []string) ([][]float64, error) {
+	var err error
+	for attempt := 0; attempt < embedAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(embedBackoff << (attempt - 1))
+		}
+
+		var vecs [][]float64
+		ctx, cancel := context.WithTimeout(context.Background(), embedTimeout)
+		vecs, err = e.Embed(ctx, texts)
+		cancel()
+
+		if err == nil && len(vecs) != len(texts) {
+			err = permanent(fmt.Errorf("%s returned %d vectors for %d texts", e.Name(), len(vecs), len(texts)))
+		}
+		if err == nil {
+			if embedHealth.up() {
+				fmt.Printf("[data] Embeddings re-enabled: %s is reachable again\n", e.Name())
+				go backfillEmbeddings()
+			}
+			return vecs, nil
+		}
+
+		var p *permanentError
+		if errors.As(err, &p) {
+			return nil, err
+		}
+	}
+
+	embedHealth.down(time.Now(), err)
+	return nil, err
+}
+
+// cacheKey scopes cached vectors to the embedder that produced them.
+func cacheKey(embedder, text string) string {
+	return embedder + "\x00" + strings.TrimSpace(text)
+}
+
+// legacyEmbedderName is the provider that produced entries and cache keys
+// written before embedders were pluggable.
+func legacyEmbedderName() string {
+	return NewOllamaEmbedder("", "").Name()
+}
+
+// permanentError marks failures that retrying will not fix, such as an
+// unknown model or a rejected API key.
+type permanentError struct{ err error }
+
+func (p *permanentError) Error() string { return p.err.Error() }
+func (p *permanentError) Unwrap() error { return p.err }
+
+func permanent(err error) error { return &permanentError{err} }
+
+// statusError converts a non-2xx response into an error. Client errors other
+// than 429 are permanent.
+func statusError(name string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err := fmt.Errorf("%s error: status %d: %s", name, resp.StatusCode, strings.TrimSpace(string(body)))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanent(err)
+	}
+	return err
+}
+
+// providerHealth tracks whether the embedding provider is reachable. After a
+// failure it waits out a cooldown, doubling on each further failure, before
+// letting a request through to probe the provider again.
+type providerHealth struct {
+	mu       sync.Mutex
+	isDown   bool
+	retryAt  time.Time
+	cooldown time.Duration
+}
+
+func (h *providerHealth) ready(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.isDown || !now.Before(h.retryAt)
+}
+
+func (h *providerHealth) down(now time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.cooldown == 0 {
+		h.cooldown = embedCooldownMin
+	} else if h.isDown {
+		h.cooldown *= 2
+		if h.cooldown > embedCooldownMax {
+			h.cooldown = embedCooldownMax
+		}
+	}
+	h.isDown = true
+	h.retryAt = now.Add(h.cooldown)
+	fmt.Printf("[data] Embeddings unavailable, retrying in %s: %v\n", h.cooldown, err)
+}
+
+// up records a success and reports whether the provider had been down.
+func (h *providerHealth) up() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	was := h.isDown
+	h.isDown = false
+	h.cooldown = 0
+	return was
+}
+
+func (h *providerHealth) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.isDown = false
+	h.cooldown = 0
+}
+
+// backfillEmbeddings embeds entries, and chunks of entries, indexed while
+// the provider was down or by a different provider.
+func backfillEmbeddings() {
+	if !embeddingsEnabled.Load() || !backfilling.CompareAndSwap(false, true) {
+		return
+	}
+	defer backfilling.Store(false)
+
+	name := CurrentEmbedder().Name()
+
+	indexMutex.RLock()
+	var stale []*IndexEntry
+	for _, entry := range index {
+		if !fullyEmbedded(entry, name) {
+			stale = append(stale, entry)
+		}
+	}
+	indexMutex.RUnlock()
+
+	if len(stale) == 0 {
+		return
+	}
+	fmt.Printf("[data] Backfilling embeddings for %d entries\n", len(stale))
+
+	for _, entry := range stale {
+		updated := *entry
+		updated.Chunks = append([]Chunk(nil), entry.Chunks...)
+		if err := embedEntry(&updated, entry, name); err != nil {
+			fmt.Printf("[data] Backfill stopped: %v\n", err)
+			return
+		}
+		setEmbedding(entry, &updated)
+	}
+}
+
+// setEmbedding swaps in a copy of an entry carrying new vectors unless the
+// entry was replaced since the caller read it.
+func setEmbedding(old, updated *IndexEntry) {
+	indexMutex.Lock()
+	if index[old.ID] != old {
+		indexMutex.Unlock()
+		return
+	}
+	index[old.ID] = updated
+	indexMutex.Unlock()
+
+	syncANN(old, updated)
+	schedulePersist()
+}
+
+// entryEmbedder returns the provider that produced an entry's embedding.
+func entryEmbedder(entry *IndexEntry) string {
+	if entry.EmbeddingModel == "" {
+		return legacyEmbedderName()
+	}
+	return entry.EmbeddingModel
+}
+
+// ============================================
+// OLLAMA
+// ============================================
+
+type ollamaEmbedder struct {
+	url    string
+	model  string
+	client *http.Client
+}
+
+// NewOllamaEmbedder embeds with a local Ollama server. Empty arguments use
+// http://localhost:11434 and qwen3-embedding:0.6b.
+func NewOllamaEmbedder(url, model string) Embedder {
+	if url == "" {
+		url = defaultOllamaURL
+	}
+	if model == "" {
+		model = defaultEmbedModel
+	}
+	return &ollamaEmbedder{
+		url:    strings.TrimSuffix(url, "/"),
+		model:  model,
+		client: &http.Client{},
+	}
+}
+
+func (o *ollamaEmbedder) Name() string { return EmbedderOllama + ":" + o.model }
+
+func (o *ollamaEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	var result struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	err := postJSON(ctx, o.client, o.url+"/api/embed", "", map[string]interface{}{
+		"model": o.model,
+		"input": texts,
+	}, &result, "ollama")
+
+	// servers older than 0.3 only have the single prompt endpoint
+	var p *permanentError
+	if errors.As(err, &p) && strings.Contains(err.Error(), "status 404") {
+		return o.embedEach(ctx, texts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result.Embeddings, nil
+}
+
+func (o *ollamaEmbedder) embedEach(ctx context.Context, texts []string) ([][]float64, error) {
+	out := make([][]float64, len(texts))
+	for i, text := range texts {
+		var result struct {
+			Embedding []float64 `json:"embedding"`
+		}
+		err := postJSON(ctx, o.client, o.url+"/api/embeddings", "", map[string]interface{}{
+			"model":  o.model,
+			"prompt": text,
+		}, &result, "ollama")
+		if err != nil {
+			return nil, err
+		}
+		out[i] = result.Embedding
+	}
+	return out, nil
+}
+
+// ============================================
+// OPENAI COMPATIBLE
+// ============================================
+
+type openAIEmbedder struct {
+	url    string
+	model  string
+	apiKey string
+	client *http.Client
+}
+
+// NewOpenAIEmbedder embeds with any server implementing the OpenAI
+// /v1/embeddings API, such as llama.cpp, LM Studio or vLLM. url is the base
+// address with or without the /v1 suffix.
+func NewOpenAIEmbedder(url, model, apiKey string) Embedder {
+	if url == "" {
+		url = defaultOpenAIURL
+	}
+	if model == "" {
+		model = defaultOpenAIModel
+	}
+	url = strings.TrimSuffix(url, "/")
+	if !strings.HasSuffix(url, "/v1") {
+		url += "/v1"
+	}
+	return &openAIEmbedder{
+		url:    url,
+		model:  model,
+		apiKey: strings.TrimSpace(apiKey),
+		client: &http.Client{},
+	}
+}
+
+func (o *openAIEmbedder) Name() string { return EmbedderOpenAI + ":" + o.model }
+
+func (o *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	err := postJSON(ctx, o.client, o.url+"/embeddings", o.apiKey, map[string]interface{}{
+		"model": o.model,
+		"input": texts,
+	}, &result, "openai")
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([][]float64, len(texts))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(out) {
+			return nil, permanent(fmt.Errorf("openai returned index %d for %d texts", d.Index, len(texts)))
+		}
+		out[d.Index] = d.Embedding
+	}
+	return out, nil
+}
+
+func postJSON(ctx context.Context, client *http.Client, url, apiKey string, body, out interface{}, name string) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return permanent(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(b))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return statusError(name, resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s: decoding response: %w", name, err)
+	}
+	return nil
+}
+
+// ============================================
+// HASHING (OFFLINE)
+// ============================================
+
+type hashEmbedder struct {
+	dims int
+}
+
+// NewHashEmbedder returns a deterministic embedder that needs no model. It
+// hashes stemmed terms and their character trigrams into a fixed number of
+// dimensions, so texts sharing words or word fragments land close together.
+// Useful for tests and offline installs.
+func NewHashEmbedder(dims int) Embedder {
+	if dims <= 0 {
+		dims = defaultHashDims
+	}
+	return &hashEmbedder{dims: dims}
+}
+
+func (h *hashEmbedder) Name() string { return fmt.Sprintf("%s:%d", EmbedderHash, h.dims) }
+
+func (h *hashEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	out := make([][]float64, len(texts))
+	for i, text := range texts {
+		out[i] = h.embed(text)
+	}
+	return out, nil
+}
+
+func (h *hashEmbedder) embed(text string) []float64 {
+	vec := make([]float64, h.dims)
+	for _, term := range analyze(text) {
+		h.addFeature(vec, "w:"+term, 1)
+
+		r := []rune(term)
+		for i := 0; i+3 <= len(r); i++ {
+			h.addFeature(vec, "t:"+string(r[i:i+3]), 0.5)
+		}
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += v * v
+	}
+	if norm > 0 {
+		norm = 1 / math.Sqrt(norm)
+		for i := range vec {
+			vec[i] *= norm
+		}
+	}
+	return vec
+}
+
+// addFeature adds a signed weight to the bucket a feature hashes to; the
+// sign bit keeps collisions from always adding up.
+func (h *hashEmbedder) addFeature(vec []float64, feature string, weight float64) {
+	f := fnv.New64a()
+	f.Write([]byte(feature))
+	sum := f.Sum64()
+	if sum>>63 == 1 {
+		weight = -weight
+	}
+	vec[sum%uint64(h.dims)] += weight
+}
@@ -0,0 +1,198 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func useEmbedder(t *testing.T, e Embedder) {
+	t.Helper()
+	useTempStore(t)
+	ClearIndex()
+	prev := SetEmbedder(e)
+	t.Cleanup(func() {
+		SetEmbedder(prev)
+		ClearIndex()
+	})
+}
+
+func TestHashEmbedder(t *testing.T) {
+	e := NewHashEmbedder(64)
+	vecs, err := e.Embed(t.Context(), []string{"Bitcoin price drops", "bitcoin prices dropped", "Football results"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if len(vecs) != 3 || len(vecs[0]) != 64 {
+		t.Fatalf("unexpected shape %d x %d", len(vecs), len(vecs[0]))
+	}
+
+	again, _ := e.Embed(t.Context(), []string{"Bitcoin price drops"})
+	if cosineSimilarity(vecs[0], again[0]) < 0.9999 {
+		t.Error("hash embedder is not deterministic")
+	}
+	if near, far := cosineSimilarity(vecs[0], vecs[1]), cosineSimilarity(vecs[0], vecs[2]); near <= far {
+		t.Errorf("related texts should be closer: near=%.3f far=%.3f", near, far)
+	}
+}
+
+func TestOpenAIEmbedderBatch(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path != "/v1/embeddings" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "bad request", http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		type item struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		}
+		var resp struct {
+			Data []item `json:"data"`
+		}
+		// reply out of order to check results are matched by index
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			resp.Data = append(resp.Data, item{i, []float64{float64(len(req.Input[i])), 1}})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	useEmbedder(t, NewOpenAIEmbedder(srv.URL, "test-model", "secret"))
+
+	vecs, err := Embed([]string{"a", "bbb", "a", "cc"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	for i, want := range []float64{1, 3, 1, 2} {
+		if vecs[i][0] != want {
+			t.Errorf("vector %d = %v, want first component %v", i, vecs[i], want)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected one batched request, got %d", calls.Load())
+	}
+
+	// repeats come from the cache
+	if _, err := Embed([]string{"bbb"}); err != nil || calls.Load() != 1 {
+		t.Errorf("expected cached result, calls=%d err=%v", calls.Load(), err)
+	}
+}
+
+func TestOllamaEmbedderLegacyEndpoint(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embeddings" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]float64{"embedding": {0.5, 0.5}})
+	}))
+	defer srv.Close()
+
+	vecs, err := NewOllamaEmbedder(srv.URL, "m").Embed(t.Context(), []string{"x", "y"})
+	if err != nil || len(vecs) != 2 || vecs[1][0] != 0.5 {
+		t.Fatalf("legacy fallback = %v, %v", vecs, err)
+	}
+}
+
+func TestEmbedderRetryAndRecovery(t *testing.T) {
+	var failing atomic.Bool
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failing.Load() {
+			http.Error(w, "loading model", http.StatusServiceUnavailable)
+			return
+		}
+		var req struct {
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		out := make([][]float64, len(req.Input))
+		for i := range out {
+			out[i] = []float64{1, 0}
+		}
+		json.NewEncoder(w).Encode(map[string][][]float64{"embeddings": out})
+	}))
+	defer srv.Close()
+
+	useEmbedder(t, NewOllamaEmbedder(srv.URL, "flaky"))
+
+	failing.Store(true)
+	Index("down", "news", "Indexed while down", "", nil)
+	if calls.Load() != embedAttempts {
+		t.Errorf("expected %d attempts, got %d", embedAttempts, calls.Load())
+	}
+	if e := GetByID("down"); e == nil || len(e.Embedding) != 0 {
+		t.Fatal("entry should be indexed without an embedding")
+	}
+
+	// while cooling down the provider is not called at all
+	if _, err := getEmbedding("anything"); !errors.Is(err, errEmbeddingsUnavailable) {
+		t.Errorf("expected unavailable during cooldown, got %v", err)
+	}
+	if calls.Load() != embedAttempts {
+		t.Error("provider was called during cooldown")
+	}
+
+	// once the cooldown passes the next request probes and recovers
+	failing.Store(false)
+	embedHealth.mu.Lock()
+	embedHealth.retryAt = time.Now().Add(-time.Second)
+	embedHealth.mu.Unlock()
+
+	if _, err := getEmbedding("probe"); err != nil {
+		t.Fatalf("expected recovery, got %v", err)
+	}
+
+	// entries indexed while down are backfilled
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if e := GetByID("down"); e != nil && len(e.Embedding) == 2 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("entry was not backfilled after the provider recovered")
+}
+
+func TestProviderHealthCooldown(t *testing.T) {
+	var h providerHealth
+	now := time.Unix(0, 0)
+	err := errors.New("boom")
+
+	h.down(now, err)
+	if h.ready(now.Add(embedCooldownMin - time.Second)) {
+		t.Error("should wait out the first cooldown")
+	}
+	if !h.ready(now.Add(embedCooldownMin)) {
+		t.Error("should probe after the cooldown")
+	}
+
+	h.down(now, err)
+	if h.ready(now.Add(embedCooldownMin)) {
+		t.Error("cooldown should double after a failed probe")
+	}
+
+	for i := 0; i < 10; i++ {
+		h.down(now, err)
+	}
+	if !h.ready(now.Add(embedCooldownMax)) {
+		t.Error("cooldown should be capped")
+	}
+
+	if !h.up() || h.up() {
+		t.Error("up should report recovery exactly once")
+	}
+}
@@ -0,0 +1,314 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ============================================
+// SCHEMA VERSIONS & MIGRATIONS
+// ============================================
+
+// The layout of everything under $HOME/.mu/data has a single version, kept
+// in schema.json. Any change to how a struct is stored that old files would
+// not survive comes with a Migration that rewrites them; Migrate runs the
+// pending ones in version order at startup.
+//
+// Migrations see sensitive values decrypted, and what they write is sealed
+// again. They must be idempotent. On the file store a crash can leave some
+// of a migration's writes applied without the version bump, and the
+// migration then runs again over its own output.
+const schemaFile = "schema.json"
+
+// Migration upgrades stored data from Version-1 to Version.
+type Migration struct {
+	Version int
+	Name    string
+	Apply   func(tx Tx) error
+}
+
+// MigrationResult is one migration applied, or that would be in a dry run,
+// with the keys it wrote or deleted.
+type MigrationResult struct {
+	Version int      `json:"version"`
+	Name    string   `json:"name"`
+	Changed []string `json:"changed"`
+}
+
+// MigrationReport describes a Migrate run.
+type MigrationReport struct {
+	From    int               `json:"from"`
+	To      int               `json:"to"`
+	DryRun  bool              `json:"dry_run"`
+	Backup  string            `json:"backup,omitempty"` // pre-migration backup
+	Applied []MigrationResult `json:"applied"`
+}
+
+type schemaState struct {
+	Version int       `json:"version"`
+	Updated time.Time `json:"updated"`
+}
+
+var (
+	migrationsMu sync.Mutex
+	migrations   []Migration
+
+	errDryRun = errors.New("dry run")
+)
+
+// RegisterMigration adds a migration to the registry. Packages register
+// theirs from init; versions are global across packages and must be
+// unique.
+func RegisterMigration(m Migration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+
+	if m.Version < 1 || m.Apply == nil {
+		panic(fmt.Sprintf("data: invalid migration %d %q", m.Version, m.Name))
+	}
+	for _, existing := range migrations {
+		if existing.Version == m.Version {
+			panic(fmt.Sprintf("data: migration %d registered twice (%q and %q)", m.Version, existing.Name, m.Name))
+		}
+	}
+	migrations = append(migrations, m)
+}
+
+// registeredMigrations returns the registry in version order.
+func registeredMigrations() []Migration {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+
+	list := append([]Migration(nil), migrations...)
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+// LatestSchema is the schema version this build writes.
+func LatestSchema() int {
+	list := registeredMigrations()
+	if len(list) == 0 {
+		return 0
+	}
+	return list[len(list)-1].Version
+}
+
+// SchemaVersion returns the version of the stored data. Data written before
+// versioning has no schema file and is version 0.
+func SchemaVersion() (int, error) {
+	var s schemaState
+	if err := LoadJSON(schemaFile, &s); err != nil {
+		if IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("read %s: %w", schemaFile, err)
+	}
+	return s.Version, nil
+}
+
+// Migrate brings the stored data up to LatestSchema. A backup is written to
+// $HOME/.mu/backups before anything changes. With dryRun set the
+// migrations run against staged writes that are then discarded, and the
+// report lists what would change.
+func Migrate(dryRun bool) (*MigrationReport, error) {
+	return migrate(registeredMigrations(), dryRun)
+}
+
+func migrate(list []Migration, dryRun bool) (*MigrationReport, error) {
+	latest := 0
+	if len(list) > 0 {
+		latest = list[len(list)-1].Version
+	}
+
+	cur, err := SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	rep := &MigrationReport{From: cur, To: cur, DryRun: dryRun}
+	if cur > latest {
+		return rep, fmt.Errorf("data is at schema %d but this build only knows up to %d", cur, latest)
+	}
+
+	// a new install starts at the latest layout
+	if cur == 0 {
+		keys, err := CurrentStore().List("")
+		if err != nil {
+			return rep, err
+		}
+		if len(keys) == 0 {
+			rep.To = latest
+			if dryRun || latest == 0 {
+				return rep, nil
+			}
+			return rep, SaveJSON(schemaFile, schemaState{Version: latest, Updated: time.Now().UTC()})
+		}
+	}
+
+	var pending []Migration
+	for _, m := range list {
+		if m.Version > cur {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) == 0 {
+		return rep, nil
+	}
+
+	if !dryRun {
+		path, err := backupBeforeMigrate(cur)
+		if err != nil {
+			return rep, fmt.Errorf("pre-migration backup: %w", err)
+		}
+		rep.Backup = path
+	}
+
+	writeGate.Lock()
+	defer writeGate.Unlock()
+
+	var applied []MigrationResult
+	err = CurrentStore().Tx(func(tx Tx) error {
+		for _, m := range pending {
+			rec := &recordingTx{Tx: sealedTx{tx}}
+			if err := m.Apply(rec); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+			}
+			applied = append(applied, MigrationResult{Version: m.Version, Name: m.Name, Changed: rec.changed})
+		}
+		if dryRun {
+			return errDryRun
+		}
+		b, err := json.Marshal(schemaState{Version: latest, Updated: time.Now().UTC()})
+		if err != nil {
+			return err
+		}
+		// last, so an interrupted run is retried
+		return tx.Put(schemaFile, b)
+	})
+	if err != nil && !(dryRun && errors.Is(err, errDryRun)) {
+		return rep, err
+	}
+
+	rep.Applied = applied
+	rep.To = latest
+	if !dryRun {
+		for _, res := range applied {
+			fmt.Printf("[data] Applied migration %d: %s\n", res.Version, res.Name)
+		}
+	}
+	return rep, nil
+}
+
+// backupBeforeMigrate writes a backup of the data at schema version from
+// and returns its path.
+func backupBeforeMigrate(from int) (string, error) {
+	dir := filepath.Join(os.ExpandEnv("$HOME/.mu"), "backups")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("pre-migrate-v%d-%s.tar.gz", from, time.Now().UTC().Format("20060102-150405.000"))
+	path := filepath.Join(dir, name)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	// migrations run before the index is loaded, so there is nothing in
+	// memory to flush and the stored files are what gets migrated
+	_, err = backup(f, false)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// recordingTx notes the keys a migration writes.
+type recordingTx struct {
+	Tx
+	changed []string
+}
+
+func (t *recordingTx) Put(key string, val []byte) error {
+	t.changed = append(t.changed, key)
+	return t.Tx.Put(key, val)
+}
+
+func (t *recordingTx) Delete(key string) error {
+	t.changed = append(t.changed, key)
+	return t.Tx.Delete(key)
+}
+
+// ============================================
+// MIGRATIONS
+// ============================================
+
+func init() {
+	RegisterMigration(Migration{
+		Version: 1,
+		Name:    "move inline embeddings to " + vectorsFile,
+		Apply:   migrateInlineEmbeddings,
+	})
+}
+
+// migrateInlineEmbeddings moves embeddings saved inside index.json, as
+// indexes did before the vector file, into the vector file.
+func migrateInlineEmbeddings(tx Tx) error {
+	b, err := tx.Get("index.json")
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries map[string]*IndexEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return fmt.Errorf("parse index.json: %w", err)
+	}
+
+	inline := false
+	for _, entry := range entries {
+		if len(entry.Embedding) > 0 {
+			inline = true
+			break
+		}
+		for _, c := range entry.Chunks {
+			if len(c.Embedding) > 0 {
+				inline = true
+				break
+			}
+		}
+	}
+	if !inline {
+		return nil
+	}
+
+	vecs := map[string]annVector{}
+	if vb, err := tx.Get(vectorsFile); err == nil && len(vb) > 0 {
+		if existing, err := decodeVectors(vb); err == nil {
+			vecs = existing
+		}
+	}
+	meta, moved := splitVectors(entries)
+	for id, v := range moved {
+		vecs[id] = v
+	}
+
+	mb, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := tx.Put(vectorsFile, encodeVectors(vecs, vectorFormat)); err != nil {
+		return err
+	}
+	return tx.Put("index.json", mb)
+}
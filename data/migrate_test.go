@@ -0,0 +1,190 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// loadFixture copies a stored layout from testdata into a fresh store.
+func loadFixture(t *testing.T, name string) {
+	t.Helper()
+	useTempStore(t)
+
+	dir := filepath.Join("testdata", name)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		return CurrentStore().Put(filepath.ToSlash(rel), b)
+	})
+	if err != nil {
+		t.Fatalf("load fixture %s: %v", name, err)
+	}
+}
+
+func storeContents(t *testing.T) map[string]string {
+	t.Helper()
+	keys, err := CurrentStore().List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]string{}
+	for _, k := range keys {
+		b, _ := CurrentStore().Get(k)
+		out[k] = string(b)
+	}
+	return out
+}
+
+func TestMigrateV0Fixture(t *testing.T) {
+	loadFixture(t, "schema_v0")
+	before := storeContents(t)
+
+	rep, err := Migrate(true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if rep.From != 0 || rep.To != LatestSchema() || len(rep.Applied) == 0 {
+		t.Fatalf("dry run report = %+v", rep)
+	}
+	if got := rep.Applied[0].Changed; !reflect.DeepEqual(got, []string{vectorsFile, "index.json"}) {
+		t.Errorf("dry run changed %v", got)
+	}
+	if !reflect.DeepEqual(storeContents(t), before) {
+		t.Fatal("dry run modified the store")
+	}
+
+	rep, err = Migrate(false)
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if v, _ := SchemaVersion(); v != LatestSchema() {
+		t.Errorf("schema version = %d, want %d", v, LatestSchema())
+	}
+
+	// the backup holds the data as it was
+	f, err := os.Open(rep.Backup)
+	if err != nil {
+		t.Fatalf("no pre-migration backup: %v", err)
+	}
+	m, err := VerifyBackup(f)
+	f.Close()
+	if err != nil || m.Schema != 0 || len(m.Files) != len(before) {
+		t.Errorf("pre-migration backup = %+v, %v", m, err)
+	}
+
+	b, _ := LoadFile("index.json")
+	if strings.Contains(string(b), `"embedding":`) {
+		t.Error("index.json still has inline embeddings")
+	}
+	vb, _ := LoadFile(vectorsFile)
+	vecs, err := decodeVectors(vb)
+	if err != nil {
+		t.Fatalf("decode vectors: %v", err)
+	}
+	if v := vecs["news_1"]; v.hash != "h-news-1" || len(v.vec) != 4 {
+		t.Errorf("news_1 vector = %+v", v)
+	}
+	if accounts, _ := LoadFile("accounts.json"); string(accounts) != before["accounts.json"] {
+		t.Error("migration touched accounts.json")
+	}
+
+	// nothing pending the second time
+	after := storeContents(t)
+	rep, err = Migrate(false)
+	if err != nil || len(rep.Applied) != 0 || rep.Backup != "" {
+		t.Errorf("second run = %+v, %v", rep, err)
+	}
+	if !reflect.DeepEqual(storeContents(t), after) {
+		t.Error("second run modified the store")
+	}
+}
+
+func TestMigrationsAreIdempotent(t *testing.T) {
+	for _, m := range registeredMigrations() {
+		loadFixture(t, "schema_v0")
+		apply := func() map[string]string {
+			if err := CurrentStore().Tx(m.Apply); err != nil {
+				t.Fatalf("migration %d: %v", m.Version, err)
+			}
+			return storeContents(t)
+		}
+		if first, second := apply(), apply(); !reflect.DeepEqual(first, second) {
+			t.Errorf("migration %d (%s) changed its own output", m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigrateOrderAndVersions(t *testing.T) {
+	loadFixture(t, "schema_v0")
+
+	var order []int
+	step := func(v int) Migration {
+		return Migration{Version: v, Name: "step", Apply: func(tx Tx) error {
+			// each step sees the writes of the one before
+			b, _ := tx.Get("steps.txt")
+			order = append(order, v)
+			return tx.Put("steps.txt", append(b, byte('0'+v)))
+		}}
+	}
+	list := []Migration{step(1), step(2), step(3)}
+
+	SaveJSON(schemaFile, schemaState{Version: 1})
+	if _, err := migrate(list, false); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	if !reflect.DeepEqual(order, []int{2, 3}) {
+		t.Errorf("ran %v, want [2 3]", order)
+	}
+	if b, _ := LoadFile("steps.txt"); string(b) != "23" {
+		t.Errorf("steps.txt = %q", b)
+	}
+
+	SaveJSON(schemaFile, schemaState{Version: 9})
+	if _, err := migrate(list, false); err == nil {
+		t.Error("data from a newer schema was accepted")
+	}
+
+	failing := append(list[:1:1], Migration{Version: 2, Name: "broken", Apply: func(tx Tx) error {
+		tx.Put("half.txt", []byte("x"))
+		return errors.New("boom")
+	}})
+	SaveJSON(schemaFile, schemaState{Version: 1})
+	if _, err := migrate(failing, false); err == nil {
+		t.Error("failed migration reported success")
+	}
+	if v, _ := SchemaVersion(); v != 1 {
+		t.Errorf("schema moved to %d after a failed migration", v)
+	}
+	if _, err := LoadFile("half.txt"); !IsNotFound(err) {
+		t.Error("failed migration's writes were applied")
+	}
+}
+
+func TestMigrateNewInstall(t *testing.T) {
+	useTempStore(t)
+
+	rep, err := Migrate(false)
+	if err != nil || len(rep.Applied) != 0 || rep.Backup != "" {
+		t.Fatalf("new install = %+v, %v", rep, err)
+	}
+	if v, _ := SchemaVersion(); v != LatestSchema() {
+		t.Errorf("new install at schema %d, want %d", v, LatestSchema())
+	}
+
+	var buf bytes.Buffer
+	m, err := Backup(&buf)
+	if err != nil || m.Schema != LatestSchema() {
+		t.Errorf("backup manifest schema = %d, %v", m.Schema, err)
+	}
+}
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================
+// RETENTION & COMPACTION
+// ============================================
+
+// RetentionPolicy limits how long entries of one type stay in the index.
+// Zero fields mean no limit.
+type RetentionPolicy struct {
+	MaxAge     time.Duration // drop entries published or indexed longer ago
+	MaxEntries int           // keep only the newest entries
+}
+
+// DefaultRetention applies unless overridden by SetRetention or MU_RETENTION,
+// e.g. MU_RETENTION="news=30d,video=90d,market=7d". Types without a policy,
+// such as posts, are kept forever. Market entries are one per ticker and
+// updated in place, so the age limit only removes tickers no longer quoted.
+var DefaultRetention = map[string]RetentionPolicy{
+	"news":   {MaxAge: 30 * 24 * time.Hour},
+	"video":  {MaxAge: 90 * 24 * time.Hour},
+	"market": {MaxAge: 7 * 24 * time.Hour},
+}
+
+const (
+	compactDelay    = time.Minute
+	compactInterval = time.Hour
+)
+
+var (
+	retentionMu sync.RWMutex
+	retention   = map[string]RetentionPolicy{}
+
+	compactorOnce sync.Once
+
+	statsMu sync.Mutex
+	stats   = IndexStats{Evicted: map[string]int{}}
+)
+
+// IndexStats reports the size of the index and what compaction removed
+// since startup.
+type IndexStats struct {
+	Entries        map[string]int // current entries by type
+	Evicted        map[string]int // entries removed by retention, by type
+	CachePruned    int            // cached vectors removed with their entries
+	CacheEvicted   uint64         // cached vectors pushed out by the LRU bound
+	CacheSize      int
+	CacheCapacity  int
+	Compactions    int
+	LastCompaction time.Time
+	LastDuration   time.Duration
+}
+
+// CompactionResult describes a single compaction run.
+type CompactionResult struct {
+	Evicted     map[string]int
+	CachePruned int
+	Took        time.Duration
+}
+
+func init() {
+	for t, p := range DefaultRetention {
+		retention[t] = p
+	}
+
+	v := strings.TrimSpace(os.Getenv("MU_RETENTION"))
+	if v == "" {
+		return
+	}
+	for _, part := range strings.Split(v, ",") {
+		name, age, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			fmt.Printf("[data] Ignoring invalid MU_RETENTION entry %q\n", part)
+			continue
+		}
+		d, err := parseRetentionAge(age)
+		if err != nil {
+			fmt.Printf("[data] Ignoring invalid MU_RETENTION entry %q: %v\n", part, err)
+			continue
+		}
+		p := retention[name]
+		p.MaxAge = d
+		retention[name] = p
+	}
+}
+
+// parseRetentionAge accepts Go durations plus a "d" suffix for days.
+func parseRetentionAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("bad day count %q", days)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// SetRetention sets the policy for an entry type. A zero policy keeps
+// entries of that type forever.
+func SetRetention(entryType string, p RetentionPolicy) {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+	if p == (RetentionPolicy{}) {
+		delete(retention, entryType)
+		return
+	}
+	retention[entryType] = p
+}
+
+// GetRetention returns a copy of the policies in effect.
+func GetRetention() map[string]RetentionPolicy {
+	retentionMu.RLock()
+	defer retentionMu.RUnlock()
+	out := make(map[string]RetentionPolicy, len(retention))
+	for t, p := range retention {
+		out[t] = p
+	}
+	return out
+}
+
+// startCompactor runs Compact in the background once a minute after
+// startup and hourly after that.
+func startCompactor() {
+	compactorOnce.Do(func() {
+		go func() {
+			time.Sleep(compactDelay)
+			for {
+				Compact()
+				time.Sleep(compactInterval)
+			}
+		}()
+	})
+}
+
+// Compact removes entries that fall outside their type's retention policy,
+// along with their cached embeddings, and records what it removed.
+func Compact() CompactionResult {
+	start := time.Now()
+	policies := GetRetention()
+
+	res := CompactionResult{Evicted: map[string]int{}}
+
+	indexMutex.Lock()
+	byType := map[string][]*IndexEntry{}
+	for _, entry := range index {
+		if _, ok := policies[entry.Type]; ok {
+			byType[entry.Type] = append(byType[entry.Type], entry)
+		}
+	}
+
+	var removed []*IndexEntry
+	for t, entries := range byType {
+		p := policies[t]
+
+		// newest first so MaxEntries keeps the head
+		sort.Slice(entries, func(i, j int) bool {
+			return EntryTime(entries[i]).After(EntryTime(entries[j]))
+		})
+
+		for i, entry := range entries {
+			expired := p.MaxAge > 0 && start.Sub(EntryTime(entry)) > p.MaxAge
+			overflow := p.MaxEntries > 0 && i >= p.MaxEntries
+			if expired || overflow {
+				delete(index, entry.ID)
+				removed = append(removed, entry)
+				res.Evicted[t]++
+			}
+		}
+	}
+	indexMutex.Unlock()
+
+	for _, entry := range removed {
+		textIndex.remove(entry.ID)
+		syncANN(entry, nil)
+
+		name := entryEmbedder(entry)
+		if embeddingCache.remove(cacheKey(name, embedText(entry.Title, entry.Content))) {
+			res.CachePruned++
+		}
+		for i := range entry.Chunks {
+			if embeddingCache.remove(cacheKey(name, chunkEmbedText(entry.Title, entry.ChunkText(i)))) {
+				res.CachePruned++
+			}
+		}
+
+		// re-indexed while we were pruning
+		if cur := GetByID(entry.ID); cur != nil {
+			textIndex.add(cur.ID, cur.Title, cur.Content)
+			syncANN(nil, cur)
+		}
+	}
+
+	if len(removed) > 0 {
+		schedulePersist()
+	}
+	res.Took = time.Since(start)
+
+	statsMu.Lock()
+	for t, n := range res.Evicted {
+		stats.Evicted[t] += n
+	}
+	stats.CachePruned += res.CachePruned
+	stats.Compactions++
+	stats.LastCompaction = start
+	stats.LastDuration = res.Took
+	statsMu.Unlock()
+
+	if len(removed) > 0 {
+		fmt.Printf("[data] Compacted index in %s: evicted %v, pruned %d cached embeddings\n", res.Took, res.Evicted, res.CachePruned)
+	}
+
+	return res
+}
+
+// Stats returns the current index size and compaction totals.
+func Stats() IndexStats {
+	statsMu.Lock()
+	s := stats
+	s.Evicted = make(map[string]int, len(stats.Evicted))
+	for t, n := range stats.Evicted {
+		s.Evicted[t] = n
+	}
+	statsMu.Unlock()
+
+	s.Entries = map[string]int{}
+	indexMutex.RLock()
+	for _, entry := range index {
+		s.Entries[entry.Type]++
+	}
+	indexMutex.RUnlock()
+
+	s.CacheSize, s.CacheCapacity, s.CacheEvicted = embeddingCache.stats()
+	return s
+}
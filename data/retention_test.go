@@ -0,0 +1,132 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCompactRetention(t *testing.T) {
+	useTempStore(t)
+	ClearIndex()
+	defer ClearIndex()
+
+	prev := GetRetention()
+	defer func() {
+		for typ := range GetRetention() {
+			SetRetention(typ, RetentionPolicy{})
+		}
+		for typ, p := range prev {
+			SetRetention(typ, p)
+		}
+	}()
+	SetRetention("news", RetentionPolicy{MaxAge: 24 * time.Hour})
+	SetRetention("market", RetentionPolicy{MaxEntries: 2})
+	SetRetention("video", RetentionPolicy{})
+
+	now := time.Now()
+	putEntry(&IndexEntry{ID: "fresh", Type: "news", Title: "Fresh story", IndexedAt: now})
+	putEntry(&IndexEntry{ID: "stale", Type: "news", Title: "Stale story", IndexedAt: now.Add(-48 * time.Hour)})
+	putEntry(&IndexEntry{ID: "old-published", Type: "news", Title: "Republished story", IndexedAt: now,
+		Metadata: map[string]interface{}{"published": now.Add(-72 * time.Hour).Format(time.RFC3339)}})
+	for i := 0; i < 4; i++ {
+		putEntry(&IndexEntry{ID: fmt.Sprintf("m%d", i), Type: "market", Title: "Ticker", IndexedAt: now.Add(-time.Duration(i) * time.Hour)})
+	}
+	putEntry(&IndexEntry{ID: "video", Type: "video", Title: "Ancient video", IndexedAt: now.Add(-1000 * time.Hour)})
+	putEntry(&IndexEntry{ID: "post", Type: "post", Title: "Ancient post", IndexedAt: now.Add(-1000 * time.Hour)})
+
+	stale := GetByID("stale")
+	embeddingCache.put(cacheKey(entryEmbedder(stale), embedText(stale.Title, stale.Content)), []float64{1})
+
+	before := Stats()
+	res := Compact()
+
+	if res.Evicted["news"] != 2 || res.Evicted["market"] != 2 || len(res.Evicted) != 2 {
+		t.Errorf("evicted = %v", res.Evicted)
+	}
+	if res.CachePruned != 1 {
+		t.Errorf("expected the stale entry's cached embedding to be pruned, got %d", res.CachePruned)
+	}
+
+	for _, id := range []string{"fresh", "m0", "m1", "video", "post"} {
+		if GetByID(id) == nil {
+			t.Errorf("%s should be kept", id)
+		}
+	}
+	for _, id := range []string{"stale", "old-published", "m2", "m3"} {
+		if GetByID(id) != nil {
+			t.Errorf("%s should be evicted", id)
+		}
+	}
+	if results := Search("stale", 10); len(results) != 0 {
+		t.Error("evicted entry still searchable")
+	}
+
+	after := Stats()
+	if after.Evicted["news"]-before.Evicted["news"] != 2 || after.Compactions != before.Compactions+1 {
+		t.Errorf("stats not updated: before %+v after %+v", before, after)
+	}
+	if after.Entries["market"] != 2 || after.Entries["news"] != 1 {
+		t.Errorf("entries = %v", after.Entries)
+	}
+}
+
+func TestParseRetentionAge(t *testing.T) {
+	tests := map[string]time.Duration{
+		"30d": 30 * 24 * time.Hour,
+		"12h": 12 * time.Hour,
+		"0":   0,
+	}
+	for in, want := range tests {
+		if got, err := parseRetentionAge(in); err != nil || got != want {
+			t.Errorf("parseRetentionAge(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := parseRetentionAge("xd"); err == nil {
+		t.Error("expected error for bad day count")
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2)
+	c.put("a", []float64{1})
+	c.put("b", []float64{2})
+	c.get("a") // b is now least recently used
+	c.put("c", []float64{3})
+
+	if _, ok := c.get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("a should still be cached")
+	}
+	if size, capacity, evicted := c.stats(); size != 2 || capacity != 2 || evicted != 1 {
+		t.Errorf("stats = %d, %d, %d", size, capacity, evicted)
+	}
+
+	// round trip keeps recency order
+	useTempStore(t)
+	if err := c.save("cache.json"); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	b, _ := LoadFile("cache.json")
+	loaded := newLRUCache(2)
+	if err := loaded.load(b); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	loaded.put("d", []float64{4})
+	if _, ok := loaded.get("c"); ok {
+		t.Error("c was least recently used and should be evicted after reload")
+	}
+
+	// the old map format is still read
+	legacy, _ := json.Marshal(map[string][]float64{"hello": {1, 2}})
+	old := newLRUCache(10)
+	if err := old.load(legacy); err != nil {
+		t.Fatalf("legacy load failed: %v", err)
+	}
+	if _, ok := old.get(cacheKey(legacyEmbedderName(), "hello")); !ok {
+		t.Error("legacy key not migrated")
+	}
+}
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ============================================
+// SEARCH OPTIONS & FACETS
+// ============================================
+
+// SearchOptions restricts and pages a search. Filters are applied before
+// ranking, so ranks are computed among matching entries only.
+type SearchOptions struct {
+	Types    []string          // entry types to include, empty for all
+	Metadata map[string]string // metadata values that must match, case-insensitively
+	Since    time.Time         // published or indexed at or after, zero for no bound
+	Until    time.Time         // published or indexed before, zero for no bound
+	MinScore float64           // drop results scoring below this (scores are 0-1)
+	Offset   int               // results to skip, for paging
+	Limit    int               // results to return, zero for all
+	Facets   []string          // fields to count, defaults to DefaultFacets
+}
+
+// DefaultFacets are counted when SearchOptions.Facets is empty. "type" is
+// the entry type; anything else is a metadata key.
+var DefaultFacets = []string{"type", "category", "source", "channel"}
+
+// SearchResponse is one page of results plus counts across every match.
+type SearchResponse struct {
+	Results []SearchResult
+	Total   int                       // matches before Offset and Limit
+	Facets  map[string]map[string]int // field -> value -> matches
+}
+
+// Entries returns the entries of the page in rank order.
+func (r *SearchResponse) Entries() []*IndexEntry {
+	if r == nil || len(r.Results) == 0 {
+		return nil
+	}
+	entries := make([]*IndexEntry, len(r.Results))
+	for i, res := range r.Results {
+		entries[i] = res.Entry
+	}
+	return entries
+}
+
+// SearchWith ranks entries like Search, restricted and paged by opts.
+func SearchWith(query string, opts SearchOptions) *SearchResponse {
+	var queryEmbedding []float64
+	if embeddingsEnabled.Load() {
+		if emb, err := getEmbedding(query); err == nil && len(emb) > 0 {
+			queryEmbedding = emb
+		}
+	}
+
+	return search(query, queryEmbedding, opts)
+}
+
+// filtered reports whether opts restricts which entries can match.
+func (o SearchOptions) filtered() bool {
+	return len(o.Types) > 0 || len(o.Metadata) > 0 || !o.Since.IsZero() || !o.Until.IsZero()
+}
+
+// matches reports whether an entry passes the type, metadata and time filters.
+func (o SearchOptions) matches(entry *IndexEntry) bool {
+	if entry == nil {
+		return false
+	}
+
+	if len(o.Types) > 0 {
+		ok := false
+		for _, t := range o.Types {
+			if entry.Type == t {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	for k, want := range o.Metadata {
+		if !strings.EqualFold(metadataString(entry, k), want) {
+			return false
+		}
+	}
+
+	if !o.Since.IsZero() || !o.Until.IsZero() {
+		t := EntryTime(entry)
+		if !o.Since.IsZero() && t.Before(o.Since) {
+			return false
+		}
+		if !o.Until.IsZero() && !t.Before(o.Until) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// publishedLayouts covers the dates found in feed items, videos and posts.
+var publishedLayouts = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	time.RFC822Z,
+	time.RFC822,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// EntryTime returns when an entry was published, from its "published"
+// metadata when that parses, otherwise when it was indexed.
+func EntryTime(entry *IndexEntry) time.Time {
+	switch v := entry.Metadata["published"].(type) {
+	case time.Time:
+		if !v.IsZero() {
+			return v
+		}
+	case string:
+		v = strings.TrimSpace(v)
+		for _, layout := range publishedLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t
+			}
+		}
+	}
+	return entry.IndexedAt
+}
+
+func metadataString(entry *IndexEntry, key string) string {
+	v, ok := entry.Metadata[key]
+	if !ok || v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// facetCounts counts each field's values across the matched results.
+func facetCounts(results []SearchResult, fields []string) map[string]map[string]int {
+	if len(fields) == 0 {
+		fields = DefaultFacets
+	}
+
+	facets := make(map[string]map[string]int, len(fields))
+	for _, field := range fields {
+		counts := map[string]int{}
+		for _, r := range results {
+			var v string
+			if field == "type" {
+				v = r.Entry.Type
+			} else {
+				v = metadataString(r.Entry, field)
+			}
+			if v != "" {
+				counts[v]++
+			}
+		}
+		facets[field] = counts
+	}
+	return facets
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func loadFilterFixtures(t *testing.T) {
+	t.Helper()
+	useTempStore(t)
+	ClearIndex()
+	t.Cleanup(ClearIndex)
+
+	now := time.Now()
+	putEntry(&IndexEntry{ID: "n1", Type: "news", Title: "Bitcoin rallies", Metadata: map[string]interface{}{"category": "Crypto", "source": "CoinDesk", "published": now.Add(-2 * time.Hour).Format(time.RFC1123Z)}})
+	putEntry(&IndexEntry{ID: "n2", Type: "news", Title: "Bitcoin miners struggle", Metadata: map[string]interface{}{"category": "Crypto", "source": "CoinDesk", "published": now.Add(-72 * time.Hour).Format(time.RFC1123Z)}})
+	putEntry(&IndexEntry{ID: "n3", Type: "news", Title: "Bitcoin ETF hearing", Metadata: map[string]interface{}{"category": "Finance", "source": "CNBC"}, IndexedAt: now})
+	putEntry(&IndexEntry{ID: "v1", Type: "video", Title: "Bitcoin explained", Metadata: map[string]interface{}{"category": "Tech", "channel": "TechCrunch", "published": now.Format(time.RFC3339)}})
+}
+
+func ids(results []SearchResult) map[string]bool {
+	out := map[string]bool{}
+	for _, r := range results {
+		out[r.Entry.ID] = true
+	}
+	return out
+}
+
+func TestSearchFilters(t *testing.T) {
+	loadFilterFixtures(t)
+
+	tests := []struct {
+		name string
+		opts SearchOptions
+		want []string
+	}{
+		{"all", SearchOptions{}, []string{"n1", "n2", "n3", "v1"}},
+		{"type", SearchOptions{Types: []string{"video"}}, []string{"v1"}},
+		{"metadata", SearchOptions{Metadata: map[string]string{"category": "crypto"}}, []string{"n1", "n2"}},
+		{"type and metadata", SearchOptions{Types: []string{"news"}, Metadata: map[string]string{"source": "CNBC"}}, []string{"n3"}},
+		{"since", SearchOptions{Types: []string{"news"}, Since: time.Now().Add(-24 * time.Hour)}, []string{"n1", "n3"}},
+		{"until", SearchOptions{Until: time.Now().Add(-24 * time.Hour)}, []string{"n2"}},
+		{"missing key", SearchOptions{Metadata: map[string]string{"channel": "CoinDesk"}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := search("bitcoin", nil, tt.opts)
+			got := ids(resp.Results)
+			if len(got) != len(tt.want) || resp.Total != len(tt.want) {
+				t.Fatalf("got %v (total %d), want %v", got, resp.Total, tt.want)
+			}
+			for _, id := range tt.want {
+				if !got[id] {
+					t.Errorf("missing %s in %v", id, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchFacetsAndPaging(t *testing.T) {
+	loadFilterFixtures(t)
+
+	resp := search("bitcoin", nil, SearchOptions{Limit: 2})
+	if resp.Total != 4 || len(resp.Results) != 2 {
+		t.Fatalf("expected 2 of 4 results, got %d of %d", len(resp.Results), resp.Total)
+	}
+	if resp.Facets["type"]["news"] != 3 || resp.Facets["type"]["video"] != 1 {
+		t.Errorf("type facet = %v", resp.Facets["type"])
+	}
+	if resp.Facets["category"]["Crypto"] != 2 || resp.Facets["source"]["CoinDesk"] != 2 || resp.Facets["channel"]["TechCrunch"] != 1 {
+		t.Errorf("facets = %v", resp.Facets)
+	}
+
+	next := search("bitcoin", nil, SearchOptions{Limit: 2, Offset: 2})
+	if len(next.Results) != 2 {
+		t.Fatalf("expected second page of 2, got %d", len(next.Results))
+	}
+	seen := ids(resp.Results)
+	for id := range ids(next.Results) {
+		if seen[id] {
+			t.Errorf("%s appears on both pages", id)
+		}
+	}
+
+	if past := search("bitcoin", nil, SearchOptions{Offset: 10}); len(past.Results) != 0 || past.Total != 4 {
+		t.Errorf("offset past the end should be empty, got %d", len(past.Results))
+	}
+
+	custom := search("bitcoin", nil, SearchOptions{Facets: []string{"source"}})
+	if len(custom.Facets) != 1 || custom.Facets["source"]["CNBC"] != 1 {
+		t.Errorf("custom facets = %v", custom.Facets)
+	}
+}
+
+func TestSearchMinScore(t *testing.T) {
+	loadFilterFixtures(t)
+
+	all := search("bitcoin rallies", nil, SearchOptions{})
+	if len(all.Results) < 2 {
+		t.Fatalf("expected several matches, got %d", len(all.Results))
+	}
+	if all.Results[0].Entry.ID != "n1" || all.Results[0].Score > 1 {
+		t.Fatalf("unexpected top result %+v", all.Results[0])
+	}
+
+	strict := search("bitcoin rallies", nil, SearchOptions{MinScore: all.Results[0].Score})
+	if len(strict.Results) != 1 || strict.Results[0].Entry.ID != "n1" {
+		t.Errorf("min score should keep only the top match, got %v", ids(strict.Results))
+	}
+}
+
+func TestEntryTime(t *testing.T) {
+	indexed := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		published interface{}
+		want      time.Time
+	}{
+		{"Mon, 06 Jan 2025 10:00:00 +0000", time.Date(2025, 1, 6, 10, 0, 0, 0, time.UTC)},
+		{"2025-01-07T08:30:00Z", time.Date(2025, 1, 7, 8, 30, 0, 0, time.UTC)},
+		{"yesterday", indexed},
+		{nil, indexed},
+	}
+	for _, tt := range tests {
+		e := &IndexEntry{IndexedAt: indexed, Metadata: map[string]interface{}{"published": tt.published}}
+		if got := EntryTime(e); !got.Equal(tt.want) {
+			t.Errorf("EntryTime(%v) = %v, want %v", tt.published, got, tt.want)
+		}
+	}
+}
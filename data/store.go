@@ -0,0 +1,198 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is the persistence backend behind SaveFile, LoadFile, SaveJSON and
+// LoadJSON. Keys are slash separated relative paths such as "blog.json" or
+// "news/metadata/abc.json".
+type Store interface {
+	// Get returns the value for key. Missing keys return an error that
+	// satisfies errors.Is(err, fs.ErrNotExist).
+	Get(key string) ([]byte, error)
+	// Put durably stores val under key, replacing any previous value.
+	Put(key string, val []byte) error
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(key string) error
+	// List returns all keys with the given prefix in lexical order.
+	List(prefix string) ([]string, error)
+	// Tx runs fn and applies its writes once fn returns nil.
+	Tx(fn func(tx Tx) error) error
+}
+
+// Tx stages writes made inside Store.Tx. Reads see the staged writes.
+type Tx interface {
+	Get(key string) ([]byte, error)
+	Put(key string, val []byte) error
+	Delete(key string) error
+}
+
+const (
+	StoreFile = "file"
+	StoreKV   = "kv"
+
+	kvFileName = "mu.db"
+)
+
+var (
+	storeMu sync.RWMutex
+	store   Store // the file store in $HOME/.mu/data until Open or SetStore
+)
+
+// Open opens the store chosen by MU_STORE ("file", the default, or "kv")
+// in $HOME/.mu/data and makes it current, falling back to files if it
+// cannot be opened. Temp files left by writes that never finished are
+// removed first. main calls it at startup; without it the file store is
+// opened on first use.
+func Open() {
+	removeStaleTemps(dataDir(), time.Now().Add(-staleTempAge))
+
+	kind := strings.ToLower(strings.TrimSpace(os.Getenv("MU_STORE")))
+	s, err := OpenStore(kind)
+	if err != nil {
+		fmt.Printf("[data] Failed to open %s store, using files: %v\n", kind, err)
+		s = NewFileStore("")
+	}
+	SetStore(s)
+}
+
+// dataDir returns the directory holding all persisted state.
+func dataDir() string {
+	return filepath.Join(os.ExpandEnv("$HOME/.mu"), "data")
+}
+
+// OpenStore opens a store by kind ("file" or "kv") rooted in $HOME/.mu/data.
+func OpenStore(kind string) (Store, error) {
+	switch kind {
+	case "", StoreFile:
+		return NewFileStore(""), nil
+	case StoreKV:
+		return OpenKVStore(filepath.Join(dataDir(), kvFileName))
+	}
+	return nil, fmt.Errorf("unknown store %q", kind)
+}
+
+// CurrentStore returns the store used by the package level helpers.
+func CurrentStore() Store {
+	storeMu.RLock()
+	s := store
+	storeMu.RUnlock()
+	if s != nil {
+		return s
+	}
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if store == nil {
+		store = NewFileStore("")
+	}
+	return store
+}
+
+// SetStore replaces the store used by the package level helpers and returns
+// the previous one. Callers own closing the previous store if needed.
+func SetStore(s Store) Store {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	prev := store
+	store = s
+	return prev
+}
+
+// CloseStore closes the current store if it holds open resources.
+func CloseStore() error {
+	if c, ok := CurrentStore().(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// cleanKey normalises a key and confines it to the store root.
+func cleanKey(key string) (string, error) {
+	k := path.Clean("/" + filepath.ToSlash(key))[1:]
+	if k == "" || k == "." {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return k, nil
+}
+
+func notFound(key string) error {
+	return &fs.PathError{Op: "get", Path: key, Err: fs.ErrNotExist}
+}
+
+// IsNotFound reports whether err means a key does not exist.
+func IsNotFound(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// stagedTx buffers writes for stores that apply them on commit.
+type stagedTx struct {
+	get     func(key string) ([]byte, error)
+	puts    map[string][]byte
+	deletes map[string]bool
+	order   []string
+}
+
+func newStagedTx(get func(string) ([]byte, error)) *stagedTx {
+	return &stagedTx{
+		get:     get,
+		puts:    map[string][]byte{},
+		deletes: map[string]bool{},
+	}
+}
+
+func (t *stagedTx) touch(key string) {
+	if _, ok := t.puts[key]; ok {
+		return
+	}
+	if t.deletes[key] {
+		return
+	}
+	t.order = append(t.order, key)
+}
+
+func (t *stagedTx) Get(key string) ([]byte, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := t.puts[k]; ok {
+		return append([]byte(nil), v...), nil
+	}
+	if t.deletes[k] {
+		return nil, notFound(k)
+	}
+	return t.get(k)
+}
+
+func (t *stagedTx) Put(key string, val []byte) error {
+	k, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	t.touch(k)
+	delete(t.deletes, k)
+	t.puts[k] = append([]byte(nil), val...)
+	return nil
+}
+
+func (t *stagedTx) Delete(key string) error {
+	k, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	t.touch(k)
+	delete(t.puts, k)
+	t.deletes[k] = true
+	return nil
+}
@@ -0,0 +1,209 @@
+package data
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tempPrefix = ".tmp-"
+
+// fileStore keeps one file per key under a directory. Every write goes to a
+// temporary file which is fsynced and renamed over the target, so readers
+// and crashes only ever see the old or the new contents.
+type fileStore struct {
+	dir  string
+	txMu sync.Mutex
+}
+
+// staleTempAge is how old a leftover temp file must be before Open
+// removes it, so writes in flight from another process are left alone.
+const staleTempAge = time.Minute
+
+// NewFileStore returns a filesystem store rooted at dir, or at
+// $HOME/.mu/data when dir is empty.
+func NewFileStore(dir string) Store {
+	if dir == "" {
+		dir = dataDir()
+	}
+	return &fileStore{dir: dir}
+}
+
+func (s *fileStore) root() string {
+	return s.dir
+}
+
+// removeStaleTemps deletes temp files under dir last written before cutoff.
+// A crash between creating a temp file and renaming it leaves one behind,
+// and List already skips them.
+func removeStaleTemps(dir string, cutoff time.Time) {
+	n := 0
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.Contains(d.Name(), tempPrefix) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().Before(cutoff) {
+			if os.Remove(p) == nil {
+				n++
+			}
+		}
+		return nil
+	})
+	if n > 0 {
+		fmt.Printf("[data] Removed %d unfinished temp files from %s\n", n, dir)
+	}
+}
+
+func (s *fileStore) path(key string) (string, string, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	return k, filepath.Join(s.root(), filepath.FromSlash(k)), nil
+}
+
+func (s *fileStore) Get(key string) ([]byte, error) {
+	_, file, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(file)
+}
+
+func (s *fileStore) Put(key string, val []byte) error {
+	_, file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, val, 0600)
+}
+
+func (s *fileStore) Delete(key string) error {
+	_, file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) List(prefix string) ([]string, error) {
+	root := s.root()
+	var keys []string
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.Contains(d.Name(), tempPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if key == kvFileName {
+			return nil
+		}
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Tx serialises transactions and applies staged writes one atomic file at a
+// time. A crash part way through can leave some keys updated, but never a
+// partially written file.
+func (s *fileStore) Tx(fn func(tx Tx) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	tx := newStagedTx(s.Get)
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	for _, key := range tx.order {
+		if val, ok := tx.puts[key]; ok {
+			if err := s.Put(key, val); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes b to a temp file beside name, fsyncs it and renames
+// it into place, then fsyncs the directory so the rename is durable.
+func writeFileAtomic(name string, b []byte, perm os.FileMode) error {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(name)+tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	cleanup := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, name); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+// syncDir flushes directory metadata. Not all platforms support it, so
+// failures are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,351 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// kvStore is an embedded key-value store kept in a single append-only file.
+// Every Put, Delete or Tx is written as one checksummed frame and fsynced, so
+// a torn write at the tail is detected and discarded on the next open. The
+// full key set lives in memory; the log is compacted when it grows to twice
+// the size of the live data.
+type kvStore struct {
+	mu   sync.RWMutex
+	path string
+	f    *os.File
+	data map[string][]byte
+	size int64 // bytes in the log file
+	live int64 // bytes the live keys would take if rewritten
+}
+
+const (
+	kvMagic           = "MUKV1\n"
+	kvOpPut      byte = 1
+	kvOpDelete   byte = 2
+	kvFrameHead       = 8 // uint32 length + uint32 crc
+	kvCompactMin      = 4 << 20
+)
+
+// OpenKVStore opens or creates a single file key-value store at path.
+func OpenKVStore(path string) (Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &kvStore{
+		path: path,
+		f:    f,
+		data: map[string][]byte{},
+	}
+
+	if err := s.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// replay rebuilds the in-memory map from the log, truncating any trailing
+// partial or corrupt frame left behind by a crash.
+func (s *kvStore) replay() error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		if _, err := s.f.Write([]byte(kvMagic)); err != nil {
+			return err
+		}
+		s.size = int64(len(kvMagic))
+		return s.f.Sync()
+	}
+
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(s.f)
+
+	magic := make([]byte, len(kvMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != kvMagic {
+		return fmt.Errorf("%s: not a mu kv store", s.path)
+	}
+
+	offset := int64(len(kvMagic))
+	head := make([]byte, kvFrameHead)
+
+	for {
+		if _, err := io.ReadFull(r, head); err != nil {
+			break
+		}
+		n := binary.LittleEndian.Uint32(head[0:4])
+		sum := binary.LittleEndian.Uint32(head[4:8])
+
+		// a length running past the end of the file is a torn header
+		if int64(n) > info.Size()-offset-kvFrameHead {
+			break
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != sum {
+			break
+		}
+		if err := s.apply(payload); err != nil {
+			break
+		}
+		offset += kvFrameHead + int64(n)
+	}
+
+	if offset < info.Size() {
+		fmt.Printf("[data] Discarding %d trailing bytes from %s\n", info.Size()-offset, s.path)
+		if err := s.f.Truncate(offset); err != nil {
+			return err
+		}
+	}
+
+	s.size = offset
+	_, err = s.f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// apply decodes the ops in a frame payload into the in-memory map.
+func (s *kvStore) apply(payload []byte) error {
+	r := bytes.NewReader(payload)
+	for r.Len() > 0 {
+		op, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		key, err := readChunk(r)
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case kvOpPut:
+			val, err := readChunk(r)
+			if err != nil {
+				return err
+			}
+			s.set(string(key), val)
+		case kvOpDelete:
+			s.unset(string(key))
+		default:
+			return fmt.Errorf("unknown op %d", op)
+		}
+	}
+	return nil
+}
+
+func (s *kvStore) set(key string, val []byte) {
+	s.unset(key)
+	s.data[key] = val
+	s.live += int64(len(key) + len(val))
+}
+
+func (s *kvStore) unset(key string) {
+	if old, ok := s.data[key]; ok {
+		s.live -= int64(len(key) + len(old))
+		delete(s.data, key)
+	}
+}
+
+func readChunk(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, errors.New("chunk exceeds frame")
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+func appendChunk(buf []byte, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func encodeFrame(payload []byte) []byte {
+	frame := make([]byte, kvFrameHead, kvFrameHead+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	return append(frame, payload...)
+}
+
+// commit appends one frame to the log and applies it. Caller holds s.mu.
+func (s *kvStore) commit(payload []byte) error {
+	if len(payload) == 0 {
+		return nil
+	}
+	if s.f == nil {
+		return errors.New("kv store closed")
+	}
+
+	frame := encodeFrame(payload)
+	if _, err := s.f.Write(frame); err != nil {
+		// drop whatever made it to disk so the log stays well formed
+		s.f.Truncate(s.size)
+		s.f.Seek(s.size, io.SeekStart)
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	s.size += int64(len(frame))
+
+	if err := s.apply(payload); err != nil {
+		return err
+	}
+
+	if s.size > kvCompactMin && s.size > 2*s.live {
+		if err := s.compact(); err != nil {
+			fmt.Printf("[data] kv compaction failed: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// compact rewrites the live keys into a fresh log and swaps it in.
+func (s *kvStore) compact() error {
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := []byte(kvMagic)
+	for _, k := range keys {
+		var payload []byte
+		payload = append(payload, kvOpPut)
+		payload = appendChunk(payload, []byte(k))
+		payload = appendChunk(payload, s.data[k])
+		buf = append(buf, encodeFrame(payload)...)
+	}
+
+	if err := writeFileAtomic(s.path, buf, 0600); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return err
+	}
+
+	s.f.Close()
+	s.f = f
+	s.size = int64(len(buf))
+	return nil
+}
+
+func (s *kvStore) Get(key string) ([]byte, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getUnlocked(k)
+}
+
+func (s *kvStore) getUnlocked(k string) ([]byte, error) {
+	v, ok := s.data[k]
+	if !ok {
+		return nil, notFound(k)
+	}
+	return append([]byte(nil), v...), nil
+}
+
+func (s *kvStore) Put(key string, val []byte) error {
+	return s.Tx(func(tx Tx) error {
+		return tx.Put(key, val)
+	})
+}
+
+func (s *kvStore) Delete(key string) error {
+	return s.Tx(func(tx Tx) error {
+		return tx.Delete(key)
+	})
+}
+
+func (s *kvStore) List(prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	for k := range s.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Tx writes all staged operations as a single frame, so either every write
+// in the transaction survives a crash or none do.
+func (s *kvStore) Tx(fn func(tx Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := newStagedTx(s.getUnlocked)
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	var payload []byte
+	for _, key := range tx.order {
+		if val, ok := tx.puts[key]; ok {
+			payload = append(payload, kvOpPut)
+			payload = appendChunk(payload, []byte(key))
+			payload = appendChunk(payload, val)
+			continue
+		}
+		if _, ok := s.data[key]; !ok {
+			continue
+		}
+		payload = append(payload, kvOpDelete)
+		payload = appendChunk(payload, []byte(key))
+	}
+
+	return s.commit(payload)
+}
+
+// Close flushes and closes the underlying file.
+func (s *kvStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,199 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useTempStore gives a test its own store in a temp directory. Index saves
+// still pending when the test ends are flushed into it, so none land in the
+// next test's store or the real data directory.
+func useTempStore(t testing.TB) {
+	t.Helper()
+	// settle saves left over from earlier tests before switching stores
+	FlushIndex()
+	prev := SetStore(NewFileStore(t.TempDir()))
+	t.Cleanup(func() {
+		FlushIndex()
+		SetStore(prev)
+	})
+}
+
+func testStoreBasics(t *testing.T, s Store) {
+	t.Helper()
+
+	if _, err := s.Get("missing.json"); !IsNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	if err := s.Put("a.json", []byte(`{"a":1}`)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := s.Put("news/metadata/b.json", []byte("b")); err != nil {
+		t.Fatalf("Put nested failed: %v", err)
+	}
+
+	got, err := s.Get("a.json")
+	if err != nil || string(got) != `{"a":1}` {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+
+	keys, err := s.List("news/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"news/metadata/b.json"}) {
+		t.Fatalf("List = %v", keys)
+	}
+
+	err = s.Tx(func(tx Tx) error {
+		if err := tx.Put("c.json", []byte("c")); err != nil {
+			return err
+		}
+		if v, err := tx.Get("c.json"); err != nil || string(v) != "c" {
+			t.Errorf("tx should read its own writes, got %q, %v", v, err)
+		}
+		return tx.Delete("a.json")
+	})
+	if err != nil {
+		t.Fatalf("Tx failed: %v", err)
+	}
+	if _, err := s.Get("a.json"); !IsNotFound(err) {
+		t.Errorf("a.json should be deleted, got %v", err)
+	}
+
+	rollback := errors.New("rollback")
+	err = s.Tx(func(tx Tx) error {
+		tx.Put("d.json", []byte("d"))
+		return rollback
+	})
+	if err != rollback {
+		t.Fatalf("expected rollback error, got %v", err)
+	}
+	if _, err := s.Get("d.json"); !IsNotFound(err) {
+		t.Errorf("failed tx must not apply writes, got %v", err)
+	}
+
+	if err := s.Put("../escape.json", []byte("x")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := s.Get("escape.json"); err != nil {
+		t.Errorf("keys should be confined to the store root: %v", err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFileStore(dir)
+	testStoreBasics(t, s)
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.json")); err == nil {
+		t.Error("key escaped the store root")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".json" && e.Name() != "news" {
+			t.Errorf("unexpected leftover file %s", e.Name())
+		}
+	}
+}
+
+func TestOpenRemovesStaleTemps(t *testing.T) {
+	useTempStore(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("MU_STORE", "")
+	dir := filepath.Join(home, ".mu", "data")
+	old := time.Now().Add(-time.Hour)
+	write := func(name string, mtime time.Time) {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0700)
+		os.WriteFile(p, []byte("{}"), 0600)
+		os.Chtimes(p, mtime, mtime)
+	}
+	write("index.json", old)
+	write("index.json"+tempPrefix+"123", old)
+	write("news/a.json"+tempPrefix+"456", old)
+	write("blog.json"+tempPrefix+"789", time.Now())
+
+	Open()
+	if got := CurrentStore().(*fileStore).root(); got != dir {
+		t.Errorf("Open used %s, want %s", got, dir)
+	}
+
+	for name, want := range map[string]bool{
+		"index.json":                       true,
+		"index.json" + tempPrefix + "123":  false,
+		"news/a.json" + tempPrefix + "456": false,
+		"blog.json" + tempPrefix + "789":   true, // may still be in flight
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if (err == nil) != want {
+			t.Errorf("%s exists = %v, want %v", name, err == nil, want)
+		}
+	}
+}
+
+func TestKVStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mu.db")
+	s, err := OpenKVStore(path)
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	testStoreBasics(t, s)
+	s.(*kvStore).Close()
+
+	// simulate a crash mid-write by appending a torn frame
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.Write([]byte{0xff, 0x00, 0x00, 0x00, 0x01})
+	f.Close()
+
+	s, err = OpenKVStore(path)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	got, err := s.Get("c.json")
+	if err != nil || string(got) != "c" {
+		t.Fatalf("expected c.json to survive reopen, got %q, %v", got, err)
+	}
+	if err := s.Put("e.json", []byte("e")); err != nil {
+		t.Fatalf("Put after recovery failed: %v", err)
+	}
+	s.(*kvStore).Close()
+
+	// a header claiming a huge frame is treated as the torn tail
+	info, _ := os.Stat(path)
+	f, _ = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.Write([]byte{0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00, 0x01})
+	f.Close()
+
+	s, err = OpenKVStore(path)
+	if err != nil {
+		t.Fatalf("reopen after oversized frame failed: %v", err)
+	}
+	defer s.(*kvStore).Close()
+	if got, err := s.Get("e.json"); err != nil || string(got) != "e" {
+		t.Fatalf("expected e.json to survive reopen, got %q, %v", got, err)
+	}
+	if after, _ := os.Stat(path); after.Size() != info.Size() {
+		t.Fatalf("expected log truncated to %d bytes, got %d", info.Size(), after.Size())
+	}
+}
+
+func TestSaveJSONUsesStore(t *testing.T) {
+	useTempStore(t)
+
+	if err := SaveJSON("x.json", map[string]int{"n": 1}); err != nil {
+		t.Fatalf("SaveJSON failed: %v", err)
+	}
+	var out map[string]int
+	if err := LoadJSON("x.json", &out); err != nil || out["n"] != 1 {
+		t.Fatalf("LoadJSON = %v, %v", out, err)
+	}
+}
@@ -0,0 +1,289 @@
+package data
+
+import (
+	"strings"
+	"unicode"
+)
+
+// ============================================
+// TOKENIZATION & STEMMING
+// ============================================
+
+var stopWords = map[string]bool{
+	"a": true, "about": true, "an": true, "and": true, "are": true, "as": true,
+	"at": true, "be": true, "but": true, "by": true, "can": true, "did": true,
+	"do": true, "does": true, "for": true, "from": true, "has": true, "have": true,
+	"how": true, "i": true, "in": true, "is": true, "it": true, "its": true,
+	"me": true, "my": true, "of": true, "on": true, "or": true, "our": true,
+	"so": true, "that": true, "the": true, "their": true, "there": true,
+	"these": true, "this": true, "to": true, "was": true, "we": true,
+	"were": true, "what": true, "when": true, "where": true, "which": true,
+	"who": true, "why": true, "will": true, "with": true, "you": true,
+	"your": true,
+}
+
+// tokenize lowercases text and splits it into word tokens on anything that
+// is not a letter or digit. Single letters and stop words are dropped.
+func tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	tokens := fields[:0]
+	for _, f := range fields {
+		if stopWords[f] {
+			continue
+		}
+		if len([]rune(f)) < 2 && !unicode.IsDigit([]rune(f)[0]) {
+			continue
+		}
+		tokens = append(tokens, f)
+	}
+	return tokens
+}
+
+// analyze tokenizes and stems text into index terms.
+func analyze(text string) []string {
+	tokens := tokenize(text)
+	for i, t := range tokens {
+		tokens[i] = stem(t)
+	}
+	return tokens
+}
+
+// stem reduces an English word to its Porter stem. Words containing
+// anything other than ASCII letters are returned unchanged.
+func stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+
+	w := []byte(word)
+	w = porterStep1a(w)
+	w = porterStep1b(w)
+	w = porterStep1c(w)
+	w = porterStep2(w)
+	w = porterStep3(w)
+	w = porterStep4(w)
+	w = porterStep5(w)
+	return string(w)
+}
+
+func isConsonant(w []byte, i int) bool {
+	switch w[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		if i == 0 {
+			return true
+		}
+		return !isConsonant(w, i-1)
+	}
+	return true
+}
+
+// measure counts VC sequences in w, the "m" of the Porter paper.
+func measure(w []byte) int {
+	n, i := 0, 0
+	for i < len(w) && isConsonant(w, i) {
+		i++
+	}
+	for i < len(w) {
+		for i < len(w) && !isConsonant(w, i) {
+			i++
+		}
+		if i >= len(w) {
+			break
+		}
+		for i < len(w) && isConsonant(w, i) {
+			i++
+		}
+		n++
+	}
+	return n
+}
+
+func hasVowel(w []byte) bool {
+	for i := range w {
+		if !isConsonant(w, i) {
+			return true
+		}
+	}
+	return false
+}
+
+func endsDoubleConsonant(w []byte) bool {
+	n := len(w)
+	return n >= 2 && w[n-1] == w[n-2] && isConsonant(w, n-1)
+}
+
+// endsCVC reports a consonant-vowel-consonant ending where the last
+// consonant is not w, x or y.
+func endsCVC(w []byte) bool {
+	n := len(w)
+	if n < 3 || !isConsonant(w, n-3) || isConsonant(w, n-2) || !isConsonant(w, n-1) {
+		return false
+	}
+	switch w[n-1] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+func hasSuffix(w []byte, s string) bool {
+	return len(w) >= len(s) && string(w[len(w)-len(s):]) == s
+}
+
+func replaceSuffix(w []byte, s, r string) []byte {
+	return append(w[:len(w)-len(s)], r...)
+}
+
+// replaceIfMeasure swaps suffix s for r when the stem before s has m > min.
+func replaceIfMeasure(w []byte, s, r string, min int) ([]byte, bool) {
+	if !hasSuffix(w, s) {
+		return w, false
+	}
+	if measure(w[:len(w)-len(s)]) > min {
+		return replaceSuffix(w, s, r), true
+	}
+	return w, true
+}
+
+func porterStep1a(w []byte) []byte {
+	switch {
+	case hasSuffix(w, "sses"):
+		return replaceSuffix(w, "sses", "ss")
+	case hasSuffix(w, "ies"):
+		return replaceSuffix(w, "ies", "i")
+	case hasSuffix(w, "ss"):
+		return w
+	case hasSuffix(w, "s"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+func porterStep1b(w []byte) []byte {
+	if hasSuffix(w, "eed") {
+		if measure(w[:len(w)-3]) > 0 {
+			return w[:len(w)-1]
+		}
+		return w
+	}
+
+	var stemmed []byte
+	switch {
+	case hasSuffix(w, "ed") && hasVowel(w[:len(w)-2]):
+		stemmed = w[:len(w)-2]
+	case hasSuffix(w, "ing") && hasVowel(w[:len(w)-3]):
+		stemmed = w[:len(w)-3]
+	default:
+		return w
+	}
+
+	switch {
+	case hasSuffix(stemmed, "at"), hasSuffix(stemmed, "bl"), hasSuffix(stemmed, "iz"):
+		return append(stemmed, 'e')
+	case endsDoubleConsonant(stemmed):
+		switch stemmed[len(stemmed)-1] {
+		case 'l', 's', 'z':
+			return stemmed
+		}
+		return stemmed[:len(stemmed)-1]
+	case measure(stemmed) == 1 && endsCVC(stemmed):
+		return append(stemmed, 'e')
+	}
+	return stemmed
+}
+
+func porterStep1c(w []byte) []byte {
+	if hasSuffix(w, "y") && hasVowel(w[:len(w)-1]) {
+		w[len(w)-1] = 'i'
+	}
+	return w
+}
+
+var porterStep2Suffixes = [][2]string{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"},
+	{"izer", "ize"}, {"abli", "able"}, {"alli", "al"}, {"entli", "ent"},
+	{"eli", "e"}, {"ousli", "ous"}, {"ization", "ize"}, {"ation", "ate"},
+	{"ator", "ate"}, {"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"},
+	{"ousness", "ous"}, {"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"},
+}
+
+func porterStep2(w []byte) []byte {
+	for _, p := range porterStep2Suffixes {
+		if out, matched := replaceIfMeasure(w, p[0], p[1], 0); matched {
+			return out
+		}
+	}
+	return w
+}
+
+var porterStep3Suffixes = [][2]string{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"},
+	{"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+func porterStep3(w []byte) []byte {
+	for _, p := range porterStep3Suffixes {
+		if out, matched := replaceIfMeasure(w, p[0], p[1], 0); matched {
+			return out
+		}
+	}
+	return w
+}
+
+var porterStep4Suffixes = []string{
+	"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement", "ment",
+	"ent", "ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+}
+
+func porterStep4(w []byte) []byte {
+	// longest matching suffix wins
+	best := ""
+	for _, s := range porterStep4Suffixes {
+		if hasSuffix(w, s) && len(s) > len(best) {
+			best = s
+		}
+	}
+	if best == "" {
+		return w
+	}
+
+	stemmed := w[:len(w)-len(best)]
+	if measure(stemmed) <= 1 {
+		return w
+	}
+	if best == "ion" {
+		if n := len(stemmed); n == 0 || (stemmed[n-1] != 's' && stemmed[n-1] != 't') {
+			return w
+		}
+	}
+	return stemmed
+}
+
+func porterStep5(w []byte) []byte {
+	if hasSuffix(w, "e") {
+		stemmed := w[:len(w)-1]
+		m := measure(stemmed)
+		if m > 1 || (m == 1 && !endsCVC(stemmed)) {
+			w = stemmed
+		}
+	}
+	if measure(w) > 1 && endsDoubleConsonant(w) && hasSuffix(w, "l") {
+		w = w[:len(w)-1]
+	}
+	return w
+}
+
+// Terms returns the stemmed index terms for text, as used to match queries.
+func Terms(text string) []string {
+	return analyze(text)
+}
@@ -0,0 +1,255 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ============================================
+// EMBEDDING STORAGE
+// ============================================
+
+// Embeddings are kept out of index.json and saved to a binary sidecar, one
+// record per entry or chunk keyed by its ANN node ID. Vectors are held as
+// float64 in memory and written as float32, or as int8 with a per-vector
+// scale when MU_VECTOR_FORMAT=int8.
+//
+// File layout, little endian:
+//
+//	magic "MUVEC" | version uint8 | count uint32
+//	per record: id len uint16 | id | hash len uint16 | hash |
+//	            format uint8 | dims uint32 | [scale float32] | values
+const (
+	vectorsFile    = "index_vectors.bin"
+	vectorsMagic   = "MUVEC"
+	vectorsVersion = 1
+)
+
+// Vector encodings understood by the sidecar.
+const (
+	VectorFloat32 = "float32"
+	VectorInt8    = "int8"
+)
+
+const (
+	vecFormatFloat32 uint8 = iota
+	vecFormatInt8
+)
+
+var errBadVectors = errors.New("malformed vector file")
+
+// vectorFormat is the encoding used when saving.
+var vectorFormat = vectorFormatFromEnv()
+
+func vectorFormatFromEnv() string {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("MU_VECTOR_FORMAT")))
+	switch v {
+	case "", VectorFloat32:
+		return VectorFloat32
+	case VectorInt8:
+		return VectorInt8
+	}
+	fmt.Printf("[data] Ignoring invalid MU_VECTOR_FORMAT=%q\n", v)
+	return VectorFloat32
+}
+
+// encodeVectors serialises vectors keyed by node ID in the given format.
+func encodeVectors(vecs map[string]annVector, format string) []byte {
+	ids := make([]string, 0, len(vecs))
+	for id := range vecs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var buf bytes.Buffer
+	buf.WriteString(vectorsMagic)
+	buf.WriteByte(vectorsVersion)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(ids)))
+
+	for _, id := range ids {
+		v := vecs[id]
+		writeString(&buf, id)
+		writeString(&buf, v.hash)
+
+		if format == VectorInt8 {
+			buf.WriteByte(vecFormatInt8)
+			binary.Write(&buf, binary.LittleEndian, uint32(len(v.vec)))
+			scale, q := quantize(v.vec)
+			binary.Write(&buf, binary.LittleEndian, scale)
+			binary.Write(&buf, binary.LittleEndian, q)
+			continue
+		}
+
+		buf.WriteByte(vecFormatFloat32)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(v.vec)))
+		f := make([]float32, len(v.vec))
+		for i, x := range v.vec {
+			f[i] = float32(x)
+		}
+		binary.Write(&buf, binary.LittleEndian, f)
+	}
+	return buf.Bytes()
+}
+
+// decodeVectors reads a file written by encodeVectors.
+func decodeVectors(b []byte) (map[string]annVector, error) {
+	r := bytes.NewReader(b)
+
+	head := make([]byte, len(vectorsMagic)+1)
+	if _, err := r.Read(head); err != nil || string(head[:len(vectorsMagic)]) != vectorsMagic {
+		return nil, errBadVectors
+	}
+	if head[len(vectorsMagic)] != vectorsVersion {
+		return nil, fmt.Errorf("unsupported vector file version %d", head[len(vectorsMagic)])
+	}
+
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, errBadVectors
+	}
+
+	vecs := make(map[string]annVector, count)
+	for n := uint32(0); n < count; n++ {
+		id, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		format, err := r.ReadByte()
+		if err != nil {
+			return nil, errBadVectors
+		}
+		var dims uint32
+		if err := binary.Read(r, binary.LittleEndian, &dims); err != nil {
+			return nil, errBadVectors
+		}
+		// each value takes at least a byte
+		if int64(dims) > int64(r.Len()) {
+			return nil, errBadVectors
+		}
+
+		vec := make([]float64, dims)
+		switch format {
+		case vecFormatFloat32:
+			f := make([]float32, dims)
+			if err := binary.Read(r, binary.LittleEndian, f); err != nil {
+				return nil, errBadVectors
+			}
+			for i, x := range f {
+				vec[i] = float64(x)
+			}
+		case vecFormatInt8:
+			var scale float32
+			q := make([]int8, dims)
+			if err := binary.Read(r, binary.LittleEndian, &scale); err != nil {
+				return nil, errBadVectors
+			}
+			if err := binary.Read(r, binary.LittleEndian, q); err != nil {
+				return nil, errBadVectors
+			}
+			for i, x := range q {
+				vec[i] = float64(x) * float64(scale)
+			}
+		default:
+			return nil, fmt.Errorf("unknown vector format %d", format)
+		}
+
+		vecs[id] = annVector{hash: hash, vec: vec}
+	}
+	return vecs, nil
+}
+
+// quantize maps a vector onto int8 with a single scale so that the largest
+// component becomes ±127.
+func quantize(vec []float64) (float32, []int8) {
+	var max float64
+	for _, x := range vec {
+		if a := math.Abs(x); a > max {
+			max = a
+		}
+	}
+	q := make([]int8, len(vec))
+	if max == 0 {
+		return 0, q
+	}
+	scale := max / 127
+	for i, x := range vec {
+		q[i] = int8(math.Round(x / scale))
+	}
+	return float32(scale), q
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.LittleEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", errBadVectors
+	}
+	if int(n) > r.Len() {
+		return "", errBadVectors
+	}
+	b := make([]byte, n)
+	r.Read(b)
+	return string(b), nil
+}
+
+// splitVectors returns copies of the entries without embeddings, for
+// index.json, and the embeddings keyed by node ID for the sidecar.
+func splitVectors(entries map[string]*IndexEntry) (map[string]*IndexEntry, map[string]annVector) {
+	meta := make(map[string]*IndexEntry, len(entries))
+	vecs := map[string]annVector{}
+	for id, entry := range entries {
+		annVectors(entry, vecs)
+
+		slim := *entry
+		slim.Embedding = nil
+		if len(entry.Chunks) > 0 {
+			slim.Chunks = make([]Chunk, len(entry.Chunks))
+			for i, c := range entry.Chunks {
+				c.Embedding = nil
+				slim.Chunks[i] = c
+			}
+		}
+		meta[id] = &slim
+	}
+	return meta, vecs
+}
+
+// attachVectors puts loaded embeddings back on their entries and chunks
+// where the hash still matches, and returns how many were attached. The
+// caller must hold indexMutex.
+func attachVectors(vecs map[string]annVector) int {
+	n := 0
+	for id, v := range vecs {
+		entry, c := resolveNode(id)
+		if entry == nil {
+			continue
+		}
+		if c < 0 {
+			if entry.EmbeddingHash == v.hash && len(entry.Embedding) == 0 {
+				entry.Embedding = v.vec
+				n++
+			}
+			continue
+		}
+		if entry.Chunks[c].EmbeddingHash == v.hash && len(entry.Chunks[c].Embedding) == 0 {
+			entry.Chunks[c].Embedding = v.vec
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestVectorEncoding(t *testing.T) {
+	vecs := map[string]annVector{
+		"a":   {hash: "h1", vec: []float64{0.1, -0.5, 0.25, 0.9}},
+		"a#0": {hash: "h2", vec: []float64{-1, 0, 0.5, 0.003}},
+		"z":   {hash: "", vec: []float64{0, 0, 0, 0}},
+	}
+
+	for _, format := range []string{VectorFloat32, VectorInt8} {
+		t.Run(format, func(t *testing.T) {
+			b := encodeVectors(vecs, format)
+			got, err := decodeVectors(b)
+			if err != nil {
+				t.Fatalf("decode failed: %v", err)
+			}
+			if len(got) != len(vecs) {
+				t.Fatalf("got %d vectors, want %d", len(got), len(vecs))
+			}
+			for id, want := range vecs {
+				v := got[id]
+				if v.hash != want.hash || len(v.vec) != len(want.vec) {
+					t.Fatalf("%s: got hash %q dims %d", id, v.hash, len(v.vec))
+				}
+				for i := range want.vec {
+					if math.Abs(v.vec[i]-want.vec[i]) > 0.01 {
+						t.Errorf("%s[%d] = %f, want %f", id, i, v.vec[i], want.vec[i])
+					}
+				}
+			}
+
+			if _, err := decodeVectors(b[:len(b)-3]); err == nil {
+				t.Error("truncated file decoded without error")
+			}
+		})
+	}
+
+	if int8Size, f32Size := len(encodeVectors(vecs, VectorInt8)), len(encodeVectors(vecs, VectorFloat32)); int8Size >= f32Size {
+		t.Errorf("int8 file (%d bytes) not smaller than float32 (%d bytes)", int8Size, f32Size)
+	}
+}
+
+func TestLoadMigratesInlineEmbeddings(t *testing.T) {
+	e := NewHashEmbedder(8)
+	useEmbedder(t, e)
+
+	// an index.json written before embeddings moved to the vector file
+	legacy := map[string]*IndexEntry{
+		"n1": {
+			ID:             "n1",
+			Type:           "news",
+			Title:          "Rain expected",
+			Content:        "Heavy rain is expected across the region this weekend.",
+			Embedding:      []float64{0.5, 0.5, 0, 0, 0, 0, 0.5, 0.5},
+			EmbeddingHash:  "h-n1",
+			EmbeddingModel: e.Name(),
+		},
+	}
+	if err := SaveJSON("index.json", legacy); err != nil {
+		t.Fatal(err)
+	}
+	CurrentStore().Delete(vectorsFile)
+
+	Load()
+	if got := GetByID("n1"); got == nil || len(got.Embedding) != 8 {
+		t.Fatalf("inline embedding not loaded: %+v", got)
+	}
+
+	FlushIndex()
+	b, err := LoadFile("index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte(`"embedding":`)) {
+		t.Error("index.json still holds embeddings after migration")
+	}
+	if vb, err := LoadFile(vectorsFile); err != nil || len(vb) == 0 {
+		t.Fatalf("vector file not written: %v", err)
+	}
+
+	// a fresh start reads the embedding back from the vector file
+	indexMutex.Lock()
+	index = map[string]*IndexEntry{}
+	indexMutex.Unlock()
+
+	Load()
+	got := GetByID("n1")
+	if got == nil || len(got.Embedding) != 8 {
+		t.Fatalf("embedding not restored from vector file: %+v", got)
+	}
+	if sim := cosineSimilarity(got.Embedding, legacy["n1"].Embedding); sim < 0.9999 {
+		t.Errorf("restored embedding differs: similarity %f", sim)
+	}
+}
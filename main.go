@@ -17,6 +17,7 @@ import (
 	"mu/admin"
 	"mu/api"
 	"mu/app"
+	"mu/audit"
 	"mu/auth"
 	"mu/blog"
 	"mu/chat"
@@ -24,6 +25,7 @@ import (
 	"mu/data"
 	"mu/home"
 	"mu/news"
+	"mu/search"
 	"mu/user"
 	"mu/video"
 )
@@ -58,10 +60,22 @@ func normalizeAddress(addr string) string {
 func main() {
 	flag.Parse()
 
+	data.Open()
+
+	// the master key must be in place before anything encrypted is read
+	if err := data.LoadMasterKey(); err != nil {
+		fmt.Printf("Master key error: %v\n", err)
+		os.Exit(1)
+	}
+
 	if strings.TrimSpace(*ChatPromptFlag) != "" {
 		os.Exit(runChatCLI())
 	}
 
+	if args := flag.Args(); len(args) > 0 {
+		os.Exit(runCommand(args))
+	}
+
 	if !*ServeFlag {
 		fmt.Println("--serve not set")
 		os.Exit(1)
@@ -72,9 +86,18 @@ func main() {
 	apiDoc := app.Render([]byte(md))
 	apiHTML := app.RenderHTML("API", "API documentation", string(apiDoc))
 
+	// upgrade stored data to the current schema
+	if _, err := data.Migrate(false); err != nil {
+		fmt.Printf("Migration failed: %v\n", err)
+		os.Exit(1)
+	}
+
 	// load the data index
 	data.Load()
 
+	// load accounts and sessions
+	auth.Load()
+
 	// load mutable settings
 	config.Load()
 
@@ -93,6 +116,9 @@ func main() {
 	// load the blog
 	blog.Load()
 
+	// load the user profiles
+	user.Load()
+
 	// load the home cards
 	home.Load()
 
@@ -118,85 +144,7 @@ func main() {
 		close(startupReady)
 	}()
 
-	// serve video
-	http.HandleFunc("/video", video.Handler)
-
-	// serve news
-	http.HandleFunc("/news", news.Handler)
-
-	// serve chat
-	http.HandleFunc("/chat", chat.Handler)
-
-	// serve blog (full list)
-	http.HandleFunc("/posts", blog.Handler)
-
-	// serve individual blog post (public, no auth)
-	http.HandleFunc("/post", blog.PostHandler)
-
-	// edit blog post
-	http.HandleFunc("/post/edit", blog.EditHandler)
-
-	// flag content
-	http.HandleFunc("/flag", admin.FlagHandler)
-
-	// moderation queue
-	http.HandleFunc("/moderate", admin.ModerateHandler)
-
-	// admin user management
-	http.HandleFunc("/admin", admin.AdminHandler)
-
-	// membership page (public - handles GoCardless redirects)
-	http.HandleFunc("/membership", app.Membership)
-
-	// donate page (public - handles GoCardless redirects)
-	http.HandleFunc("/donate", app.Donate)
-
-	// serve the home screen
-	http.HandleFunc("/home", home.Handler)
-
-	http.HandleFunc("/mail", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, "/home", 302)
-	})
-
-	http.HandleFunc("/markets", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, "https://coinmarketcap.com/", 302)
-	})
-
-	// auth
-	http.HandleFunc("/login", app.Login)
-	http.HandleFunc("/logout", app.Logout)
-	http.HandleFunc("/signup", app.Signup)
-	http.HandleFunc("/account", app.Account)
-	http.HandleFunc("/session", app.Session)
-	http.HandleFunc("/settings", app.Settings)
-
-	// presence ping endpoint
-	http.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
-		username := "guest"
-		if sess, err := auth.GetSession(r); err == nil {
-			if acc, err := auth.GetAccount(sess.Account); err == nil {
-				username = acc.ID
-			}
-		}
-		auth.UpdatePresence(username)
-
-		w.Header().Set("Content-Type", "application/json")
-		onlineCount := auth.GetOnlineCount()
-		w.Write([]byte(fmt.Sprintf(`{"status":"ok","online":%d}`, onlineCount)))
-	})
-
-	// serve the api doc
-	http.Handle("/api", app.ServeHTML(apiHTML))
-
-	// serve the app, redirecting "/" to /home
-	appHandler := app.Serve()
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/" {
-			http.Redirect(w, r, "/home", http.StatusFound)
-			return
-		}
-		appHandler.ServeHTTP(w, r)
-	})
+	registerRoutes(http.DefaultServeMux, apiHTML)
 
 	addr := normalizeAddress(*AddressFlag)
 	fmt.Println("Starting server on", addr)
@@ -208,8 +156,7 @@ func main() {
 		if *EnvFlag == "dev" {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+api.TokenHeader)
 
 			if r.Method == "OPTIONS" {
 				w.WriteHeader(http.StatusOK)
@@ -236,7 +183,7 @@ func main() {
 
 	server := &http.Server{
 		Addr:    addr,
-		Handler: handler,
+		Handler: auth.TokenMiddleware(handler),
 	}
 
 	go func() {
@@ -264,6 +211,111 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		fmt.Printf("Server forced to shutdown: %v\n", err)
 	}
+
+	// persist the index and release the store
+	data.FlushIndex()
+	if err := data.CloseStore(); err != nil {
+		fmt.Printf("Failed to close store: %v\n", err)
+	}
+}
+
+// registerRoutes adds the app's handlers to mux.
+func registerRoutes(mux *http.ServeMux, apiHTML string) {
+	// Routes that change state are wrapped in auth.Require or
+	// auth.RequireWrite with the permission they need; see auth/role.go for
+	// what each role grants. Those open to abuse are also rate limited with
+	// auth.Throttle, and those taking forms from signed in users check a
+	// CSRF token with auth.CSRF.
+
+	// serve video
+	mux.HandleFunc("/video", video.Handler)
+
+	// serve news
+	mux.HandleFunc("/news", news.Handler)
+
+	// serve chat
+	mux.HandleFunc("/chat", auth.CSRF(auth.RequireWrite(auth.PermChat)(auth.Throttle(auth.ChatLimiter)(chat.Handler))))
+
+	// serve search across the local index
+	mux.HandleFunc("/search", search.Handler)
+
+	// serve blog (full list)
+	mux.HandleFunc("/posts", auth.CSRF(auth.RequireWrite(auth.PermPost)(blog.Handler)))
+
+	// serve individual blog post (public, no auth)
+	mux.HandleFunc("/post", auth.CSRF(auth.RequireWrite(auth.PermPost)(blog.PostHandler)))
+
+	// edit blog post
+	mux.HandleFunc("/post/edit", auth.CSRF(auth.RequireWrite(auth.PermPost)(blog.EditHandler)))
+
+	// flag content
+	mux.HandleFunc("/flag", auth.CSRF(auth.Require(auth.PermFlag)(auth.Throttle(auth.FlagLimiter)(admin.FlagHandler))))
+
+	// moderation queue
+	mux.HandleFunc("/moderate", auth.CSRF(auth.Require(auth.PermModerate)(admin.ModerateHandler)))
+
+	// admin user management
+	mux.HandleFunc("/admin", auth.CSRF(auth.Require(auth.PermUsers)(admin.AdminHandler)))
+
+	// admin audit log and export
+	mux.HandleFunc("/admin/audit", auth.Require(auth.PermUsers)(admin.AuditHandler))
+
+	// admin backup download
+	mux.HandleFunc("/admin/backup", auth.CSRF(auth.Require(auth.PermBackup)(admin.BackupHandler)))
+
+	// membership page (public - handles GoCardless redirects)
+	mux.HandleFunc("/membership", app.Membership)
+
+	// donate page (public - handles GoCardless redirects)
+	mux.HandleFunc("/donate", app.Donate)
+
+	// serve the home screen
+	mux.HandleFunc("/home", home.Handler)
+
+	mux.HandleFunc("/mail", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/home", 302)
+	})
+
+	mux.HandleFunc("/markets", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://coinmarketcap.com/", 302)
+	})
+
+	// auth
+	mux.HandleFunc("/login", app.Login)
+	mux.HandleFunc("/logout", app.Logout)
+	mux.HandleFunc("/signup", app.Signup)
+	mux.HandleFunc("/reset", app.Reset)
+	mux.HandleFunc("/account", auth.CSRF(app.Account))
+	mux.HandleFunc("/session", app.Session)
+	mux.HandleFunc("/settings", auth.CSRF(auth.Require(auth.PermSettings)(app.Settings)))
+
+	// presence ping endpoint
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		username := "guest"
+		if sess, err := auth.GetSession(r); err == nil {
+			if acc, err := auth.GetAccount(sess.Account); err == nil {
+				username = acc.ID
+			}
+		}
+		auth.UpdatePresence(username)
+
+		w.Header().Set("Content-Type", "application/json")
+		onlineCount := auth.GetOnlineCount()
+		w.Write([]byte(fmt.Sprintf(`{"status":"ok","online":%d}`, onlineCount)))
+	})
+
+	// serve the api doc
+	mux.Handle("/api", app.ServeHTML(apiHTML))
+
+	// serve the app, redirecting "/" to /home
+	appHandler := app.Serve()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/home", http.StatusFound)
+			return
+		}
+		appHandler.ServeHTTP(w, r)
+	})
 }
 
 func runChatCLI() int {
@@ -318,6 +370,155 @@ func runChatCLI() int {
 	return 0
 }
 
+// runCommand runs a maintenance command such as "backup <file>",
+// "restore <file>" or "migrate [--dry-run]" against the data store.
+func runCommand(args []string) int {
+	usage := "usage: mu backup <file> | mu restore <file> | mu migrate [--dry-run] | mu rekey | mu role <account> <role>"
+	defer data.CloseStore()
+
+	if args[0] == "rekey" && len(args) == 1 {
+		return runRekey()
+	}
+
+	if args[0] == "role" && len(args) == 3 {
+		return runRole(args[1], auth.Role(args[2]))
+	}
+
+	if args[0] == "migrate" {
+		dryRun := len(args) == 2 && args[1] == "--dry-run"
+		if len(args) > 2 || (len(args) == 2 && !dryRun) {
+			fmt.Fprintln(os.Stderr, usage)
+			return 1
+		}
+		return runMigrate(dryRun)
+	}
+
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 1
+	}
+
+	switch args[0] {
+	case "backup":
+		f, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create backup: %v\n", err)
+			return 1
+		}
+		m, err := data.Backup(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(args[1])
+			fmt.Fprintf(os.Stderr, "Backup failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Backed up %d files to %s\n", len(m.Files), args[1])
+		audit.Record(audit.Event{Actor: "cli", Action: "data.backup", Target: args[1], Detail: fmt.Sprintf("%d files", len(m.Files))})
+		return 0
+
+	case "restore":
+		f, err := os.Open(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open backup: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+
+		fmt.Println("Restoring into $HOME/.mu/data; make sure the server is stopped.")
+		m, err := data.Restore(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Restore failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Restored %d files from a %s backup taken %s\n", len(m.Files), m.Version, m.Created.Format(time.RFC3339))
+		audit.Record(audit.Event{
+			Actor:  "cli",
+			Action: "data.restore",
+			Target: args[1],
+			Detail: fmt.Sprintf("%d files from a %s backup taken %s", len(m.Files), m.Version, m.Created.Format(time.RFC3339)),
+		})
+		return 0
+	}
+
+	fmt.Fprintln(os.Stderr, usage)
+	return 1
+}
+
+// runMigrate upgrades the data store to the current schema, or with dryRun
+// lists the migrations that would run and the keys they would change.
+func runMigrate(dryRun bool) int {
+	rep, err := data.Migrate(dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+		return 1
+	}
+
+	if rep.Backup != "" {
+		fmt.Printf("Backed up data to %s\n", rep.Backup)
+	}
+	for _, res := range rep.Applied {
+		changed := strings.Join(res.Changed, ", ")
+		if changed == "" {
+			changed = "no changes"
+		}
+		fmt.Printf("%d %s: %s\n", res.Version, res.Name, changed)
+	}
+	switch {
+	case rep.From == rep.To:
+		fmt.Printf("Schema is up to date at version %d\n", rep.To)
+	case dryRun:
+		fmt.Printf("Would migrate schema %d to %d\n", rep.From, rep.To)
+	default:
+		fmt.Printf("Migrated schema %d to %d\n", rep.From, rep.To)
+	}
+	return 0
+}
+
+// runRekey reseals sensitive files with the current master key, or writes
+// them in the clear when no key is set.
+func runRekey() int {
+	n, err := data.Rekey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Rekey failed: %v\n", err)
+		return 1
+	}
+	if data.EncryptionEnabled() {
+		fmt.Printf("Encrypted %d files with the current master key\n", n)
+	} else {
+		fmt.Printf("Decrypted %d files; no master key is set\n", n)
+	}
+	return 0
+}
+
+// runRole assigns a role from the command line, e.g. to make the first
+// admin. Stop the server first; it keeps accounts in memory.
+func runRole(account string, role auth.Role) int {
+	if v, err := data.SchemaVersion(); err != nil || v < data.LatestSchema() {
+		fmt.Fprintln(os.Stderr, "Data needs migrating first; run mu migrate")
+		return 1
+	}
+	auth.Load()
+	before := auth.RoleGuest
+	if acc, err := auth.GetAccount(account); err == nil {
+		before = acc.GetRole()
+	}
+	if err := auth.SetRole(account, role); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set role: %v\n", err)
+		return 1
+	}
+	audit.Record(audit.Event{
+		Actor:  "cli",
+		Action: "account.role",
+		Target: account,
+		Before: map[string]any{"role": before},
+		After:  map[string]any{"role": role},
+	})
+	fmt.Printf("%s is now %s\n", account, role)
+	return 0
+}
+
 // isStaticAsset returns true for requests that should bypass the loading gate
 // (CSS, JS, icons, manifest, and cached JSON blobs).
 func isStaticAsset(path string) bool {
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"mu/auth"
+	"mu/data"
+)
+
+func TestMain(m *testing.M) {
+	tmpDir, _ := os.MkdirTemp("", "mu_test_main")
+	originalHome := os.Getenv("HOME")
+	_ = os.Setenv("HOME", tmpDir)
+	code := m.Run()
+
+	data.FlushIndex()
+	_ = os.Setenv("HOME", originalHome)
+	os.RemoveAll(tmpDir)
+	os.Exit(code)
+}
+
+// TestCSRFRoutes checks every route that takes forms from signed in users
+// refuses a POST made with the session cookie alone.
+func TestCSRFRoutes(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoutes(mux, "")
+
+	if err := auth.Create(&auth.Account{ID: "csrfadmin", Name: "csrfadmin", Secret: "password123", Role: string(auth.RoleAdmin), Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auth.DeleteAccount("csrfadmin") })
+	sess, err := auth.Login("csrfadmin", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := sess.CSRF
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session", Value: sess.Token})
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{
+		"/chat",
+		"/posts",
+		"/post",
+		"/post/edit",
+		"/flag",
+		"/moderate",
+		"/admin",
+		"/admin/backup",
+		"/settings",
+		"/account",
+	} {
+		t.Run(path, func(t *testing.T) {
+			for name, form := range map[string]url.Values{
+				"missing": {},
+				"wrong":   {auth.CSRFField: {"wrong"}},
+			} {
+				rec := post(path, form)
+				if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "CSRF") {
+					t.Errorf("%s token: %d %q", name, rec.Code, rec.Body.String())
+				}
+			}
+			rec := post(path, url.Values{auth.CSRFField: {tk}})
+			if strings.Contains(rec.Body.String(), "CSRF") {
+				t.Errorf("valid token refused: %d %q", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
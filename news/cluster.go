@@ -0,0 +1,269 @@
+package news
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"strings"
+	"time"
+
+	"mu/data"
+)
+
+// ============================================
+// STORY CLUSTERING
+// ============================================
+
+// The same story often arrives from several feeds. Posts are grouped into
+// stories when their titles share most shingles (MinHash), their text is a
+// near duplicate (SimHash), or their embeddings are very close. Each story
+// is shown once, under its earliest report, with links to the others.
+const (
+	minhashSize     = 64
+	storyJaccard    = 0.5  // estimated title shingle overlap
+	storySimHash    = 3    // max differing SimHash bits for near-duplicate text
+	simhashMinTerms = 12   // shorter texts are too noisy for SimHash
+	storySimilarity = 0.85 // embedding cosine similarity
+	storyWindow     = 48 * time.Hour
+)
+
+// Coverage is another source's report of the same story.
+type Coverage struct {
+	Source string `json:"source"`
+	URL    string `json:"url"`
+}
+
+// storySignature is what clustering compares for one post.
+type storySignature struct {
+	minhash []uint64
+	simhash uint64
+	vec     []float64
+}
+
+// storyText is what identifies a post's story: its headline and summary.
+func storyText(p *Post) string {
+	return p.Title + " " + p.Description
+}
+
+// clusterStories groups posts that report the same story. Each post's
+// Story is set to the ID of the story's lead, its earliest report, and the
+// lead's Coverage lists the other sources. vecs holds an optional embedding
+// per post and may be nil. It returns the lead of each story by story ID.
+func clusterStories(posts []*Post, vecs [][]float64) map[string]*Post {
+	sigs := make([]storySignature, len(posts))
+	for i, p := range posts {
+		sigs[i] = storySignature{
+			minhash: minhash(titleShingles(p.Title)),
+			simhash: simhash(storyText(p)),
+		}
+		if i < len(vecs) {
+			sigs[i].vec = vecs[i]
+		}
+	}
+
+	parent := make([]int, len(posts))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range posts {
+		for j := i + 1; j < len(posts); j++ {
+			if find(i) != find(j) && sameStory(posts[i], posts[j], sigs[i], sigs[j]) {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := map[int][]*Post{}
+	var roots []int
+	for i, p := range posts {
+		r := find(i)
+		if _, ok := groups[r]; !ok {
+			roots = append(roots, r)
+		}
+		groups[r] = append(groups[r], p)
+	}
+
+	leads := make(map[string]*Post, len(roots))
+	for _, r := range roots {
+		members := groups[r]
+		lead := members[0]
+		for _, p := range members[1:] {
+			if p.PostedAt.Before(lead.PostedAt) {
+				lead = p
+			}
+		}
+
+		lead.Coverage = nil
+		seen := map[string]bool{lead.Source: true}
+		for _, p := range members {
+			p.Story = lead.ID
+			if p != lead {
+				p.Coverage = nil
+			}
+			if seen[p.Source] {
+				continue
+			}
+			seen[p.Source] = true
+			lead.Coverage = append(lead.Coverage, Coverage{Source: p.Source, URL: p.URL})
+		}
+		leads[lead.ID] = lead
+	}
+	return leads
+}
+
+// sameStory decides whether two posts report the same story.
+func sameStory(a, b *Post, sa, sb storySignature) bool {
+	gap := a.PostedAt.Sub(b.PostedAt)
+	if gap < 0 {
+		gap = -gap
+	}
+	if gap > storyWindow {
+		return false
+	}
+
+	if sa.simhash != 0 && sb.simhash != 0 && bits.OnesCount64(sa.simhash^sb.simhash) <= storySimHash {
+		return true
+	}
+	if jaccard(sa.minhash, sb.minhash) >= storyJaccard {
+		return true
+	}
+	return len(sa.vec) > 0 && len(sa.vec) == len(sb.vec) && cosine(sa.vec, sb.vec) >= storySimilarity
+}
+
+// titleShingles returns a title's stemmed terms and adjacent term pairs.
+func titleShingles(title string) []string {
+	terms := data.Terms(title)
+	shingles := append([]string{}, terms...)
+	for i := 1; i < len(terms); i++ {
+		shingles = append(shingles, terms[i-1]+" "+terms[i])
+	}
+	return shingles
+}
+
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// mix is the splitmix64 finaliser, used to derive the MinHash functions
+// from one base hash.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// minhash returns the MinHash signature of a set of shingles, or nil for
+// an empty set.
+func minhash(shingles []string) []uint64 {
+	if len(shingles) == 0 {
+		return nil
+	}
+	sig := make([]uint64, minhashSize)
+	for i := range sig {
+		sig[i] = math.MaxUint64
+	}
+	for _, s := range shingles {
+		base := hash64(s)
+		for i := range sig {
+			if h := mix(base + uint64(i)*0x9e3779b97f4a7c15); h < sig[i] {
+				sig[i] = h
+			}
+		}
+	}
+	return sig
+}
+
+// jaccard estimates the Jaccard similarity of two shingle sets from their
+// MinHash signatures.
+func jaccard(a, b []uint64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	same := 0
+	for i := range a {
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(a))
+}
+
+// simhash returns a 64-bit SimHash of text's terms, or 0 when there are too
+// few terms for it to mean anything.
+func simhash(text string) uint64 {
+	terms := data.Terms(text)
+	if len(terms) < simhashMinTerms {
+		return 0
+	}
+	var weights [64]int
+	for _, t := range terms {
+		h := hash64(t)
+		for b := 0; b < 64; b++ {
+			if h&(1<<b) != 0 {
+				weights[b]++
+			} else {
+				weights[b]--
+			}
+		}
+	}
+	var out uint64
+	for b, w := range weights {
+		if w > 0 {
+			out |= 1 << b
+		}
+	}
+	return out
+}
+
+func cosine(a, b []float64) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// storyVectors embeds each post's story text. It returns nil when
+// embeddings are unavailable, leaving clustering to the text signatures.
+func storyVectors(posts []*Post) [][]float64 {
+	texts := make([]string, len(posts))
+	for i, p := range posts {
+		texts[i] = storyText(p)
+	}
+	vecs, err := data.Embed(texts)
+	if err != nil {
+		return nil
+	}
+	return vecs
+}
+
+// renderCoverage lists the other sources that reported a story.
+func renderCoverage(p *Post) string {
+	if len(p.Coverage) == 0 {
+		return ""
+	}
+	links := make([]string, len(p.Coverage))
+	for i, c := range p.Coverage {
+		links[i] = fmt.Sprintf(`<a href="%s" rel="noopener noreferrer" target="_blank">%s</a>`, c.URL, c.Source)
+	}
+	return `<div class="coverage">Also covered by ` + strings.Join(links, " · ") + `</div>`
+}
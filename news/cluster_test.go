@@ -0,0 +1,88 @@
+package news
+
+import (
+	"math/bits"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClusterStories(t *testing.T) {
+	now := time.Now()
+	posts := []*Post{
+		{ID: "bbc", Source: "BBC", URL: "https://bbc.example/1", PostedAt: now.Add(-2 * time.Hour),
+			Title:       "Earthquake strikes southern Turkey, thousands evacuated",
+			Description: "A powerful earthquake struck southern Turkey early on Monday."},
+		{ID: "aj", Source: "Al Jazeera", URL: "https://aj.example/1", PostedAt: now.Add(-time.Hour),
+			Title:       "Thousands evacuated as earthquake strikes southern Turkey",
+			Description: "Rescue teams are searching collapsed buildings."},
+		{ID: "gdn", Source: "Guardian", URL: "https://gdn.example/1", PostedAt: now,
+			Title:       "Central bank holds interest rates steady",
+			Description: "Policy makers kept rates unchanged for a third month."},
+		{ID: "old", Source: "Guardian", URL: "https://gdn.example/0", PostedAt: now.Add(-10 * 24 * time.Hour),
+			Title:       "Earthquake strikes southern Turkey, thousands evacuated",
+			Description: "An older report."},
+	}
+
+	leads := clusterStories(posts, nil)
+
+	if posts[0].Story != "bbc" || posts[1].Story != "bbc" {
+		t.Errorf("same story not clustered: %q %q", posts[0].Story, posts[1].Story)
+	}
+	if posts[2].Story != "gdn" {
+		t.Errorf("unrelated story clustered into %q", posts[2].Story)
+	}
+	if posts[3].Story != "old" {
+		t.Errorf("story outside the time window clustered into %q", posts[3].Story)
+	}
+	if leads["bbc"] != posts[0] {
+		t.Error("earliest report should lead the story")
+	}
+
+	cov := posts[0].Coverage
+	if len(cov) != 1 || cov[0].Source != "Al Jazeera" || cov[0].URL != "https://aj.example/1" {
+		t.Errorf("unexpected coverage %+v", cov)
+	}
+	if len(posts[1].Coverage) != 0 {
+		t.Error("coverage should only be set on the lead")
+	}
+	if html := renderCoverage(posts[0]); !strings.Contains(html, "Also covered by") || !strings.Contains(html, "https://aj.example/1") {
+		t.Errorf("unexpected coverage html %q", html)
+	}
+}
+
+func TestClusterStoriesByEmbedding(t *testing.T) {
+	now := time.Now()
+	posts := []*Post{
+		{ID: "a", Source: "A", PostedAt: now, Title: "Markets rally on trade deal"},
+		{ID: "b", Source: "B", PostedAt: now, Title: "Stocks climb after tariff agreement"},
+		{ID: "c", Source: "C", PostedAt: now, Title: "Football final ends in penalties"},
+	}
+	vecs := [][]float64{{1, 0.1, 0}, {0.95, 0.15, 0}, {0, 0, 1}}
+
+	clusterStories(posts, vecs)
+	if posts[1].Story != posts[0].Story {
+		t.Error("close embeddings should share a story")
+	}
+	if posts[2].Story == posts[0].Story {
+		t.Error("distant embeddings should not share a story")
+	}
+}
+
+func TestSimHashNearDuplicates(t *testing.T) {
+	a := simhash("Earthquake strikes southern Turkey, thousands evacuated. A powerful earthquake struck southern Turkey early on Monday, collapsing buildings and forcing thousands of residents to flee their homes, officials said.")
+	b := simhash("Earthquake strikes southern Turkey, thousands evacuated. A powerful earthquake struck southern Turkey early on Monday, collapsing buildings and forcing thousands of people to flee their homes, officials said.")
+	c := simhash("Central bank holds interest rates steady. Policy makers kept rates unchanged for a third month as inflation eased slightly in the spring, the bank said on Thursday.")
+	if a == 0 || b == 0 {
+		t.Fatal("simhash unexpectedly empty")
+	}
+	if simhash("Too short to hash") != 0 {
+		t.Error("short text should not get a simhash")
+	}
+	if d := bits.OnesCount64(a ^ b); d > storySimHash {
+		t.Errorf("near duplicates differ in %d bits", d)
+	}
+	if d := bits.OnesCount64(a ^ c); d <= storySimHash {
+		t.Errorf("unrelated texts differ in only %d bits", d)
+	}
+}
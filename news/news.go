@@ -87,15 +87,31 @@ type Feed struct {
 }
 
 type Post struct {
-	ID          string    `json:"id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	URL         string    `json:"url"`
-	Published   string    `json:"published"`
-	Category    string    `json:"category"`
-	PostedAt    time.Time `json:"posted_at"`
-	Image       string    `json:"image"`
-	Content     string    `json:"content"`
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	URL         string     `json:"url"`
+	Published   string     `json:"published"`
+	Category    string     `json:"category"`
+	PostedAt    time.Time  `json:"posted_at"`
+	Image       string     `json:"image"`
+	Content     string     `json:"content"`
+	Source      string     `json:"source"`
+	Story       string     `json:"story,omitempty"`    // ID of the lead post of this story
+	Coverage    []Coverage `json:"coverage,omitempty"` // other sources, on the lead post only
+}
+
+// feedSection is one source's items within a category, rendered once
+// stories have been clustered.
+type feedSection struct {
+	category string
+	source   string
+	items    []*feedItem
+}
+
+type feedItem struct {
+	post *Post
+	guid string
 }
 
 type Metadata struct {
@@ -860,6 +876,7 @@ func parseFeed() {
 	// all the news
 	var news []*Post
 	var headlines []*Post
+	var sections []*feedSection
 
 	for _, category := range sorted {
 		catSources := feedSnapshot[category]
@@ -904,9 +921,8 @@ func parseFeed() {
 			status[feedID] = &stat
 			mutex.Unlock()
 
-			content = append(content, []byte(`<div class=section>`)...)
-			content = append(content, []byte(`<hr id="`+category+`" class="anchor">`)...)
-			content = append(content, []byte(`<h1>`+category+` - `+sourceName+`</h1>`)...)
+			section := &feedSection{category: category, source: sourceName}
+			sections = append(sections, section)
 
 			for i, item := range f.Items {
 				// only 10 items
@@ -991,29 +1007,58 @@ func parseFeed() {
 					Category:    category,
 					Image:       md.Image,
 					Content:     item.Content,
+					Source:      sourceName,
 				}
 
 				news = append(news, post)
+				section.items = append(section.items, &feedItem{post: post, guid: item.GUID})
+
+				if i > 0 {
+					continue
+				}
+
+				// add to headlines / 1 per category
+				headlines = append(headlines, post)
+			}
+		}
+	}
+
+	// group reports of the same story from different feeds
+	leads := clusterStories(news, storyVectors(news))
+
+	for _, post := range news {
+		// Index the article for search/RAG
+		data.Index(
+			post.ID,
+			"news",
+			post.Title,
+			post.Description+" "+post.Content,
+			map[string]interface{}{
+				"url":       post.URL,
+				"category":  post.Category,
+				"source":    post.Source,
+				"published": post.Published,
+				"image":     post.Image,
+				"story":     post.Story,
+			},
+		)
+	}
+
+	for _, section := range sections {
+		var items []byte
+
+		for _, fi := range section.items {
+			post := fi.post
+
+			// other reports are linked from the story's lead
+			if leads[post.Story] != post {
+				continue
+			}
 
-				// Index the article for search/RAG
-				data.Index(
-					itemID,
-					"news",
-					item.Title,
-					cleanDescription+" "+item.Content,
-					map[string]interface{}{
-						"url":       link,
-						"category":  category,
-						"source":    sourceName,
-						"published": item.Published,
-						"image":     md.Image,
-					},
-				)
-
-				var val string
-
-				if len(md.Image) > 0 {
-					val = fmt.Sprintf(`
+			var val string
+
+			if len(post.Image) > 0 {
+				val = fmt.Sprintf(`
 	<div id="%s" class="news">
 	  <div style="display: inline-block; width: 100%%;">
 	    <a href="%s" rel="noopener noreferrer" target="_blank" style="text-decoration: none;">
@@ -1026,10 +1071,10 @@ func parseFeed() {
 	      <div class="description collapsed" onclick="toggleDescription(this)">%s</div>
 	    </div>
 	  </div>
-	  <div style="font-size: 0.8em; margin-top: 5px; color: #777;">%s</div>
-				`, item.GUID, link, md.Image, link, item.Title, cleanDescription, getSummary(post))
-				} else {
-					val = fmt.Sprintf(`
+	  <div style="font-size: 0.8em; margin-top: 5px; color: #777;">%s</div>%s
+				`, fi.guid, post.URL, post.Image, post.URL, post.Title, post.Description, getSummary(post), renderCoverage(post))
+			} else {
+				val = fmt.Sprintf(`
 	<div id="%s" class="news">
 	  <div style="display: inline-block; width: 100%%;">
 	    <a href="%s" rel="noopener noreferrer" target="_blank" style="text-decoration: none;">
@@ -1042,25 +1087,25 @@ func parseFeed() {
 	      <div class="description collapsed" onclick="toggleDescription(this)">%s</div>
 	    </div>
 	  </div>
-	  <div style="font-size: 0.8em; margin-top: 5px; color: #777;">%s</div>
-				`, item.GUID, link, link, item.Title, cleanDescription, getSummary(post))
-				}
-
-				// close div
-				val += `</div>`
-
-				content = append(content, []byte(val)...)
+	  <div style="font-size: 0.8em; margin-top: 5px; color: #777;">%s</div>%s
+				`, fi.guid, post.URL, post.URL, post.Title, post.Description, getSummary(post), renderCoverage(post))
+			}
 
-				if i > 0 {
-					continue
-				}
+			// close div
+			val += `</div>`
 
-				// add to headlines / 1 per category
-				headlines = append(headlines, post)
-			}
+			items = append(items, []byte(val)...)
+		}
 
-			content = append(content, []byte(`</div>`)...)
+		if len(items) == 0 {
+			continue
 		}
+
+		content = append(content, []byte(`<div class=section>`)...)
+		content = append(content, []byte(`<hr id="`+section.category+`" class="anchor">`)...)
+		content = append(content, []byte(`<h1>`+section.category+` - `+section.source+`</h1>`)...)
+		content = append(content, items...)
+		content = append(content, []byte(`</div>`)...)
 	}
 
 	headline := []byte(`<div class=section>`)
@@ -1108,6 +1153,21 @@ func parseFeed() {
 		}
 	}
 
+	// one headline per story, shown as its lead
+	seenStories := map[string]bool{}
+	var storyHeadlines []*Post
+	for _, h := range headlines {
+		if seenStories[h.Story] {
+			continue
+		}
+		seenStories[h.Story] = true
+		if lead := leads[h.Story]; lead != nil {
+			h = lead
+		}
+		storyHeadlines = append(storyHeadlines, h)
+	}
+	headlines = storyHeadlines
+
 	// create the headlines
 	sort.Slice(headlines, func(i, j int) bool {
 		return headlines[i].PostedAt.After(headlines[j].PostedAt)
@@ -1121,8 +1181,8 @@ func parseFeed() {
 			   <span class="title">%s</span>
 			  </a>
 			 <div class="description collapsed" onclick="toggleDescription(this)" style="margin-top: 5px;">%s</div>
-			 <div style="font-size: 0.8em; margin-top: 5px; color: #777;">%s</div>
-			`, h.Category, h.Category, h.URL, h.Title, h.Description, getSummary(h))
+			 <div style="font-size: 0.8em; margin-top: 5px; color: #777;">%s</div>%s
+			`, h.Category, h.Category, h.URL, h.Title, h.Description, getSummary(h), renderCoverage(h))
 
 		// close val
 		val += `</div>`
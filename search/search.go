@@ -0,0 +1,368 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"mu/app"
+	"mu/data"
+)
+
+const (
+	pageSize      = 10
+	snippetBefore = 60
+	snippetLength = 240
+)
+
+// Tab is a result type shown as a tab on the search page.
+type Tab struct {
+	Type  string
+	Label string
+}
+
+// Tabs lists the searchable content types in display order. An empty type
+// means all content.
+var Tabs = []Tab{
+	{"", "All"},
+	{"news", "News"},
+	{"video", "Videos"},
+	{"market", "Markets"},
+	{"post", "Posts"},
+	{"user", "People"},
+}
+
+// Result is a single search hit as returned by the JSON API.
+type Result struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Title     string    `json:"title"`
+	Snippet   string    `json:"snippet"`
+	URL       string    `json:"url"`
+	Score     float64   `json:"score"`
+	Published time.Time `json:"published"`
+}
+
+// Response is the JSON body returned by /search.
+type Response struct {
+	Query   string         `json:"query"`
+	Type    string         `json:"type"`
+	Page    int            `json:"page"`
+	Pages   int            `json:"pages"`
+	Total   int            `json:"total"`
+	Counts  map[string]int `json:"counts"`
+	Results []*Result      `json:"results"`
+}
+
+var SearchTemplate = `
+<form id="search" action="/search" method="GET">
+  <input name="q" type="text" placeholder="Search news, videos, markets and posts" value="%s" autofocus>
+  <input name="type" type="hidden" value="%s">
+  <button>Search</button>
+</form>
+<div id="topics">%s</div>
+<div id="results">%s</div>
+<div id="pagination">%s</div>
+`
+
+// Handler serves the search page, or JSON when the request asks for it.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := strings.TrimSpace(q.Get("q"))
+	entryType := q.Get("type")
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	if !validType(entryType) {
+		entryType = ""
+	}
+
+	resp := run(query, entryType, page)
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	content := fmt.Sprintf(SearchTemplate,
+		html.EscapeString(query),
+		html.EscapeString(entryType),
+		renderTabs(resp),
+		renderResults(resp),
+		renderPagination(resp),
+	)
+
+	title := "Search"
+	if query != "" {
+		title = query + " | Search"
+	}
+	w.Write([]byte(app.RenderHTMLForRequest(title, "Search news, videos, markets and posts", content, r)))
+}
+
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json") ||
+		strings.Contains(r.Header.Get("Content-Type"), "application/json")
+}
+
+func validType(t string) bool {
+	for _, tab := range Tabs {
+		if tab.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// run queries the index for one page of results. Tab counts always cover
+// every type, so they come from an unfiltered search.
+func run(query, entryType string, page int) *Response {
+	resp := &Response{
+		Query:   query,
+		Type:    entryType,
+		Page:    page,
+		Counts:  map[string]int{},
+		Results: []*Result{},
+	}
+	if query == "" {
+		return resp
+	}
+
+	opts := data.SearchOptions{
+		Offset: (page - 1) * pageSize,
+		Limit:  pageSize,
+		Facets: []string{"type"},
+	}
+
+	all := data.SearchWith(query, opts)
+	resp.Counts = all.Facets["type"]
+	resp.Counts[""] = all.Total
+
+	found := all
+	if entryType != "" {
+		opts.Types = []string{entryType}
+		found = data.SearchWith(query, opts)
+	}
+
+	resp.Total = found.Total
+	resp.Pages = (found.Total + pageSize - 1) / pageSize
+
+	terms := termSet(query)
+	for _, res := range found.Results {
+		e := res.Entry
+		resp.Results = append(resp.Results, &Result{
+			ID:        e.ID,
+			Type:      e.Type,
+			Title:     e.Title,
+			Snippet:   snippet(passage(res), terms),
+			URL:       link(e),
+			Score:     res.Score,
+			Published: data.EntryTime(e),
+		})
+	}
+
+	return resp
+}
+
+// passage is the text a snippet is drawn from: the chunk that matched for
+// long content, otherwise the whole content.
+func passage(res data.SearchResult) string {
+	if res.Passage != "" {
+		return res.Passage
+	}
+	return res.Entry.Content
+}
+
+// link returns where a result points: its source URL when it has one,
+// otherwise the page that shows it.
+func link(e *data.IndexEntry) string {
+	if u, ok := e.Metadata["url"].(string); ok && u != "" {
+		return u
+	}
+	switch e.Type {
+	case "post":
+		return "/post?id=" + url.QueryEscape(strings.TrimPrefix(e.ID, "post_"))
+	case "market":
+		return "/news"
+	}
+	return ""
+}
+
+func termSet(query string) map[string]bool {
+	terms := map[string]bool{}
+	for _, t := range data.Terms(query) {
+		terms[t] = true
+	}
+	return terms
+}
+
+// matches reports whether a single word stems to one of the query terms.
+func matches(word string, terms map[string]bool) bool {
+	for _, t := range data.Terms(word) {
+		if terms[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// snippet returns a window of text around the first matching word.
+func snippet(text string, terms map[string]bool) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+
+	first := -1
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && matches(string(runes[start:i]), terms) {
+			first = start
+			break
+		}
+		start = -1
+	}
+
+	from := 0
+	if first > snippetBefore {
+		from = first - snippetBefore
+		// start on a word boundary
+		for from < first && runes[from-1] != ' ' {
+			from++
+		}
+	}
+	to := from + snippetLength
+	if to > len(runes) {
+		to = len(runes)
+	}
+
+	s := string(runes[from:to])
+	if from > 0 {
+		s = "…" + s
+	}
+	if to < len(runes) {
+		s += "…"
+	}
+	return s
+}
+
+// highlight escapes text for HTML and wraps words matching the query's
+// terms in <mark>.
+func highlight(text string, terms map[string]bool) string {
+	var b strings.Builder
+	var word []rune
+
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		w := html.EscapeString(string(word))
+		if matches(string(word), terms) {
+			w = "<mark>" + w + "</mark>"
+		}
+		b.WriteString(w)
+		word = word[:0]
+	}
+
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word = append(word, r)
+			continue
+		}
+		flush()
+		b.WriteString(html.EscapeString(string(r)))
+	}
+	flush()
+
+	return b.String()
+}
+
+func pageURL(query, entryType string, page int) string {
+	v := url.Values{}
+	v.Set("q", query)
+	if entryType != "" {
+		v.Set("type", entryType)
+	}
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	return "/search?" + v.Encode()
+}
+
+func renderTabs(resp *Response) string {
+	if resp.Query == "" {
+		return ""
+	}
+
+	var tabs string
+	for _, tab := range Tabs {
+		class := "head"
+		if tab.Type == resp.Type {
+			class += " active"
+		}
+		tabs += fmt.Sprintf(`<a href="%s" class="%s">%s (%d)</a>`,
+			html.EscapeString(pageURL(resp.Query, tab.Type, 1)), class, tab.Label, resp.Counts[tab.Type])
+	}
+	return tabs
+}
+
+func renderResults(resp *Response) string {
+	if resp.Query == "" {
+		return ""
+	}
+	if len(resp.Results) == 0 {
+		return `<p>No results found.</p>`
+	}
+
+	terms := termSet(resp.Query)
+
+	var out string
+	for _, res := range resp.Results {
+		title := highlight(res.Title, terms)
+		if title == "" {
+			title = "Untitled"
+		}
+		if res.URL != "" {
+			target := ""
+			if strings.HasPrefix(res.URL, "http") {
+				target = ` target="_blank" rel="noopener noreferrer"`
+			}
+			title = fmt.Sprintf(`<a href="%s"%s>%s</a>`, html.EscapeString(res.URL), target, title)
+		}
+
+		out += fmt.Sprintf(`
+<div class="search-result">
+  <h3>%s</h3>
+  <div class="info">%s · %s</div>
+  <p>%s</p>
+</div>`, title, html.EscapeString(res.Type), app.TimeAgo(res.Published), highlight(res.Snippet, terms))
+	}
+	return out
+}
+
+func renderPagination(resp *Response) string {
+	if resp.Pages <= 1 {
+		return ""
+	}
+
+	var links []string
+	if resp.Page > 1 {
+		links = append(links, fmt.Sprintf(`<a href="%s">&larr; Previous</a>`, html.EscapeString(pageURL(resp.Query, resp.Type, resp.Page-1))))
+	}
+	links = append(links, fmt.Sprintf(`<span>Page %d of %d</span>`, resp.Page, resp.Pages))
+	if resp.Page < resp.Pages {
+		links = append(links, fmt.Sprintf(`<a href="%s">Next &rarr;</a>`, html.EscapeString(pageURL(resp.Query, resp.Type, resp.Page+1))))
+	}
+	return strings.Join(links, " ")
+}
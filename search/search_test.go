@@ -0,0 +1,120 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"mu/data"
+)
+
+func TestMain(m *testing.M) {
+	tmpDir, _ := os.MkdirTemp("", "mu_test_search")
+	originalHome := os.Getenv("HOME")
+	_ = os.Setenv("HOME", tmpDir)
+	code := m.Run()
+
+	data.FlushIndex()
+	_ = os.Setenv("HOME", originalHome)
+	os.RemoveAll(tmpDir)
+	os.Exit(code)
+}
+
+func TestHighlight(t *testing.T) {
+	got := highlight("Bitcoin prices <dropped> today", termSet("bitcoin price drop"))
+	want := "<mark>Bitcoin</mark> <mark>prices</mark> &lt;<mark>dropped</mark>&gt; today"
+	if got != want {
+		t.Errorf("highlight = %q, want %q", got, want)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	text := strings.Repeat("filler words here ", 20) + "the bitcoin rally continued " + strings.Repeat("more ", 100)
+	s := snippet(text, termSet("bitcoin"))
+	if !strings.HasPrefix(s, "…") || !strings.HasSuffix(s, "…") || !strings.Contains(s, "bitcoin rally") {
+		t.Errorf("unexpected snippet %q", s)
+	}
+}
+
+func TestHandlerJSONAndPaging(t *testing.T) {
+	data.ClearIndex()
+	defer data.ClearIndex()
+
+	for i := 0; i < 12; i++ {
+		data.Index(fmt.Sprintf("n%d", i), "news", fmt.Sprintf("Bitcoin story %d", i), "Markets moved", map[string]interface{}{"url": "https://example.com"})
+	}
+	data.Index("v1", "video", "Bitcoin explained", "A video", nil)
+
+	get := func(query string) *Response {
+		req := httptest.NewRequest("GET", "/search?"+query, nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		Handler(rec, req)
+		var resp Response
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("bad JSON: %v", err)
+		}
+		return &resp
+	}
+
+	resp := get("q=bitcoin")
+	if resp.Total != 13 || resp.Pages != 2 || len(resp.Results) != pageSize {
+		t.Errorf("total=%d pages=%d results=%d", resp.Total, resp.Pages, len(resp.Results))
+	}
+	if resp.Counts["news"] != 12 || resp.Counts["video"] != 1 || resp.Counts[""] != 13 {
+		t.Errorf("counts = %v", resp.Counts)
+	}
+
+	resp = get("q=bitcoin&type=news&page=2")
+	if resp.Total != 12 || len(resp.Results) != 2 || resp.Counts["video"] != 1 {
+		t.Errorf("page 2: total=%d results=%d counts=%v", resp.Total, len(resp.Results), resp.Counts)
+	}
+	for _, r := range resp.Results {
+		if r.Type != "news" || r.URL != "https://example.com" {
+			t.Errorf("unexpected result %+v", r)
+		}
+	}
+
+	if resp = get("q=bitcoin&type=bogus"); resp.Type != "" || resp.Total != 13 {
+		t.Errorf("unknown type should search everything, got %q with %d", resp.Type, resp.Total)
+	}
+}
+
+func TestHandlerHTML(t *testing.T) {
+	data.ClearIndex()
+	defer data.ClearIndex()
+	data.Index("n1", "news", "Bitcoin <b>rallies</b>", "Prices up", nil)
+
+	rec := httptest.NewRecorder()
+	Handler(rec, httptest.NewRequest("GET", "/search?q=rally", nil))
+
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if !strings.Contains(body, "&lt;b&gt;<mark>rallies</mark>&lt;/b&gt;") {
+		t.Error("expected escaped, highlighted title")
+	}
+	if !strings.Contains(body, `class="head active"`) || !strings.Contains(body, "News (1)") {
+		t.Error("expected type tabs with counts")
+	}
+}
+
+func TestHandlerEscapesTitle(t *testing.T) {
+	q := url.QueryEscape("</title><script>alert(1)</script>")
+	rec := httptest.NewRecorder()
+	Handler(rec, httptest.NewRequest("GET", "/search?q="+q, nil))
+
+	body := rec.Body.String()
+	if strings.Contains(body, "<script>alert(1)</script>") {
+		t.Fatal("query rendered as markup")
+	}
+	if !strings.Contains(body, "<title>&lt;/title&gt;&lt;script&gt;alert(1)&lt;/script&gt; | Search | Mu</title>") {
+		t.Error("expected the escaped query in the title")
+	}
+}
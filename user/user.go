@@ -4,12 +4,65 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"mu/app"
 	"mu/auth"
 	"mu/blog"
+	"mu/data"
 )
 
+// Load indexes every profile for search and keeps the index in sync as
+// accounts are created, renamed and deleted.
+func Load() {
+	auth.OnAccountChange(func(acc *auth.Account, deleted bool) {
+		if deleted {
+			data.Delete("user_" + acc.ID)
+			return
+		}
+		go reindexProfile(acc.ID)
+	})
+
+	go func() {
+		known := map[string]bool{}
+		for _, acc := range auth.GetAllAccounts() {
+			known["user_"+acc.ID] = true
+			indexProfile(acc)
+		}
+		for _, entry := range data.GetByType("user", 0) {
+			if !known[entry.ID] {
+				data.Delete(entry.ID)
+			}
+		}
+	}()
+}
+
+// reindexProfile indexes an account's current profile, or drops it if the
+// account has been deleted meanwhile. It runs outside the request that
+// changed the account, so signups and edits do not wait on embeddings.
+func reindexProfile(id string) {
+	acc, err := auth.GetAccount(id)
+	if err != nil {
+		data.Delete("user_" + id)
+		return
+	}
+	indexProfile(acc)
+}
+
+// indexProfile adds a user's profile to the search index. Accounts awaiting
+// approval are kept out until an admin approves them.
+func indexProfile(acc *auth.Account) {
+	if acc.Pending {
+		data.Delete("user_" + acc.ID)
+		return
+	}
+	data.Index("user_"+acc.ID, "user", acc.Name, acc.Name+" @"+acc.ID, map[string]interface{}{
+		"url":       "/@" + acc.ID,
+		"author_id": acc.ID,
+		"published": acc.Created.Format(time.RFC3339),
+	})
+}
+
 // Profile handler renders a user profile page at /@username
 func Profile(w http.ResponseWriter, r *http.Request) {
 	// Extract username from URL path (remove /@ prefix)